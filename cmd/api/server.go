@@ -1,59 +1,245 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"schoolapi/internal/api/handlers"
 	mw "schoolapi/internal/api/middlewares"
 	"schoolapi/internal/api/router"
+	"schoolapi/internal/config"
+	"schoolapi/internal/metrics"
+	"schoolapi/internal/models"
 	"schoolapi/internal/repository/sqlconnect"
+	"schoolapi/internal/store"
+	"schoolapi/internal/tracing"
 	"schoolapi/pkg/utils"
-	"time"
+	"slices"
+	"syscall"
 
 	"github.com/joho/godotenv"
 )
 
 func main() {
 
-	err := godotenv.Load()
-	if err != nil {
+	// a .env file is optional now, real env vars, a config file and flags all work too
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		log.Fatal("Error loading env vars", err)
 	}
-	_, err = sqlconnect.ConnectDB()
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	// a typo in a validate tag stops startup instead of failing the requests that use it
+	if err := utils.CheckTags(models.Exec{}, models.Student{}, models.Teacher{}); err != nil {
+		log.Fatal(err)
+	}
+
+	// the level can be changed on reload, everything logged through the log package ends up here too
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(cfg.Log.SlogLevel())
+	slog.SetDefault(utils.NewLogger(os.Stdout, logLevel, cfg.Log.Format))
 
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
 	if err != nil {
-		log.Fatal("Error connecting to the DB:", err)
-		return
+		fatal("Error setting up tracing", err)
 	}
 
-	port := os.Getenv("API_PORT")
-	cert := "cert.pem"
-	key := "key.pem"
+	db, err := sqlconnect.Open(cfg.Database)
+	if err != nil {
+		fatal("Error connecting to the DB", err)
+	}
+	if err := metrics.RegisterDB(db.DB); err != nil {
+		fatal("Error registering the DB metrics", err)
+	}
 
 	tlsConfig := &tls.Config{
 		MinVersion: tls.VersionTLS13,
 	}
 
-	rl := mw.NewRateLimiter(5, time.Minute)
+	sharedStore, err := store.New(cfg.Server.RedisURL)
+	if err != nil {
+		fatal("Error connecting to the shared store", err)
+	}
+	h := handlers.New(cfg, db, sharedStore)
+
+	rl := mw.NewRateLimiter(rateLimiterOptions(cfg.RateLimit, sharedStore))
+	cors := mw.NewCors(corsOptions(cfg.CORS))
+
+	// rate limits and CORS can be changed without a restart, send the process a SIGHUP
+	stopReload := config.OnReload(os.Args[1:], func(c *config.Config) {
+		rl.Update(rateLimiterOptions(c.RateLimit, sharedStore))
+		cors.Update(corsOptions(c.CORS))
+		logLevel.Set(c.Log.SlogLevel())
+	})
+
+	// credential endpoints only ever receive a few small fields
+	bodyLimitOptions := mw.BodyLimitOptions{
+		Default: cfg.Security.MaxBodyBytes,
+		Routes: []mw.RouteBodyLimit{
+			{Prefix: "/execs/login", Limit: 16 << 10},
+			{Prefix: "/execs/forgot-password", Limit: 16 << 10},
+			{Prefix: "/execs/reset-password", Limit: 16 << 10},
+		},
+	}
+
+	compressionOptions := mw.CompressionOptions{
+		MinSize:      cfg.Compression.MinSize,
+		ContentTypes: cfg.Compression.ContentTypes,
+	}
 
 	HPPOptions := mw.HPPOptions{
 		CheckQuery:                  true,
 		CheckBody:                   true,
 		CheckBodyOnlyForContentType: "application/x-www-form-urlencoded",
-		WhiteList:                   []string{"sort_by", "name", "age", "class"},
+		CheckJSONBody:               true,
+		MultiValue:                  mw.HPPMultiValue(cfg.Security.HPPMultiValue),
+		Routes:                      router.HPPRoutes(),
 	}
 
-	jwtMiddleware := mw.ExcludePaths(mw.JWT, "/execs/login", "/execs/forgot-password", "/execs/reset-password/reset")
-	secureMux := utils.ApplyMiddleware(router.MainRouter(), mw.SecurityHeaders, mw.Compression, mw.Hpp(HPPOptions), mw.XSS, jwtMiddleware, mw.ResponseTime, rl.Middleware, mw.Cors)
+	publicPaths := append(slices.Clone(cfg.Auth.PublicPaths), router.ProbePaths...)
+	jwtMiddleware := mw.ExcludePaths(mw.JWT(cfg.Auth, sharedStore, db), publicPaths...)
+	mux := router.MainRouter(cfg, h)
+	// every stage gets its own span inside the request's, see tracing.Stage
+	secureMux := utils.ApplyMiddleware(mux,
+		tracing.Handler,
+		tracing.Stage("cache", mw.Cache(mw.CacheOptions{Routes: router.CachePolicies()})),
+		tracing.Stage("security_headers", mw.SecurityHeaders),
+		tracing.Stage("xss", mw.XSS(xssOptions(cfg.Security))),
+		tracing.Stage("hpp", mw.ExcludePaths(mw.Hpp(HPPOptions), router.ProbePaths...)),
+		tracing.Stage("compression", mw.Compression(compressionOptions)),
+		tracing.Stage("body_limit", mw.BodyLimit(bodyLimitOptions)),
+		tracing.Stage("csrf", mw.CSRF(cfg.Auth)),
+		tracing.Stage("rate_limit", mw.ExcludePaths(rl.Middleware, router.ProbePaths...)),
+		tracing.Stage("jwt", jwtMiddleware),
+		tracing.Stage("response_time", mw.ResponseTime),
+		tracing.Stage("cors", cors.Middleware),
+		tracing.Stage("metrics", mw.Metrics),
+		tracing.Stage("request_id", mw.RequestID),
+		tracing.Stage("logging", mw.Logging),
+		tracing.Server,
+		router.Route(mux),
+		mw.RealIP(cfg.Server.TrustedProxies),
+	)
 
+	// without timeouts a client sending headers or reading the response slowly holds its
+	// connection forever
 	server := &http.Server{
-		Addr:      port,
-		Handler:   secureMux,
-		TLSConfig: tlsConfig,
+		Addr:              cfg.Server.Port,
+		Handler:           secureMux,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+	servers := []*http.Server{server}
+	serverErrs := make(chan error, 2)
+
+	// scrapers on the internal network get the metrics without credentials
+	if cfg.Metrics.Addr != "" {
+		adminMux := http.NewServeMux()
+		adminMux.Handle("GET /metrics", metrics.Handler())
+		metricsServer := &http.Server{
+			Addr:              cfg.Metrics.Addr,
+			Handler:           adminMux,
+			ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		}
+		servers = append(servers, metricsServer)
+		go func() {
+			slog.Info("Metrics listener running", "addr", cfg.Metrics.Addr)
+			serverErrs <- fmt.Errorf("metrics listener: %w", metricsServer.ListenAndServe())
+		}()
 	}
 
-	fmt.Println("Server running on port ", port)
-	log.Fatal(server.ListenAndServeTLS(cert, key))
+	go func() {
+		slog.Info("Server running", "port", cfg.Server.Port)
+		serverErrs <- server.ListenAndServeTLS(cfg.Server.CertFile, cfg.Server.KeyFile)
+	}()
+
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	exitCode := 0
+	select {
+	case <-signals.Done():
+		slog.Info("Shutting down", "timeout", cfg.Server.ShutdownTimeout.String())
+	case err := <-serverErrs:
+		slog.Error("Server stopped", "error", err)
+		exitCode = 1
+	}
+	// a second signal kills the process without waiting for the drain
+	stopSignals()
+
+	// stop accepting connections and let in-flight requests finish, mail is sent within the
+	// request so it is drained along with it
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	for _, s := range servers {
+		if err := s.Shutdown(ctx); err != nil {
+			slog.Error("Requests still running at the shutdown deadline", "addr", s.Addr, "error", err)
+			exitCode = 1
+		}
+	}
+
+	stopReload()
+	if err := sharedStore.Close(); err != nil {
+		slog.Error("Error closing the shared store", "error", err)
+	}
+	// sends the spans still buffered in the batcher
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
+	if err := db.Close(); err != nil {
+		slog.Error("Error closing the DB pool", "error", err)
+	}
+	slog.Info("Server stopped")
+	// the logger writes straight to stdout, this only matters when it is redirected to a file
+	os.Stdout.Sync()
+	os.Exit(exitCode)
+}
+
+func fatal(message string, err error) {
+	slog.Error(message, "error", err)
+	os.Exit(1)
+}
+
+func rateLimiterOptions(cfg config.RateLimitConfig, s store.Store) mw.RateLimiterOptions {
+	options := mw.RateLimiterOptions{Store: s, Default: rateLimitPolicy(cfg.Default)}
+	for _, route := range cfg.Routes {
+		options.Routes = append(options.Routes, mw.RouteRateLimit{Method: route.Method, Prefix: route.Prefix, Policy: rateLimitPolicy(route.Policy)})
+	}
+	return options
+}
+
+func rateLimitPolicy(p config.RateLimitPolicy) mw.RateLimitPolicy {
+	return mw.RateLimitPolicy{Name: p.Name, Limit: p.Limit, Window: p.Window}
+}
+
+func xssOptions(cfg config.SecurityConfig) mw.XSSOptions {
+	options := mw.XSSOptions{Default: mw.XSSAction(cfg.XSSDefaultAction)}
+	for _, route := range cfg.XSSRoutes {
+		fields := make(map[string]mw.XSSAction, len(route.Fields))
+		for field, action := range route.Fields {
+			fields[field] = mw.XSSAction(action)
+		}
+		options.Routes = append(options.Routes, mw.XSSRoute{Method: route.Method, Prefix: route.Prefix, Action: mw.XSSAction(route.Action), Fields: fields})
+	}
+	return options
+}
+
+func corsOptions(cfg config.CORSConfig) mw.CorsOptions {
+	return mw.CorsOptions{
+		AllowedOrigins:   cfg.AllowedOrigins,
+		AllowedMethods:   cfg.AllowedMethods,
+		AllowedHeaders:   cfg.AllowedHeaders,
+		ExposedHeaders:   cfg.ExposedHeaders,
+		AllowCredentials: cfg.AllowCredentials,
+		MaxAge:           cfg.MaxAge,
+	}
 }
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"schoolapi/internal/models"
+	"schoolapi/pkg/utils"
+	"strconv"
+)
+
+func (h *Handlers) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorize(r, "apikeys:manage"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	keys, err := h.db.GetAPIKeysDB(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Status string          `json:"status"`
+		Count  int             `json:"count"`
+		Data   []models.APIKey `json:"data"`
+	}{"success", len(keys), keys}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
+	}
+}
+
+func (h *Handlers) GetAPIKey(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorize(r, "apikeys:manage"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid API key id", http.StatusBadRequest)
+		return
+	}
+
+	key, err := h.db.GetAPIKeyDB(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(key); err != nil {
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
+	}
+}
+
+// AddAPIKey creates a key and returns it in clear. This is the only time the key is shown.
+func (h *Handlers) AddAPIKey(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorize(r, "apikeys:manage"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	var newKey models.APIKey
+	if !h.readJSON(w, r, &newKey) {
+		return
+	}
+
+	key, err := h.db.CreateAPIKeyDB(r.Context(), newKey, currentUsername(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(key); err != nil {
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
+	}
+}
+
+func (h *Handlers) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorize(r, "apikeys:manage"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid API key id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.RevokeAPIKeyDB(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	response := struct {
+		Status string `json:"status"`
+		ID     int    `json:"id"`
+	}{"API key successfully revoked", id}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		utils.Logger(r.Context()).Error("Error encoding response data", "error", err)
+	}
+}
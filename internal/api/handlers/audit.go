@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"schoolapi/internal/models"
+	"schoolapi/pkg/utils"
+	"strconv"
+	"time"
+)
+
+// GetAuditLog lists changes newest first. It filters by entity (and entity_id), actor and a
+// from/to time range, both RFC 3339 and to exclusive.
+func (h *Handlers) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorize(r, "audit:read"); err != nil {
+		utils.WriteProblem(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+	filter := models.AuditFilter{
+		Entity: query.Get("entity"),
+		Actor:  query.Get("actor"),
+	}
+	var errs utils.ValidationErrors
+	if raw := query.Get("entity_id"); raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil {
+			errs = append(errs, utils.FieldError{Field: "entity_id", Message: "must be a number"})
+		}
+		filter.EntityID = id
+	}
+	for _, param := range []struct {
+		name string
+		dest *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		raw := query.Get(param.name)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			errs = append(errs, utils.FieldError{Field: param.name, Message: "must be an RFC 3339 timestamp"})
+		}
+		*param.dest = t
+	}
+	if len(errs) > 0 {
+		utils.WriteValidationProblem(w, errs)
+		return
+	}
+
+	page, limit := getPaginationParams(r)
+	entries, totalCount, err := h.db.GetAuditLogDB(r.Context(), filter, limit, page)
+	if err != nil {
+		utils.WriteProblem(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response := struct {
+		Status     string              `json:"status"`
+		TotalCount int                 `json:"total_count"`
+		Page       int                 `json:"page"`
+		Limit      int                 `json:"limit"`
+		Data       []models.AuditEntry `json:"data"`
+	}{"success", totalCount, page, limit, entries}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
+	}
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"schoolapi/internal/auth"
+	"schoolapi/internal/config"
+	"schoolapi/internal/repository/sqlconnect"
+	"schoolapi/internal/store"
+)
+
+// Handlers holds what the handlers need from the config and the services main sets up, the
+// routes are its methods
+type Handlers struct {
+	cfg *config.Config
+	db  *sqlconnect.DB
+	// authenticators is the chain Login runs through: local argon2 first, then LDAP
+	authenticators auth.Chain
+	oidc           *auth.OIDCProvider
+	revocations    store.Store
+}
+
+// New sets up the login providers, main calls it once at startup
+func New(cfg *config.Config, db *sqlconnect.DB, revocations store.Store) *Handlers {
+	return &Handlers{
+		cfg:            cfg,
+		db:             db,
+		authenticators: auth.NewChain(cfg.Auth, cfg.LDAP, db),
+		oidc:           auth.NewOIDCProvider(cfg.OIDC),
+		revocations:    revocations,
+	}
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"schoolapi/pkg/utils"
+)
+
+var errTrailingJSON = errors.New("request body must contain a single JSON value")
+
+// decodeJSON decodes exactly one JSON value from the body. Bodies over mw.BodyLimit's cap
+// fail with *http.MaxBytesError. SCIM passes strict=false, clients send attributes we don't model.
+func (h *Handlers) decodeJSON(r *http.Request, dst any, strict bool) error {
+	decoder := json.NewDecoder(r.Body)
+	// strict mode rejects fields the target struct doesn't know
+	if strict && h.cfg.Security.StrictJSON {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(dst); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errTrailingJSON
+	}
+	return nil
+}
+
+// readJSON decodes the body strictly and answers with a problem response when it can't
+func (h *Handlers) readJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	err := h.decodeJSON(r, dst, true)
+	if err == nil {
+		return true
+	}
+	utils.WriteProblem(w, bodyErrorStatus(err), bodyErrorDetail(err))
+	return false
+}
+
+func bodyErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+func bodyErrorDetail(err error) string {
+	var tooLarge *http.MaxBytesError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &tooLarge):
+		return fmt.Sprintf("request body must not exceed %d bytes", tooLarge.Limit)
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("invalid value for field %q", typeErr.Field)
+	case errors.Is(err, io.EOF):
+		return "request body must not be empty"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "malformed JSON"
+	}
+	// DisallowUnknownFields reports `json: unknown field "x"`, which is already readable
+	return err.Error()
+}
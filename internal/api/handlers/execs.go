@@ -2,19 +2,19 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"schoolapi/internal/metrics"
 	"schoolapi/internal/models"
-	"schoolapi/internal/repository/sqlconnect"
 	"schoolapi/pkg/utils"
 	"strconv"
 	"time"
 )
 
-func GetExecs(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) GetExecs(w http.ResponseWriter, r *http.Request) {
 	var execs []models.Exec
-	execs, err := sqlconnect.GetExecsDB(execs, r)
+	execs, err := h.db.GetExecsDB(execs, r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -28,12 +28,12 @@ func GetExecs(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("JSON encoding error: %v", err)
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
 		return
 	}
 }
 
-func GetExec(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) GetExec(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 
 	id, err := strconv.Atoi(idStr)
@@ -42,28 +42,38 @@ func GetExec(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	exec, err := sqlconnect.GetExecDB(id)
+	exec, err := h.db.GetExecDB(r.Context(), id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("ETag", versionETag(exec.Version))
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(exec); err != nil {
-		log.Printf("JSON encoding error: %v", err)
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
 		return
 	}
 }
 
-func AddExecs(w http.ResponseWriter, r *http.Request) {
+// AddExecs needs execs:manage, the body sets the role each exec is created with
+func (h *Handlers) AddExecs(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorize(r, "execs:manage"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 
 	var newExecs []models.Exec
-	if err := json.NewDecoder(r.Body).Decode(&newExecs); err != nil {
-		http.Error(w, "Invalid request body: ", http.StatusBadRequest)
+	if !h.readJSON(w, r, &newExecs) {
+		return
+	}
+
+	if errs := utils.ValidateSlice(newExecs, "data"); len(errs) > 0 {
+		utils.WriteValidationProblem(w, errs)
 		return
 	}
 
-	addedExecs, err := sqlconnect.AddExecsDB(newExecs)
+	addedExecs, err := h.db.AddExecsDB(r.Context(), newExecs, currentUsername(r))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -82,46 +92,73 @@ func AddExecs(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func PatchExec(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) PatchExec(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		log.Printf("Error converting exec's id string to int: %v", err)
+		utils.Logger(r.Context()).Error("Error converting exec's id string to int", "error", err)
 		http.Error(w, "Invalid exec id", http.StatusBadRequest)
 		return
 	}
 
 	var updates map[string]any
-	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
-		log.Printf("Error decoding json data: %v", err)
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	if !h.readJSON(w, r, &updates) {
 		return
 	}
 
-	existingExec, err := sqlconnect.PatchExecDB(id, updates)
-	if err != nil {
+	if _, ok := updates["role"]; ok {
+		if err := h.authorize(r, "execs:manage"); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	version, ok := expectedVersion(w, r, popVersion(updates))
+	if !ok {
+		return
+	}
+
+	existingExec, err := h.db.PatchExecDB(r.Context(), id, version, updates, currentUsername(r))
+	if writeVersionConflict(w, r, err) {
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	w.Header().Set("ETag", versionETag(existingExec.Version))
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(existingExec); err != nil {
-		log.Printf("JSON encoding error: %v", err)
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
 		return
 	}
 }
 
-func PatchExecs(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) PatchExecs(w http.ResponseWriter, r *http.Request) {
 
 	var updates []map[string]any
-	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
-		log.Printf("Error decoding json: %v", err)
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	if !h.readJSON(w, r, &updates) {
+		return
+	}
+	if errs := requireVersions(updates); len(errs) > 0 {
+		utils.WriteValidationProblem(w, errs)
 		return
 	}
 
-	err := sqlconnect.PatchExecsDB(updates)
-	if err != nil {
+	for _, update := range updates {
+		if _, ok := update["role"]; ok {
+			if err := h.authorize(r, "execs:manage"); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			break
+		}
+	}
+
+	err := h.db.PatchExecsDB(r.Context(), updates, currentUsername(r))
+	if writeVersionConflict(w, r, err) {
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -129,16 +166,16 @@ func PatchExecs(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func DeleteExec(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) DeleteExec(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		log.Printf("Error converting exec's id string to int: %v", err)
+		utils.Logger(r.Context()).Error("Error converting exec's id string to int", "error", err)
 		http.Error(w, "Invalid exec id", http.StatusBadRequest)
 		return
 	}
 
-	err = sqlconnect.DeleteExecDB(id)
+	err = h.db.DeleteExecDB(r.Context(), id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -150,19 +187,100 @@ func DeleteExec(w http.ResponseWriter, r *http.Request) {
 	}{"Exec successfully deleted", id}
 
 	if err = json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error encoding response data: %v", err)
+		utils.Logger(r.Context()).Error("Error encoding response data", "error", err)
 		http.Error(w, "Server error", http.StatusInternalServerError)
 		return
 	}
 
 }
 
-func Login(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) ActivateExec(w http.ResponseWriter, r *http.Request) {
+	h.setExecStatus(w, r, false)
+}
+
+func (h *Handlers) DeactivateExec(w http.ResponseWriter, r *http.Request) {
+	h.setExecStatus(w, r, true)
+}
+
+func (h *Handlers) setExecStatus(w http.ResponseWriter, r *http.Request, inactive bool) {
+	if err := h.authorize(r, "execs:manage"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		utils.Logger(r.Context()).Error("Error converting exec's id string to int", "error", err)
+		http.Error(w, "Invalid exec id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.SetExecStatusDB(r.Context(), id, inactive); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if inactive {
+		sessionIds, err := h.db.RevokeExecSessionsDB(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, sessionId := range sessionIds {
+			h.denylistSession(r, sessionId)
+		}
+	}
+
+	response := struct {
+		Status         string `json:"status"`
+		ID             int    `json:"id"`
+		StatusInactive bool   `json:"status_inactive"`
+	}{"success", id, inactive}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
+		return
+	}
+}
+
+func (h *Handlers) GetExecRoleHistory(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorize(r, "execs:manage"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid exec id", http.StatusBadRequest)
+		return
+	}
+
+	changes, err := h.db.GetRoleChangesDB(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Status string              `json:"status"`
+		Count  int                 `json:"count"`
+		Data   []models.RoleChange `json:"data"`
+	}{"success", len(changes), changes}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
+		return
+	}
+}
+
+func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
 	var req models.Exec
 
 	//validate request data
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "username and password are required", http.StatusBadRequest)
+	if !h.readJSON(w, r, &req) {
 		return
 	}
 	r.Body.Close()
@@ -174,21 +292,46 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := sqlconnect.GetUserByUsername(w, req)
+	chain := h.authenticators
+	if len(chain) == 0 {
+		http.Error(w, "password login is disabled, please sign in with single sign-on", http.StatusForbidden)
+		return
+	}
+
+	user, err := chain.Authenticate(r.Context(), req.Username, req.Password)
 	if err != nil {
-		http.Error(w, "invalid username or password", http.StatusInternalServerError)
+		h.recordLoginAttempt(r, req.Username, false, err.Error())
+		metrics.AuthFailures.WithLabelValues("login_failed").Inc()
+		http.Error(w, "invalid username or password", http.StatusUnauthorized)
 		return
 	}
 
-	if err := utils.VerifyPassword(req.Password, user.Password); err != nil {
+	h.recordLoginAttempt(r, req.Username, true, "")
+
+	tokenString, err := h.issueToken(w, r, user.ID, user.Username, user.Role)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	tokenString, err := utils.SignToken(strconv.Itoa(user.ID), req.Username, user.Role)
+	response := struct {
+		Token string `json:"token"`
+	}{tokenString}
+
+	json.NewEncoder(w).Encode(response)
+
+}
+
+// issueToken opens a session for the exec, signs a token for it and sets the Bearer and CSRF cookies
+func (h *Handlers) issueToken(w http.ResponseWriter, r *http.Request, execId int, username, role string) (string, error) {
+	sessionId, err := h.db.CreateSessionDB(r.Context(), execId, utils.ClientIP(r), r.UserAgent())
+	if err != nil {
+		return "", errors.New("failed to create session")
+	}
+
+	tokenString, err := utils.SignToken([]byte(h.cfg.Auth.JWTSecret), h.cfg.Auth.JWTExpiresIn, strconv.Itoa(execId), username, role, sessionId)
 	if err != nil {
-		http.Error(w, "failed to create authorization token", http.StatusInternalServerError)
-		return
+		return "", errors.New("failed to create authorization token")
 	}
 
 	http.SetCookie(w, &http.Cookie{
@@ -198,18 +341,37 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		HttpOnly: true,
 		Secure:   true,
 		Expires:  time.Now().Add(time.Hour * 24),
-		SameSite: http.SameSiteStrictMode,
+		SameSite: h.cfg.Auth.SameSite(),
 	})
 
-	response := struct {
-		Token string `json:"token"`
-	}{tokenString}
-
-	json.NewEncoder(w).Encode(response)
+	// double submit: the page reads the cookie and echoes it in X-CSRF-Token (see mw.CSRF)
+	csrfToken := utils.CSRFToken([]byte(h.cfg.Auth.JWTSecret), sessionId)
+	http.SetCookie(w, &http.Cookie{
+		Name:     "csrf_token",
+		Value:    csrfToken,
+		Path:     "/",
+		Secure:   true,
+		Expires:  time.Now().Add(time.Hour * 24),
+		SameSite: h.cfg.Auth.SameSite(),
+	})
+	w.Header().Set("X-CSRF-Token", csrfToken)
+	return tokenString, nil
+}
 
+func (h *Handlers) recordLoginAttempt(r *http.Request, username string, success bool, reason string) {
+	if err := h.db.RecordLoginAttemptDB(r.Context(), username, utils.ClientIP(r), r.UserAgent(), success, reason); err != nil {
+		utils.Logger(r.Context()).Error("Error recording login attempt", "error", err)
+	}
 }
 
-func Logout(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) Logout(w http.ResponseWriter, r *http.Request) {
+	if userId, err := currentUserID(r); err == nil {
+		if err := h.db.RevokeSessionDB(r.Context(), userId, currentSessionID(r)); err != nil {
+			utils.Logger(r.Context()).Error("Error revoking session on logout", "error", err)
+		}
+		h.denylistSession(r, currentSessionID(r))
+	}
+
 	http.SetCookie(w, &http.Cookie{
 		Name:     "Bearer",
 		Value:    "",
@@ -217,20 +379,26 @@ func Logout(w http.ResponseWriter, r *http.Request) {
 		HttpOnly: true,
 		Secure:   true,
 		Expires:  time.Unix(0, 0),
-		SameSite: http.SameSiteStrictMode,
+		SameSite: h.cfg.Auth.SameSite(),
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     "csrf_token",
+		Value:    "",
+		Path:     "/",
+		Secure:   true,
+		Expires:  time.Unix(0, 0),
+		SameSite: h.cfg.Auth.SameSite(),
 	})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(`{"message": "logged out successfully"}`))
 }
 
-func UpdatePassword(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) UpdatePassword(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 	var req models.UpdatePasswordRequest
 
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !h.readJSON(w, r, &req) {
 		return
 	}
 	defer r.Body.Close()
@@ -240,27 +408,30 @@ func UpdatePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	username, userRole, err := sqlconnect.UpdatePasswordDB(idStr, req.CurrentPassword, req.NewPassword)
+	username, userRole, err := h.db.UpdatePasswordDB(r.Context(), idStr, req.CurrentPassword, req.NewPassword)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	token, err := utils.SignToken(idStr, username, userRole)
+	// a password change signs out every other device
+	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, "Invalid exec id", http.StatusBadRequest)
+		return
+	}
+	sessionIds, err := h.db.RevokeExecSessionsDB(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, sessionId := range sessionIds {
+		h.denylistSession(r, sessionId)
+	}
+	if _, err := h.issueToken(w, r, id, username, userRole); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	http.SetCookie(w, &http.Cookie{
-		Name:     "Bearer",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		Expires:  time.Now().Add(time.Hour * 24),
-		SameSite: http.SameSiteStrictMode,
-	})
 
 	response := struct {
 		Message string `json:"message"`
@@ -269,12 +440,11 @@ func UpdatePassword(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) ForgotPassword(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Email string `json:"email"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+	if !h.readJSON(w, r, &req) {
 		return
 	}
 	r.Body.Close()
@@ -284,7 +454,9 @@ func ForgotPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := sqlconnect.ForgotPasswordDB(req.Email); err != nil {
+	server := h.cfg.Server
+	resetBaseURL := fmt.Sprintf("https://%s%s/execs/reset-password/reset/", server.Host, server.Port)
+	if err := h.db.ForgotPasswordDB(r.Context(), req.Email, resetBaseURL, h.cfg.Auth.ResetTokenExpiresIn, h.cfg.Mail); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -293,7 +465,7 @@ func ForgotPassword(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Password reset link sent to %s", req.Email)
 }
 
-func ResetPassword(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) ResetPassword(w http.ResponseWriter, r *http.Request) {
 	token := r.PathValue("resetcode")
 
 	type request struct {
@@ -302,8 +474,7 @@ func ResetPassword(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req request
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+	if !h.readJSON(w, r, &req) {
 		return
 	}
 	r.Body.Close()
@@ -318,7 +489,7 @@ func ResetPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := sqlconnect.ResetPasswordDB(token, req.NewPassword); err != nil {
+	if err := h.db.ResetPasswordDB(r.Context(), token, req.NewPassword); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"schoolapi/internal/buildinfo"
+	"schoolapi/pkg/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readinessTimeout bounds the whole /readyz, probes usually give up after a second or two
+const readinessTimeout = 2 * time.Second
+
+// Healthz only says the process is up and serving, it checks nothing else so a database
+// outage doesn't get every replica restarted
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// Readyz checks what requests depend on. Any failed check answers 503 so the replica is
+// taken out of rotation until it passes again. The reasons are logged, the response only
+// names the failing check since the endpoint is public.
+func (h *Handlers) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]func(context.Context) error{
+		"database":   h.db.PingDB,
+		"migrations": h.checkMigrations,
+		"mail":       h.checkMail,
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]string, len(checks))
+	ready := true
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := "ok"
+			if err := check(ctx); err != nil {
+				utils.Logger(r.Context()).Warn("Readiness check failed", "check", name, "error", err)
+				result = "failed"
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			results[name] = result
+			if result != "ok" {
+				ready = false
+			}
+		}()
+	}
+	wg.Wait()
+
+	response := struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}{"ok", results}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		response.Status = "unavailable"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
+	}
+}
+
+// Version reports what was deployed, see buildinfo for setting it at build time
+func Version(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(buildinfo.Get()); err != nil {
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
+	}
+}
+
+func (h *Handlers) checkMigrations(ctx context.Context) error {
+	pending, err := h.db.PendingMigrationsDB(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("migrations not applied: %s", strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// checkMail only connects to the SMTP server, nothing is sent
+func (h *Handlers) checkMail(ctx context.Context) error {
+	mailer := h.cfg.Mail
+	if mailer.Host == "" {
+		return nil
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(mailer.Host, strconv.Itoa(mailer.Port)))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"schoolapi/pkg/utils"
 	"strconv"
 )
 
@@ -18,3 +20,38 @@ func getPaginationParams(r *http.Request) (int, int) {
 
 	return page, limit
 }
+
+// authorize checks the caller's role against the permissions stored in the roles table
+func (h *Handlers) authorize(r *http.Request, permission string) error {
+	role, _ := r.Context().Value(utils.ContextKey("role")).(string)
+	allowed, err := h.db.RoleHasPermissionDB(r.Context(), role, permission)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return errors.New("user not authorized")
+	}
+	return nil
+}
+
+func currentUsername(r *http.Request) string {
+	username, _ := r.Context().Value(utils.ContextKey("username")).(string)
+	return username
+}
+
+func currentUserID(r *http.Request) (int, error) {
+	uid, _ := r.Context().Value(utils.ContextKey("userID")).(string)
+	return strconv.Atoi(uid)
+}
+
+func currentSessionID(r *http.Request) string {
+	sessionId, _ := r.Context().Value(utils.ContextKey("sessionID")).(string)
+	return sessionId
+}
+
+// denylistSession shares a revocation with the other replicas until any token for it has expired
+func (h *Handlers) denylistSession(r *http.Request, sessionId string) {
+	if err := h.revocations.Revoke(r.Context(), sessionId, h.cfg.Auth.JWTExpiresIn); err != nil {
+		utils.Logger(r.Context()).Error("Error denylisting session", "error", err)
+	}
+}
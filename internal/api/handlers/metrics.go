@@ -0,0 +1,16 @@
+package handlers
+
+import (
+	"net/http"
+	"schoolapi/internal/metrics"
+)
+
+// Metrics serves the Prometheus metrics on the API listener, when no separate admin listener
+// is configured. A scraper needs a role with metrics:read, e.g. through an API key.
+func (h *Handlers) Metrics(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorize(r, "metrics:read"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	metrics.Handler().ServeHTTP(w, r)
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"schoolapi/internal/auth"
+	"schoolapi/internal/metrics"
+	"schoolapi/pkg/utils"
+	"strings"
+)
+
+const oidcStateCookie = "oidc_state"
+
+// OIDCLogin redirects the browser to the identity provider
+func (h *Handlers) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider := h.oidc
+	if provider == nil {
+		http.Error(w, "single sign-on is not enabled", http.StatusNotFound)
+		return
+	}
+
+	state, err := auth.RandomString(16)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := auth.RandomString(16)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	verifier, challenge, err := auth.NewPKCE()
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, challenge)
+	if err != nil {
+		utils.Logger(r.Context()).Error("OIDC error", "error", err)
+		http.Error(w, "identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	// Lax so the cookie comes back on the top-level redirect from the identity provider
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    strings.Join([]string{state, nonce, verifier}, "."),
+		Path:     "/execs/login/oidc",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback finishes the flow and issues the same token as Login
+func (h *Handlers) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider := h.oidc
+	if provider == nil {
+		http.Error(w, "single sign-on is not enabled", http.StatusNotFound)
+		return
+	}
+
+	if idpErr := r.URL.Query().Get("error"); idpErr != "" {
+		http.Error(w, "sign-in failed: "+idpErr, http.StatusUnauthorized)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		http.Error(w, "sign-in session expired, please try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		Path:     "/execs/login/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 || parts[0] != r.URL.Query().Get("state") {
+		http.Error(w, "invalid sign-in state", http.StatusBadRequest)
+		return
+	}
+	nonce, verifier := parts[1], parts[2]
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, "authorization code missing", http.StatusBadRequest)
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), code, verifier, nonce)
+	if err != nil {
+		utils.Logger(r.Context()).Error("OIDC exchange error", "error", err)
+		metrics.AuthFailures.WithLabelValues("oidc_exchange").Inc()
+		http.Error(w, "sign-in failed", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.db.GetExecByOIDCDB(r.Context(), identity.Subject, identity.Email, identity.EmailVerified)
+	if err != nil {
+		h.recordLoginAttempt(r, identity.Email, false, err.Error())
+		metrics.AuthFailures.WithLabelValues("oidc_unknown_user").Inc()
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	h.recordLoginAttempt(r, user.Username, true, "")
+
+	tokenString, err := h.issueToken(w, r, user.ID, user.Username, user.Role)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Token string `json:"token"`
+	}{tokenString}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
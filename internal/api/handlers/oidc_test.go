@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"schoolapi/internal/auth"
+	"testing"
+)
+
+// The state is checked before the provider is contacted, so an unreachable issuer is enough
+func TestOIDCCallbackState(t *testing.T) {
+	h := &Handlers{oidc: &auth.OIDCProvider{Issuer: "http://127.0.0.1:1", ClientID: "school-api"}}
+
+	tests := []struct {
+		name   string
+		cookie string
+		query  string
+		want   int
+	}{
+		{"no cookie", "", "?state=abc&code=xyz", http.StatusBadRequest},
+		{"state mismatch", "abc.nonce.verifier", "?state=other&code=xyz", http.StatusBadRequest},
+		{"malformed cookie", "abc.nonce", "?state=abc&code=xyz", http.StatusBadRequest},
+		{"state matches, no code", "abc.nonce.verifier", "?state=abc", http.StatusBadRequest},
+		{"idp error", "abc.nonce.verifier", "?error=access_denied", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/execs/login/oidc/callback"+tt.query, nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			h.OIDCCallback(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("state matches", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/execs/login/oidc/callback?state=abc&code=xyz", nil)
+		r.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: "abc.nonce.verifier"})
+		w := httptest.NewRecorder()
+		h.OIDCCallback(w, r)
+		// past the state check the exchange fails against the unreachable issuer
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d: %s", w.Code, http.StatusUnauthorized, w.Body.String())
+		}
+	})
+}
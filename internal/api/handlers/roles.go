@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"schoolapi/internal/models"
+	"schoolapi/pkg/utils"
+	"strconv"
+)
+
+func (h *Handlers) GetRoles(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorize(r, "roles:manage"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	roles, err := h.db.GetRolesDB(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Status string        `json:"status"`
+		Count  int           `json:"count"`
+		Data   []models.Role `json:"data"`
+	}{"success", len(roles), roles}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
+	}
+}
+
+func (h *Handlers) GetRole(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorize(r, "roles:manage"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid role id", http.StatusBadRequest)
+		return
+	}
+
+	role, err := h.db.GetRoleDB(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(role); err != nil {
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
+	}
+}
+
+func (h *Handlers) AddRoles(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorize(r, "roles:manage"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	var newRoles []models.Role
+	if !h.readJSON(w, r, &newRoles) {
+		return
+	}
+
+	addedRoles, err := h.db.AddRolesDB(r.Context(), newRoles)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		Status string        `json:"status"`
+		Count  int           `json:"count"`
+		Data   []models.Role `json:"data"`
+	}{
+		Status: "success",
+		Count:  len(addedRoles),
+		Data:   addedRoles,
+	})
+}
+
+func (h *Handlers) PatchRole(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorize(r, "roles:manage"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid role id", http.StatusBadRequest)
+		return
+	}
+
+	var updates map[string]any
+	if !h.readJSON(w, r, &updates) {
+		return
+	}
+
+	role, err := h.db.PatchRoleDB(r.Context(), id, updates)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(role); err != nil {
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
+	}
+}
+
+func (h *Handlers) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorize(r, "roles:manage"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid role id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.DeleteRoleDB(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := struct {
+		Status string `json:"status"`
+		ID     int    `json:"id"`
+	}{"Role successfully deleted", id}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		utils.Logger(r.Context()).Error("Error encoding response data", "error", err)
+	}
+}
@@ -0,0 +1,685 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"schoolapi/internal/models"
+	"schoolapi/internal/repository/sqlconnect"
+	"schoolapi/pkg/utils"
+	"strconv"
+	"strings"
+)
+
+// SCIM 2.0 (RFC 7643/7644) provisioning for the HR system. Users map onto execs and teachers
+// (told apart by userType), Groups map onto roles.
+
+const scimChangedBy = "scim"
+
+func SCIMServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	writeSCIM(w, r, http.StatusOK, map[string]any{
+		"schemas":        []string{"urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]any{"supported": true, "maxResults": 200},
+		"changePassword": map[string]bool{"supported": false},
+		"sort":           map[string]bool{"supported": false},
+		"etag":           map[string]bool{"supported": false},
+		"authenticationSchemes": []map[string]string{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "Dedicated SCIM bearer credential",
+		}},
+	})
+}
+
+func (h *Handlers) SCIMGetUsers(w http.ResponseWriter, r *http.Request) {
+	filters, err := parseSCIMFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		writeSCIMError(w, r, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	}
+
+	users, err := h.db.GetSCIMUsersDB(r.Context(), filters)
+	if errors.Is(err, sqlconnect.ErrInvalidSCIMFilter) {
+		writeSCIMError(w, r, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	} else if err != nil {
+		writeSCIMError(w, r, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+
+	startIndex, count := scimPagination(r)
+	writeSCIM(w, r, http.StatusOK, scimList(users, startIndex, count))
+}
+
+func (h *Handlers) SCIMGetUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.db.GetSCIMUserDB(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeSCIMError(w, r, http.StatusNotFound, "", err.Error())
+		return
+	}
+	writeSCIM(w, r, http.StatusOK, user)
+}
+
+func (h *Handlers) SCIMCreateUser(w http.ResponseWriter, r *http.Request) {
+	var user models.SCIMUser
+	if err := h.decodeJSON(r, &user, false); err != nil {
+		writeSCIMError(w, r, bodyErrorStatus(err), "invalidSyntax", bodyErrorDetail(err))
+		return
+	}
+	if user.UserName == "" {
+		writeSCIMError(w, r, http.StatusBadRequest, "invalidValue", "userName is required")
+		return
+	}
+	if user.UserType != "exec" && user.UserType != "teacher" {
+		writeSCIMError(w, r, http.StatusBadRequest, "invalidValue", `userType must be "exec" or "teacher"`)
+		return
+	}
+
+	existing, err := h.db.GetSCIMUsersDB(r.Context(), []models.SCIMFilter{
+		{Attribute: "userType", Operator: "eq", Value: user.UserType},
+		{Attribute: "userName", Operator: "eq", Value: user.UserName},
+	})
+	if err != nil {
+		writeSCIMError(w, r, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	if len(existing) > 0 {
+		writeSCIMError(w, r, http.StatusConflict, "uniqueness", "userName is already in use")
+		return
+	}
+
+	active := user.Active == nil || *user.Active
+	var id string
+	if user.UserType == "exec" {
+		role := h.scimDefaultRole()
+		if len(user.Groups) > 0 {
+			roleId, err := strconv.Atoi(user.Groups[0].Value)
+			if err != nil {
+				writeSCIMError(w, r, http.StatusBadRequest, "invalidValue", "invalid group id")
+				return
+			}
+			group, err := h.db.GetRoleDB(r.Context(), roleId)
+			if err != nil {
+				writeSCIMError(w, r, http.StatusBadRequest, "invalidValue", err.Error())
+				return
+			}
+			role = group.Name
+		}
+
+		password := user.Password
+		if password == "" {
+			// the exec signs in through SSO or resets the password
+			randomPassword := make([]byte, 32)
+			if _, err := rand.Read(randomPassword); err != nil {
+				writeSCIMError(w, r, http.StatusInternalServerError, "", "internal error")
+				return
+			}
+			password = hex.EncodeToString(randomPassword)
+		}
+
+		added, err := h.db.AddExecsDB(r.Context(), []models.Exec{{
+			FirstName:      user.Name.GivenName,
+			LastName:       user.Name.FamilyName,
+			Email:          scimPrimaryEmail(user),
+			Username:       user.UserName,
+			Password:       password,
+			Role:           role,
+			StatusInactive: !active,
+		}}, scimChangedBy)
+		if err != nil {
+			writeSCIMError(w, r, http.StatusBadRequest, "invalidValue", err.Error())
+			return
+		}
+		id = fmt.Sprintf("exec-%d", added[0].ID)
+	} else {
+		email := scimPrimaryEmail(user)
+		if email == "" {
+			email = user.UserName
+		}
+		teacher := models.Teacher{FirstName: user.Name.GivenName, LastName: user.Name.FamilyName, Email: email}
+		if user.Enterprise != nil {
+			teacher.Subject = user.Enterprise.Department
+		}
+		added, err := h.db.AddSCIMTeacherDB(r.Context(), teacher, !active)
+		if err != nil {
+			writeSCIMError(w, r, http.StatusBadRequest, "invalidValue", err.Error())
+			return
+		}
+		id = fmt.Sprintf("teacher-%d", added.ID)
+	}
+
+	created, err := h.db.GetSCIMUserDB(r.Context(), id)
+	if err != nil {
+		writeSCIMError(w, r, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	writeSCIM(w, r, http.StatusCreated, created)
+}
+
+func (h *Handlers) SCIMReplaceUser(w http.ResponseWriter, r *http.Request) {
+	existing, err := h.db.GetSCIMUserDB(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeSCIMError(w, r, http.StatusNotFound, "", err.Error())
+		return
+	}
+
+	var user models.SCIMUser
+	if err := h.decodeJSON(r, &user, false); err != nil {
+		writeSCIMError(w, r, bodyErrorStatus(err), "invalidSyntax", bodyErrorDetail(err))
+		return
+	}
+	user.ID = existing.ID
+	user.UserType = existing.UserType
+
+	h.saveSCIMUser(w, r, user)
+}
+
+func (h *Handlers) SCIMPatchUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.db.GetSCIMUserDB(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeSCIMError(w, r, http.StatusNotFound, "", err.Error())
+		return
+	}
+
+	var patch models.SCIMPatchRequest
+	if err := h.decodeJSON(r, &patch, false); err != nil {
+		writeSCIMError(w, r, bodyErrorStatus(err), "invalidSyntax", bodyErrorDetail(err))
+		return
+	}
+	if err := applySCIMUserPatch(&user, patch.Operations); err != nil {
+		writeSCIMError(w, r, http.StatusBadRequest, "invalidPath", err.Error())
+		return
+	}
+
+	h.saveSCIMUser(w, r, user)
+}
+
+func (h *Handlers) SCIMDeleteUser(w http.ResponseWriter, r *http.Request) {
+	userType, id, ok := sqlconnect.ParseSCIMUserID(r.PathValue("id"))
+	if !ok {
+		writeSCIMError(w, r, http.StatusNotFound, "", "User not found")
+		return
+	}
+
+	var err error
+	if userType == "exec" {
+		err = h.db.DeleteExecDB(r.Context(), id)
+	} else {
+		err = h.db.DeleteTeacherDB(r.Context(), id)
+	}
+	if err != nil {
+		writeSCIMError(w, r, http.StatusNotFound, "", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handlers) SCIMGetGroups(w http.ResponseWriter, r *http.Request) {
+	filters, err := parseSCIMFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		writeSCIMError(w, r, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	}
+
+	groups, err := h.db.GetSCIMGroupsDB(r.Context(), filters)
+	if errors.Is(err, sqlconnect.ErrInvalidSCIMFilter) {
+		writeSCIMError(w, r, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	} else if err != nil {
+		writeSCIMError(w, r, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+
+	startIndex, count := scimPagination(r)
+	writeSCIM(w, r, http.StatusOK, scimList(groups, startIndex, count))
+}
+
+func (h *Handlers) SCIMGetGroup(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeSCIMError(w, r, http.StatusNotFound, "", "Group not found")
+		return
+	}
+	group, err := h.db.GetSCIMGroupDB(r.Context(), id)
+	if err != nil {
+		writeSCIMError(w, r, http.StatusNotFound, "", err.Error())
+		return
+	}
+	writeSCIM(w, r, http.StatusOK, group)
+}
+
+func (h *Handlers) SCIMCreateGroup(w http.ResponseWriter, r *http.Request) {
+	var group models.SCIMGroup
+	if err := h.decodeJSON(r, &group, false); err != nil {
+		writeSCIMError(w, r, bodyErrorStatus(err), "invalidSyntax", bodyErrorDetail(err))
+		return
+	}
+	if group.DisplayName == "" {
+		writeSCIMError(w, r, http.StatusBadRequest, "invalidValue", "displayName is required")
+		return
+	}
+
+	added, err := h.db.AddRolesDB(r.Context(), []models.Role{{Name: group.DisplayName}})
+	if err != nil {
+		writeSCIMError(w, r, http.StatusConflict, "uniqueness", err.Error())
+		return
+	}
+
+	created := models.SCIMGroup{ID: strconv.Itoa(added[0].ID), DisplayName: group.DisplayName}
+	if err := h.setSCIMGroupMembers(r.Context(), created, scimMemberIDs(group.Members)); err != nil {
+		writeSCIMError(w, r, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+
+	result, err := h.db.GetSCIMGroupDB(r.Context(), added[0].ID)
+	if err != nil {
+		writeSCIMError(w, r, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	writeSCIM(w, r, http.StatusCreated, result)
+}
+
+func (h *Handlers) SCIMReplaceGroup(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeSCIMError(w, r, http.StatusNotFound, "", "Group not found")
+		return
+	}
+	existing, err := h.db.GetSCIMGroupDB(r.Context(), id)
+	if err != nil {
+		writeSCIMError(w, r, http.StatusNotFound, "", err.Error())
+		return
+	}
+
+	var group models.SCIMGroup
+	if err := h.decodeJSON(r, &group, false); err != nil {
+		writeSCIMError(w, r, bodyErrorStatus(err), "invalidSyntax", bodyErrorDetail(err))
+		return
+	}
+
+	h.saveSCIMGroup(w, r, existing, group.DisplayName, scimMemberIDs(group.Members))
+}
+
+func (h *Handlers) SCIMPatchGroup(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeSCIMError(w, r, http.StatusNotFound, "", "Group not found")
+		return
+	}
+	existing, err := h.db.GetSCIMGroupDB(r.Context(), id)
+	if err != nil {
+		writeSCIMError(w, r, http.StatusNotFound, "", err.Error())
+		return
+	}
+
+	var patch models.SCIMPatchRequest
+	if err := h.decodeJSON(r, &patch, false); err != nil {
+		writeSCIMError(w, r, bodyErrorStatus(err), "invalidSyntax", bodyErrorDetail(err))
+		return
+	}
+
+	displayName := existing.DisplayName
+	members := scimMemberIDs(existing.Members)
+	for _, op := range patch.Operations {
+		path := strings.ToLower(op.Path)
+		switch {
+		case path == "displayname" || (path == "" && strings.EqualFold(op.Op, "replace")):
+			if path == "" {
+				value, ok := op.Value.(map[string]any)
+				if !ok {
+					writeSCIMError(w, r, http.StatusBadRequest, "invalidValue", "replace without a path needs an object value")
+					return
+				}
+				if name, ok := value["displayName"].(string); ok {
+					displayName = name
+				}
+				if list, ok := value["members"]; ok {
+					members = scimMemberValues(list)
+				}
+				continue
+			}
+			name, ok := op.Value.(string)
+			if !ok {
+				writeSCIMError(w, r, http.StatusBadRequest, "invalidValue", "displayName must be a string")
+				return
+			}
+			displayName = name
+		case path == "members":
+			switch strings.ToLower(op.Op) {
+			case "add":
+				for m := range scimMemberValues(op.Value) {
+					members[m] = true
+				}
+			case "replace":
+				members = scimMemberValues(op.Value)
+			case "remove":
+				if op.Value == nil {
+					members = map[string]bool{}
+				}
+				for m := range scimMemberValues(op.Value) {
+					delete(members, m)
+				}
+			}
+		case strings.HasPrefix(path, "members[") && strings.EqualFold(op.Op, "remove"):
+			// members[value eq "exec-3"]
+			_, quoted, _ := strings.Cut(op.Path, "eq ")
+			member, err := strconv.Unquote(strings.TrimSuffix(strings.TrimSpace(quoted), "]"))
+			if err != nil {
+				writeSCIMError(w, r, http.StatusBadRequest, "invalidPath", "invalid member filter")
+				return
+			}
+			delete(members, member)
+		default:
+			writeSCIMError(w, r, http.StatusBadRequest, "invalidPath", fmt.Sprintf("unsupported path %q", op.Path))
+			return
+		}
+	}
+
+	h.saveSCIMGroup(w, r, existing, displayName, members)
+}
+
+func (h *Handlers) SCIMDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeSCIMError(w, r, http.StatusNotFound, "", "Group not found")
+		return
+	}
+	if err := h.db.DeleteRoleDB(r.Context(), id); err != nil {
+		writeSCIMError(w, r, http.StatusBadRequest, "", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// saveSCIMUser writes a full user representation back to the exec or teacher it maps onto
+func (h *Handlers) saveSCIMUser(w http.ResponseWriter, r *http.Request, user models.SCIMUser) {
+	userType, id, _ := sqlconnect.ParseSCIMUserID(user.ID)
+	active := user.Active == nil || *user.Active
+	email := scimPrimaryEmail(user)
+
+	var err error
+	if userType == "exec" {
+		err = h.db.SaveSCIMExecDB(r.Context(), models.Exec{
+			ID:             id,
+			FirstName:      user.Name.GivenName,
+			LastName:       user.Name.FamilyName,
+			Email:          email,
+			Username:       user.UserName,
+			StatusInactive: !active,
+		})
+	} else {
+		if email == "" {
+			email = user.UserName
+		}
+		teacher := models.Teacher{ID: id, FirstName: user.Name.GivenName, LastName: user.Name.FamilyName, Email: email}
+		if user.Enterprise != nil {
+			teacher.Subject = user.Enterprise.Department
+		}
+		err = h.db.SaveSCIMTeacherDB(r.Context(), teacher, !active)
+	}
+	if err != nil {
+		writeSCIMError(w, r, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+
+	saved, err := h.db.GetSCIMUserDB(r.Context(), user.ID)
+	if err != nil {
+		writeSCIMError(w, r, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	writeSCIM(w, r, http.StatusOK, saved)
+}
+
+func (h *Handlers) saveSCIMGroup(w http.ResponseWriter, r *http.Request, existing models.SCIMGroup, displayName string, members map[string]bool) {
+	id, _ := strconv.Atoi(existing.ID)
+	if displayName != "" && displayName != existing.DisplayName {
+		if _, err := h.db.PatchRoleDB(r.Context(), id, map[string]any{"name": displayName}); err != nil {
+			writeSCIMError(w, r, http.StatusBadRequest, "mutability", err.Error())
+			return
+		}
+		existing.DisplayName = displayName
+	}
+	if err := h.setSCIMGroupMembers(r.Context(), existing, members); err != nil {
+		writeSCIMError(w, r, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+
+	saved, err := h.db.GetSCIMGroupDB(r.Context(), id)
+	if err != nil {
+		writeSCIMError(w, r, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	writeSCIM(w, r, http.StatusOK, saved)
+}
+
+// setSCIMGroupMembers gives every desired member the group's role and moves execs
+// that left the group to the default role
+func (h *Handlers) setSCIMGroupMembers(ctx context.Context, group models.SCIMGroup, desired map[string]bool) error {
+	current := scimMemberIDs(group.Members)
+	for member := range desired {
+		if current[member] {
+			continue
+		}
+		userType, id, ok := sqlconnect.ParseSCIMUserID(member)
+		if !ok || userType != "exec" {
+			return fmt.Errorf("only execs can be group members, got %q", member)
+		}
+		if _, err := h.db.PatchExecDB(ctx, id, sqlconnect.AnyVersion, map[string]any{"role": group.DisplayName}, scimChangedBy); err != nil {
+			return err
+		}
+	}
+	for member := range current {
+		if desired[member] {
+			continue
+		}
+		if group.DisplayName == h.scimDefaultRole() {
+			return fmt.Errorf("execs cannot be removed from the default group %q", group.DisplayName)
+		}
+		_, id, _ := sqlconnect.ParseSCIMUserID(member)
+		if _, err := h.db.PatchExecDB(ctx, id, sqlconnect.AnyVersion, map[string]any{"role": h.scimDefaultRole()}, scimChangedBy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applySCIMUserPatch(user *models.SCIMUser, ops []models.SCIMPatchOperation) error {
+	for _, op := range ops {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+			if op.Path == "" {
+				values, ok := op.Value.(map[string]any)
+				if !ok {
+					return errors.New("an operation without a path needs an object value")
+				}
+				for attr, value := range values {
+					if err := setSCIMUserAttribute(user, attr, value); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if err := setSCIMUserAttribute(user, op.Path, op.Value); err != nil {
+				return err
+			}
+		case "remove":
+			if op.Path == "" {
+				return errors.New("remove needs a path")
+			}
+			if err := setSCIMUserAttribute(user, op.Path, nil); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported operation %q", op.Op)
+		}
+	}
+	return nil
+}
+
+func setSCIMUserAttribute(user *models.SCIMUser, attr string, value any) error {
+	lower := strings.ToLower(attr)
+	switch {
+	case lower == "active":
+		active, err := scimBool(value)
+		if err != nil {
+			return err
+		}
+		user.Active = &active
+	case lower == "username":
+		s, ok := value.(string)
+		if !ok || s == "" {
+			return errors.New("userName must be a non-empty string")
+		}
+		user.UserName = s
+	case lower == "name":
+		values, ok := value.(map[string]any)
+		if !ok {
+			return errors.New("name must be an object")
+		}
+		for k, v := range values {
+			if err := setSCIMUserAttribute(user, "name."+k, v); err != nil {
+				return err
+			}
+		}
+	case lower == "name.givenname":
+		s, _ := value.(string)
+		user.Name.GivenName = s
+	case lower == "name.familyname":
+		s, _ := value.(string)
+		user.Name.FamilyName = s
+	case strings.HasPrefix(lower, "emails"):
+		user.Emails = nil
+		switch v := value.(type) {
+		case string:
+			user.Emails = []models.SCIMEmail{{Value: v, Type: "work", Primary: true}}
+		case []any:
+			for _, item := range v {
+				if m, ok := item.(map[string]any); ok {
+					email, _ := m["value"].(string)
+					primary, _ := m["primary"].(bool)
+					user.Emails = append(user.Emails, models.SCIMEmail{Value: email, Primary: primary})
+				}
+			}
+		}
+	case lower == strings.ToLower(models.SCIMEnterpriseUserSchema):
+		values, ok := value.(map[string]any)
+		if !ok {
+			return errors.New("enterprise extension must be an object")
+		}
+		for k, v := range values {
+			if err := setSCIMUserAttribute(user, models.SCIMEnterpriseUserSchema+":"+k, v); err != nil {
+				return err
+			}
+		}
+	case lower == strings.ToLower(models.SCIMEnterpriseUserSchema+":department"):
+		s, _ := value.(string)
+		if user.Enterprise == nil {
+			user.Enterprise = &models.SCIMEnterprise{}
+		}
+		user.Enterprise.Department = s
+	case lower == "externalid" || lower == "displayname":
+		// not stored
+	default:
+		return fmt.Errorf("unsupported attribute %q", attr)
+	}
+	return nil
+}
+
+// scimBool accepts booleans and the "True"/"False" strings some identity providers send
+func scimBool(value any) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		return strconv.ParseBool(v)
+	}
+	return false, errors.New("active must be a boolean")
+}
+
+func scimPrimaryEmail(user models.SCIMUser) string {
+	for _, email := range user.Emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(user.Emails) > 0 {
+		return user.Emails[0].Value
+	}
+	return ""
+}
+
+func scimMemberIDs(members []models.SCIMMember) map[string]bool {
+	ids := make(map[string]bool, len(members))
+	for _, m := range members {
+		ids[m.Value] = true
+	}
+	return ids
+}
+
+func scimMemberValues(value any) map[string]bool {
+	ids := map[string]bool{}
+	list, _ := value.([]any)
+	for _, item := range list {
+		if m, ok := item.(map[string]any); ok {
+			if id, ok := m["value"].(string); ok {
+				ids[id] = true
+			}
+		}
+	}
+	return ids
+}
+
+func (h *Handlers) scimDefaultRole() string {
+	return h.cfg.SCIM.DefaultRole
+}
+
+func scimPagination(r *http.Request) (int, int) {
+	startIndex, err := strconv.Atoi(r.URL.Query().Get("startIndex"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil || count < 0 || count > 200 {
+		count = 200
+	}
+	return startIndex, count
+}
+
+func scimList[T any](resources []T, startIndex, count int) models.SCIMListResponse {
+	total := len(resources)
+	from := min(startIndex-1, total)
+	to := min(from+count, total)
+	return models.SCIMListResponse{
+		Schemas:      []string{models.SCIMListResponseSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: to - from,
+		Resources:    resources[from:to],
+	}
+}
+
+func writeSCIM(w http.ResponseWriter, r *http.Request, status int, v any) {
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
+	}
+}
+
+func writeSCIMError(w http.ResponseWriter, r *http.Request, status int, scimType, detail string) {
+	if status >= http.StatusInternalServerError {
+		utils.Logger(r.Context()).Error("SCIM request failed", "status", status, "detail", detail)
+	}
+	writeSCIM(w, r, status, models.SCIMError{
+		Schemas:  []string{models.SCIMErrorSchema},
+		Status:   strconv.Itoa(status),
+		SCIMType: scimType,
+		Detail:   detail,
+	})
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"schoolapi/internal/models"
+	"strconv"
+	"strings"
+)
+
+// parseSCIMFilter handles the subset of RFC 7644 filters provisioning clients send in practice:
+// comparisons (eq, ne, co, sw, ew, pr) joined by "and", e.g. userName eq "jdoe" and active eq true
+func parseSCIMFilter(filter string) ([]models.SCIMFilter, error) {
+	tokens, err := tokenizeSCIMFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var filters []models.SCIMFilter
+	for i := 0; i < len(tokens); {
+		if len(filters) > 0 {
+			if !strings.EqualFold(tokens[i], "and") {
+				return nil, fmt.Errorf("unsupported logical operator %q", tokens[i])
+			}
+			i++
+		}
+		if i+1 >= len(tokens) {
+			return nil, errors.New("incomplete filter expression")
+		}
+
+		f := models.SCIMFilter{Attribute: tokens[i], Operator: strings.ToLower(tokens[i+1])}
+		i += 2
+		if f.Operator == "pr" {
+			filters = append(filters, f)
+			continue
+		}
+		if i >= len(tokens) {
+			return nil, errors.New("incomplete filter expression")
+		}
+
+		value := tokens[i]
+		switch {
+		case strings.HasPrefix(value, `"`):
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", value)
+			}
+			f.Value = unquoted
+		case value == "true" || value == "false":
+			f.Value = value == "true"
+		default:
+			return nil, fmt.Errorf("unsupported value %s", value)
+		}
+		i++
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+func tokenizeSCIMFilter(filter string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(filter); {
+		switch {
+		case filter[i] == ' ':
+			i++
+		case filter[i] == '"':
+			end := i + 1
+			for end < len(filter) && filter[end] != '"' {
+				if filter[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(filter) {
+				return nil, errors.New("unterminated string in filter")
+			}
+			tokens = append(tokens, filter[i:end+1])
+			i = end + 1
+		case filter[i] == '(' || filter[i] == ')' || filter[i] == '[':
+			return nil, errors.New("grouping and complex attribute filters are not supported")
+		default:
+			end := strings.IndexByte(filter[i:], ' ')
+			if end < 0 {
+				end = len(filter) - i
+			}
+			tokens = append(tokens, filter[i:i+end])
+			i += end
+		}
+	}
+	return tokens, nil
+}
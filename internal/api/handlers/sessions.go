@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"schoolapi/internal/models"
+	"schoolapi/pkg/utils"
+	"strconv"
+)
+
+func (h *Handlers) GetMySessions(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserID(r)
+	if err != nil {
+		http.Error(w, "please log in", http.StatusUnauthorized)
+		return
+	}
+	h.writeSessions(w, r, userId)
+}
+
+func (h *Handlers) DeleteMySession(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserID(r)
+	if err != nil {
+		http.Error(w, "please log in", http.StatusUnauthorized)
+		return
+	}
+	h.revokeSession(w, r, userId, r.PathValue("sessionid"))
+}
+
+func (h *Handlers) GetExecSessions(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorize(r, "sessions:manage"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid exec id", http.StatusBadRequest)
+		return
+	}
+	h.writeSessions(w, r, id)
+}
+
+func (h *Handlers) DeleteExecSession(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorize(r, "sessions:manage"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid exec id", http.StatusBadRequest)
+		return
+	}
+	h.revokeSession(w, r, id, r.PathValue("sessionid"))
+}
+
+func (h *Handlers) GetExecLoginAttempts(w http.ResponseWriter, r *http.Request) {
+	if err := h.authorize(r, "sessions:manage"); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid exec id", http.StatusBadRequest)
+		return
+	}
+
+	attempts, err := h.db.GetLoginAttemptsDB(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Status string                `json:"status"`
+		Count  int                   `json:"count"`
+		Data   []models.LoginAttempt `json:"data"`
+	}{"success", len(attempts), attempts}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
+	}
+}
+
+func (h *Handlers) writeSessions(w http.ResponseWriter, r *http.Request, execId int) {
+	sessions, err := h.db.GetSessionsDB(r.Context(), execId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Status string           `json:"status"`
+		Count  int              `json:"count"`
+		Data   []models.Session `json:"data"`
+	}{"success", len(sessions), sessions}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
+	}
+}
+
+func (h *Handlers) revokeSession(w http.ResponseWriter, r *http.Request, execId int, sessionId string) {
+	if err := h.db.RevokeSessionDB(r.Context(), execId, sessionId); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	h.denylistSession(r, sessionId)
+
+	response := struct {
+		Status string `json:"status"`
+		ID     string `json:"id"`
+	}{"Session successfully revoked", sessionId}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		utils.Logger(r.Context()).Error("Error encoding response data", "error", err)
+	}
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	mw "schoolapi/internal/api/middlewares"
+	"schoolapi/internal/config"
+	"schoolapi/internal/repository/sqlconnect"
+	"schoolapi/internal/store"
+	"schoolapi/pkg/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeConn stands in for MySQL: a query gets the rows of the first fakeQuery it contains, any
+// other statement succeeds. Sessions always look live to TouchSessionDB, so a revoked one can
+// only be turned away by the revocations store.
+type fakeConn struct {
+	queries []fakeQuery
+}
+
+type fakeQuery struct {
+	contains string
+	rows     [][]driver.Value
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	for _, q := range c.queries {
+		if strings.Contains(query, q.contains) {
+			return &fakeRows{rows: q.rows}, nil
+		}
+	}
+	return nil, errors.New("unexpected query: " + query)
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (c *fakeConn) Begin() (driver.Tx, error)                { return fakeTx{}, nil }
+func (c *fakeConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+func (c *fakeConn) Close() error                             { return nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+type fakeConnector struct{ conn *fakeConn }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return c.conn, nil }
+func (c fakeConnector) Driver() driver.Driver                        { return nil }
+
+const testSecret = "test-secret"
+
+// sessionTest serves the session routes behind mw.JWT, with exec 7 holding session-1 and
+// session-2 and the admin, exec 1, holding admin-session
+func sessionTest(t *testing.T) (http.Handler, store.Store) {
+	t.Helper()
+	password, err := utils.HashPassword("old password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &fakeConn{queries: []fakeQuery{
+		{"SELECT revoked_at", [][]driver.Value{{nil, false}}},
+		{"SELECT id FROM exec_sessions", [][]driver.Value{{"session-1"}, {"session-2"}}},
+		{"FROM roles", [][]driver.Value{{int64(1), "admin", "Administrators", []byte(`["*"]`)}}},
+		{"FOR UPDATE", [][]driver.Value{{int64(7), "Ada", "Lovelace", "ada@example.com", "ada", false, "teacher", int64(1)}}},
+		{"SELECT username, password, role", [][]driver.Value{{"ada", password, "teacher"}}},
+	}}
+	pool := sql.OpenDB(fakeConnector{conn})
+	t.Cleanup(func() { pool.Close() })
+	db := &sqlconnect.DB{DB: pool}
+
+	revocations := store.NewMemory()
+	t.Cleanup(func() { revocations.Close() })
+	cfg := &config.Config{Auth: config.AuthConfig{JWTSecret: testSecret, JWTExpiresIn: time.Hour}}
+	h := &Handlers{cfg: cfg, db: db, revocations: revocations}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /execs/me", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("POST /execs/logout", h.Logout)
+	mux.HandleFunc("POST /execs/{id}/deactivate", h.DeactivateExec)
+	mux.HandleFunc("POST /execs/{id}/update-password", h.UpdatePassword)
+	return mw.JWT(cfg.Auth, revocations, db)(mux), revocations
+}
+
+func sessionToken(t *testing.T, execId, role, sessionId string) string {
+	t.Helper()
+	token, err := utils.SignToken([]byte(testSecret), time.Hour, execId, "user-"+execId, role, sessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func serveWithToken(handler http.Handler, method, path, body, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.AddCookie(&http.Cookie{Name: "Bearer", Value: token})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+type sessionCheck struct {
+	session string
+	token   string
+	want    int
+}
+
+// wantSessions checks which tokens the JWT middleware still lets through
+func wantSessions(t *testing.T, handler http.Handler, checks []sessionCheck) {
+	t.Helper()
+	for _, c := range checks {
+		if w := serveWithToken(handler, http.MethodGet, "/execs/me", "", c.token); w.Code != c.want {
+			t.Errorf("%s: status = %d, want %d: %s", c.session, w.Code, c.want, w.Body.String())
+		}
+	}
+}
+
+func TestJWTRevokedSession(t *testing.T) {
+	handler, revocations := sessionTest(t)
+	revoked, live := sessionToken(t, "7", "teacher", "session-1"), sessionToken(t, "7", "teacher", "session-2")
+	if err := revocations.Revoke(context.Background(), "session-1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serveWithToken(handler, http.MethodGet, "/execs/me", "", revoked)
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "session has been revoked") {
+		t.Errorf("revoked session: status = %d %q, want 401 session has been revoked", w.Code, w.Body.String())
+	}
+	if w := serveWithToken(handler, http.MethodGet, "/execs/me", "", live); w.Code != http.StatusOK {
+		t.Errorf("live session: status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestLogoutRevokesSession(t *testing.T) {
+	handler, _ := sessionTest(t)
+	current, other := sessionToken(t, "7", "teacher", "session-1"), sessionToken(t, "7", "teacher", "session-2")
+
+	if w := serveWithToken(handler, http.MethodPost, "/execs/logout", "", current); w.Code != http.StatusOK {
+		t.Fatalf("logout: status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+	// only the session logging out ends
+	wantSessions(t, handler, []sessionCheck{
+		{"session-1", current, http.StatusUnauthorized},
+		{"session-2", other, http.StatusOK},
+	})
+}
+
+func TestDeactivateRevokesSessions(t *testing.T) {
+	handler, _ := sessionTest(t)
+	admin := sessionToken(t, "1", "admin", "admin-session")
+
+	if w := serveWithToken(handler, http.MethodPost, "/execs/7/deactivate", "", admin); w.Code != http.StatusOK {
+		t.Fatalf("deactivate: status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+	wantSessions(t, handler, []sessionCheck{
+		{"session-1", sessionToken(t, "7", "teacher", "session-1"), http.StatusUnauthorized},
+		{"session-2", sessionToken(t, "7", "teacher", "session-2"), http.StatusUnauthorized},
+		{"admin-session", admin, http.StatusOK},
+	})
+}
+
+func TestUpdatePasswordRevokesSessions(t *testing.T) {
+	handler, _ := sessionTest(t)
+	current := sessionToken(t, "7", "teacher", "session-1")
+
+	w := serveWithToken(handler, http.MethodPost, "/execs/7/update-password", `{"current_password":"old password","new_password":"new password"}`, current)
+	if w.Code != http.StatusOK {
+		t.Fatalf("update password: status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+	var issued string
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "Bearer" {
+			issued = cookie.Value
+		}
+	}
+	if issued == "" {
+		t.Fatal("no token issued for the new password")
+	}
+	// every session before the change ends, the device changing it gets a new one
+	wantSessions(t, handler, []sessionCheck{
+		{"session-1", current, http.StatusUnauthorized},
+		{"session-2", sessionToken(t, "7", "teacher", "session-2"), http.StatusUnauthorized},
+		{"the new session", issued, http.StatusOK},
+	})
+}
@@ -1,19 +1,20 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"fmt"
 	"net/http"
 	"schoolapi/internal/models"
-	"schoolapi/internal/repository/sqlconnect"
+	"schoolapi/pkg/utils"
 	"strconv"
 )
 
-func GetStudents(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) GetStudents(w http.ResponseWriter, r *http.Request) {
 	limit, page := getPaginationParams(r)
 
 	var students []models.Student
-	students, totalCount, err := sqlconnect.GetStudentsDB(students, r, limit, page)
+	students, totalCount, err := h.db.GetStudentsDB(students, r, limit, page)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -29,11 +30,11 @@ func GetStudents(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("JSON encoding error: %v", err)
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
 	}
 }
 
-func GetStudent(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) GetStudent(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 
 	id, err := strconv.Atoi(idStr)
@@ -42,27 +43,37 @@ func GetStudent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	student, err := sqlconnect.GetStudentDB(id)
+	student, err := h.db.GetStudentDB(r.Context(), id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("ETag", versionETag(student.Version))
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(student); err != nil {
-		log.Printf("JSON encoding error: %v", err)
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
 	}
 }
 
-func AddStudents(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) AddStudents(w http.ResponseWriter, r *http.Request) {
 
 	var newStudents []models.Student
-	if err := json.NewDecoder(r.Body).Decode(&newStudents); err != nil {
-		http.Error(w, "Invalid request body: ", http.StatusBadRequest)
+	if !h.readJSON(w, r, &newStudents) {
 		return
 	}
 
-	addedStudents, err := sqlconnect.AddStudentsDB(newStudents)
+	errs, err := h.validateStudents(r.Context(), newStudents, "data")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(errs) > 0 {
+		utils.WriteValidationProblem(w, errs)
+		return
+	}
+
+	addedStudents, err := h.db.AddStudentsDB(r.Context(), newStudents)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -81,76 +92,101 @@ func AddStudents(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func UpdateStudent(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) UpdateStudent(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		log.Printf("Error converting student's id string to int: %v", err)
+		utils.Logger(r.Context()).Error("Error converting student's id string to int", "error", err)
 		http.Error(w, "Invalid student id", http.StatusBadRequest)
 		return
 	}
 
 	var updatedStudent models.Student
 
-	if err := json.NewDecoder(r.Body).Decode(&updatedStudent); err != nil {
-		log.Printf("Error decoding json data: %v", err)
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	if !h.readJSON(w, r, &updatedStudent) {
 		return
 	}
 
-	updatedStudent, err = sqlconnect.UpdateStudentDB(id, updatedStudent)
+	errs, err := h.validateStudents(r.Context(), []models.Student{updatedStudent}, "")
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(errs) > 0 {
+		utils.WriteValidationProblem(w, errs)
+		return
+	}
+	version, ok := expectedVersion(w, r, updatedStudent.Version)
+	if !ok {
+		return
+	}
+	updatedStudent.Version = version
+
+	updatedStudent, err = h.db.UpdateStudentDB(r.Context(), id, updatedStudent)
+	if writeVersionConflict(w, r, err) {
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	w.Header().Set("ETag", versionETag(updatedStudent.Version))
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(updatedStudent); err != nil {
-		log.Printf("JSON encoding error: %v", err)
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
 		return
 	}
 }
 
-func PatchStudent(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) PatchStudent(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		log.Printf("Error converting student's id string to int: %v", err)
+		utils.Logger(r.Context()).Error("Error converting student's id string to int", "error", err)
 		http.Error(w, "Invalid student id", http.StatusBadRequest)
 		return
 	}
 
 	var updates map[string]any
-	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
-		log.Printf("Error decoding json data: %v", err)
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	if !h.readJSON(w, r, &updates) {
+		return
+	}
+	version, ok := expectedVersion(w, r, popVersion(updates))
+	if !ok {
 		return
 	}
 
-	existingStudent, err := sqlconnect.PatchStudentDB(id, updates)
-	if err != nil {
+	existingStudent, err := h.db.PatchStudentDB(r.Context(), id, version, updates)
+	if writeVersionConflict(w, r, err) {
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	w.Header().Set("ETag", versionETag(existingStudent.Version))
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(existingStudent); err != nil {
-		log.Printf("JSON encoding error: %v", err)
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
 		return
 	}
 }
 
-func PatchStudents(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) PatchStudents(w http.ResponseWriter, r *http.Request) {
 
 	var updates []map[string]any
-	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
-		log.Printf("Error decoding json: %v", err)
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	if !h.readJSON(w, r, &updates) {
+		return
+	}
+	if errs := requireVersions(updates); len(errs) > 0 {
+		utils.WriteValidationProblem(w, errs)
 		return
 	}
 
-	err := sqlconnect.PatchStudentsDB(updates)
-	if err != nil {
+	err := h.db.PatchStudentsDB(r.Context(), updates)
+	if writeVersionConflict(w, r, err) {
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -158,16 +194,16 @@ func PatchStudents(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func DeleteStudent(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) DeleteStudent(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		log.Printf("Error converting student's id string to int: %v", err)
+		utils.Logger(r.Context()).Error("Error converting student's id string to int", "error", err)
 		http.Error(w, "Invalid student id", http.StatusBadRequest)
 		return
 	}
 
-	err = sqlconnect.DeleteStudentDB(id)
+	err = h.db.DeleteStudentDB(r.Context(), id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -179,24 +215,21 @@ func DeleteStudent(w http.ResponseWriter, r *http.Request) {
 	}{"Student successfully deleted", id}
 
 	if err = json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error encoding response data: %v", err)
+		utils.Logger(r.Context()).Error("Error encoding response data", "error", err)
 		http.Error(w, "Server error", http.StatusInternalServerError)
 		return
 	}
 
 }
 
-func DeleteStudents(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) DeleteStudents(w http.ResponseWriter, r *http.Request) {
 
 	var ids []int
-	err := json.NewDecoder(r.Body).Decode(&ids)
-	if err != nil {
-		log.Printf("Error reading ids from request body: %v", err)
-		http.Error(w, "Invalid student ids", http.StatusInternalServerError)
+	if !h.readJSON(w, r, &ids) {
 		return
 	}
 
-	deletedIds, err := sqlconnect.DeleteStudentsDB(ids)
+	deletedIds, err := h.db.DeleteStudentsDB(r.Context(), ids)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -209,3 +242,27 @@ func DeleteStudents(w http.ResponseWriter, r *http.Request) {
 	}{"Students successfully deleted", deletedIds}
 	json.NewEncoder(w).Encode(response)
 }
+
+// validateStudents checks the model rules plus that each class exists. With an empty prefix
+// the fields are reported without an index, for single-student requests.
+func (h *Handlers) validateStudents(ctx context.Context, students []models.Student, prefix string) (utils.ValidationErrors, error) {
+	classes, err := h.db.GetClassNamesDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs utils.ValidationErrors
+	for i, student := range students {
+		fieldPrefix := ""
+		if prefix != "" {
+			fieldPrefix = fmt.Sprintf("%s[%d].", prefix, i)
+		}
+		for _, e := range utils.Validate(student) {
+			errs = append(errs, utils.FieldError{Field: fieldPrefix + e.Field, Message: e.Message})
+		}
+		if student.Class != "" && !classes[student.Class] {
+			errs = append(errs, utils.FieldError{Field: fieldPrefix + "class", Message: "unknown class"})
+		}
+	}
+	return errs, nil
+}
@@ -3,19 +3,16 @@ package handlers
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"log"
 	"net/http"
 	"schoolapi/internal/models"
-	"schoolapi/internal/repository/sqlconnect"
 	"schoolapi/pkg/utils"
 	"strconv"
 	"time"
 )
 
-func GetTeachers(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) GetTeachers(w http.ResponseWriter, r *http.Request) {
 	var teachers []models.Teacher
-	teachers, err := sqlconnect.GetTeachersDB(teachers, r)
+	teachers, err := h.db.GetTeachersDB(teachers, r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -29,11 +26,11 @@ func GetTeachers(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("JSON encoding error: %v", err)
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
 	}
 }
 
-func GetTeacher(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) GetTeacher(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 
 	id, err := strconv.Atoi(idStr)
@@ -42,27 +39,32 @@ func GetTeacher(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	teacher, err := sqlconnect.GetTeacherDB(id)
+	teacher, err := h.db.GetTeacherDB(r.Context(), id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("ETag", versionETag(teacher.Version))
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(teacher); err != nil {
-		log.Printf("JSON encoding error: %v", err)
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
 	}
 }
 
-func AddTeachers(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) AddTeachers(w http.ResponseWriter, r *http.Request) {
 
 	var newTeachers []models.Teacher
-	if err := json.NewDecoder(r.Body).Decode(&newTeachers); err != nil {
-		http.Error(w, "Invalid request body: ", http.StatusBadRequest)
+	if !h.readJSON(w, r, &newTeachers) {
 		return
 	}
 
-	addedTeachers, err := sqlconnect.AddTeachersDB(newTeachers)
+	if errs := utils.ValidateSlice(newTeachers, "data"); len(errs) > 0 {
+		utils.WriteValidationProblem(w, errs)
+		return
+	}
+
+	addedTeachers, err := h.db.AddTeachersDB(r.Context(), newTeachers)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -81,11 +83,11 @@ func AddTeachers(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func UpdateTeacher(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) UpdateTeacher(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		log.Printf("Error converting teacher's id string to int: %v", err)
+		utils.Logger(r.Context()).Error("Error converting teacher's id string to int", "error", err)
 		http.Error(w, "Invalid teacher id", http.StatusBadRequest)
 		return
 	}
@@ -95,65 +97,85 @@ func UpdateTeacher(w http.ResponseWriter, r *http.Request) {
 
 	var updatedTeacher models.Teacher
 
-	if err := json.NewDecoder(r.Body).Decode(&updatedTeacher); err != nil {
-		log.Printf("Error decoding json data: %v", err)
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	if !h.readJSON(w, r, &updatedTeacher) {
 		return
 	}
 
-	updatedTeacher, err = sqlconnect.UpdateTeacherDB(ctx, id, updatedTeacher)
-	if err != nil {
+	if errs := utils.Validate(updatedTeacher); len(errs) > 0 {
+		utils.WriteValidationProblem(w, errs)
+		return
+	}
+	version, ok := expectedVersion(w, r, updatedTeacher.Version)
+	if !ok {
+		return
+	}
+	updatedTeacher.Version = version
+
+	updatedTeacher, err = h.db.UpdateTeacherDB(ctx, id, updatedTeacher)
+	if writeVersionConflict(w, r, err) {
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	w.Header().Set("ETag", versionETag(updatedTeacher.Version))
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(updatedTeacher); err != nil {
-		log.Printf("JSON encoding error: %v", err)
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
 		return
 	}
 }
 
-func PatchTeacher(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) PatchTeacher(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		log.Printf("Error converting teacher's id string to int: %v", err)
+		utils.Logger(r.Context()).Error("Error converting teacher's id string to int", "error", err)
 		http.Error(w, "Invalid teacher id", http.StatusBadRequest)
 		return
 	}
 
 	var updates map[string]any
-	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
-		log.Printf("Error decoding json data: %v", err)
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	if !h.readJSON(w, r, &updates) {
+		return
+	}
+	version, ok := expectedVersion(w, r, popVersion(updates))
+	if !ok {
 		return
 	}
 
-	existingTeacher, err := sqlconnect.PatchTeacherDB(id, updates)
-	if err != nil {
+	existingTeacher, err := h.db.PatchTeacherDB(r.Context(), id, version, updates)
+	if writeVersionConflict(w, r, err) {
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	w.Header().Set("ETag", versionETag(existingTeacher.Version))
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(existingTeacher); err != nil {
-		log.Printf("JSON encoding error: %v", err)
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
 		return
 	}
 }
 
-func PatchTeachers(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) PatchTeachers(w http.ResponseWriter, r *http.Request) {
 
 	var updates []map[string]any
-	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
-		log.Printf("Error decoding json: %v", err)
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	if !h.readJSON(w, r, &updates) {
+		return
+	}
+	if errs := requireVersions(updates); len(errs) > 0 {
+		utils.WriteValidationProblem(w, errs)
 		return
 	}
 
-	err := sqlconnect.PatchTeachersDB(updates)
-	if err != nil {
+	err := h.db.PatchTeachersDB(r.Context(), updates)
+	if writeVersionConflict(w, r, err) {
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -161,16 +183,16 @@ func PatchTeachers(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func DeleteTeacher(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) DeleteTeacher(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		log.Printf("Error converting teacher's id string to int: %v", err)
+		utils.Logger(r.Context()).Error("Error converting teacher's id string to int", "error", err)
 		http.Error(w, "Invalid teacher id", http.StatusBadRequest)
 		return
 	}
 
-	err = sqlconnect.DeleteTeacherDB(id)
+	err = h.db.DeleteTeacherDB(r.Context(), id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -182,22 +204,20 @@ func DeleteTeacher(w http.ResponseWriter, r *http.Request) {
 	}{"Teacher successfully deleted", id}
 
 	if err = json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error encoding response data: %v", err)
+		utils.Logger(r.Context()).Error("Error encoding response data", "error", err)
 		http.Error(w, "Server error", http.StatusInternalServerError)
 		return
 	}
 
 }
 
-func DeleteTeachers(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) DeleteTeachers(w http.ResponseWriter, r *http.Request) {
 
 	var ids []int
-	err := json.NewDecoder(r.Body).Decode(&ids)
-	if err != nil {
-		http.Error(w, "Invalid teacher ids", http.StatusInternalServerError)
+	if !h.readJSON(w, r, &ids) {
 		return
 	}
-	deletedIds, err := sqlconnect.DeleteTeachersDB(ids)
+	deletedIds, err := h.db.DeleteTeachersDB(r.Context(), ids)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -211,12 +231,12 @@ func DeleteTeachers(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func GetStudentsByTeacherId(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) GetStudentsByTeacherId(w http.ResponseWriter, r *http.Request) {
 	teacherId := r.PathValue("id")
 
 	var students []models.Student
 
-	students, err := sqlconnect.GetStudentsByTeacherIdDB(teacherId, students)
+	students, err := h.db.GetStudentsByTeacherIdDB(r.Context(), teacherId, students)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -236,15 +256,14 @@ func GetStudentsByTeacherId(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func GetStudentsCountByTeacherId(w http.ResponseWriter, r *http.Request) {
-	fmt.Println(r.Context())
+func (h *Handlers) GetStudentsCountByTeacherId(w http.ResponseWriter, r *http.Request) {
 	_, err := utils.AuthorizeUser(r.Context().Value(utils.ContextKey("role")).(string), "admin", "manager", "exec")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 	teacherId := r.PathValue("id")
-	count, err := sqlconnect.GetStudentsCountByTeacherIdDB(teacherId)
+	count, err := h.db.GetStudentsCountByTeacherIdDB(r.Context(), teacherId)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"schoolapi/internal/models"
+	"schoolapi/internal/repository/sqlconnect"
+	"schoolapi/pkg/utils"
+	"strconv"
+	"strings"
+)
+
+// versionETag is the ETag of a versioned resource, mw.Cache keeps it instead of hashing the body
+func versionETag(version int) string {
+	return fmt.Sprintf(`"v%d"`, version)
+}
+
+// expectedVersion is the version the client last read, from If-Match or else the body's
+// version. Without either the update is refused, a blind write could undo someone else's.
+func expectedVersion(w http.ResponseWriter, r *http.Request, bodyVersion int) (int, bool) {
+	if ifMatch := strings.TrimSpace(r.Header.Get("If-Match")); ifMatch != "" && ifMatch != "*" {
+		version, err := parseVersionETag(ifMatch)
+		if err != nil {
+			utils.WriteProblem(w, http.StatusPreconditionFailed, "If-Match must be an ETag this API returned")
+			return 0, false
+		}
+		return version, true
+	}
+	if bodyVersion > 0 {
+		return bodyVersion, true
+	}
+	utils.WriteProblem(w, http.StatusPreconditionRequired, "send the version you last read, in the body or as If-Match")
+	return 0, false
+}
+
+func parseVersionETag(list string) (int, error) {
+	etag, _, _ := strings.Cut(list, ",")
+	etag = strings.TrimSpace(etag)
+	if !strings.HasPrefix(etag, `"v`) || !strings.HasSuffix(etag, `"`) {
+		return 0, errors.New("not a version ETag")
+	}
+	etag = strings.TrimSuffix(strings.TrimPrefix(etag, `"v`), `"`)
+	// mw.Compression tags the ETag of a compressed response with the encoding
+	etag, _, _ = strings.Cut(etag, "-")
+	return strconv.Atoi(etag)
+}
+
+// popVersion takes the version out of a PATCH body, it isn't a field to update
+func popVersion(updates map[string]any) int {
+	version, _ := updates["version"].(float64)
+	delete(updates, "version")
+	return int(version)
+}
+
+// requireVersions is for bulk patches, where every item is checked against its own version
+func requireVersions(updates []map[string]any) utils.ValidationErrors {
+	var errs utils.ValidationErrors
+	for i, update := range updates {
+		if version, ok := update["version"].(float64); !ok || version < 1 {
+			errs = append(errs, utils.FieldError{Field: fmt.Sprintf("[%d].version", i), Message: "is required"})
+		}
+	}
+	return errs
+}
+
+// writeVersionConflict reports the rows that changed since the client read them: 412 when it
+// asked with If-Match, 409 when it sent the version in the body. It returns false for any
+// other error.
+func writeVersionConflict(w http.ResponseWriter, r *http.Request, err error) bool {
+	var conflict *sqlconnect.ConflictError
+	if !errors.As(err, &conflict) {
+		return false
+	}
+	status := http.StatusConflict
+	if r.Header.Get("If-Match") != "" {
+		status = http.StatusPreconditionFailed
+	}
+
+	response := struct {
+		Status    string                   `json:"status"`
+		Detail    string                   `json:"detail"`
+		Conflicts []models.VersionConflict `json:"conflicts"`
+	}{"conflict", "changed since it was read, fetch it again and reapply the changes", conflict.Conflicts}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		utils.Logger(r.Context()).Error("JSON encoding error", "error", err)
+	}
+	return true
+}
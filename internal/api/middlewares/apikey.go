@@ -0,0 +1,52 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"schoolapi/internal/metrics"
+	"schoolapi/internal/repository/sqlconnect"
+	"schoolapi/pkg/utils"
+	"slices"
+	"strings"
+)
+
+// APIKey authenticates machine clients through the X-API-Key header. A key acts with its role
+// and, when it has scopes, only on those route groups (the first path segment, e.g. "teachers").
+func APIKey(db *sqlconnect.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rawKey := r.Header.Get("X-API-Key")
+			if rawKey == "" {
+				metrics.AuthFailures.WithLabelValues("api_key_missing").Inc()
+				http.Error(w, "API key missing", http.StatusUnauthorized)
+				return
+			}
+
+			key, err := db.AuthenticateAPIKeyDB(r.Context(), rawKey)
+			if err != nil {
+				metrics.AuthFailures.WithLabelValues("api_key_invalid").Inc()
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			if len(key.Scopes) > 0 && !slices.Contains(key.Scopes, "*") && !slices.Contains(key.Scopes, routeGroup(r.URL.Path)) {
+				metrics.AuthFailures.WithLabelValues("api_key_scope").Inc()
+				http.Error(w, "API key not allowed on this route", http.StatusForbidden)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), utils.ContextKey("role"), key.Role)
+			ctx = context.WithValue(ctx, utils.ContextKey("username"), "apikey:"+key.Name)
+			ctx = context.WithValue(ctx, utils.ContextKey("apiKeyID"), key.ID)
+			ctx = context.WithValue(ctx, utils.ContextKey("authMethod"), "apikey")
+			utils.AddLogAttrs(ctx, "api_key_id", key.ID, "auth_method", "apikey")
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func routeGroup(path string) string {
+	group, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return group
+}
@@ -0,0 +1,55 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"schoolapi/pkg/utils"
+	"strings"
+)
+
+// RouteBodyLimit caps bodies for every path starting with Prefix (and to Method only, if set)
+type RouteBodyLimit struct {
+	Method string
+	Prefix string
+	Limit  int64
+}
+
+type BodyLimitOptions struct {
+	Default int64
+	Routes  []RouteBodyLimit
+}
+
+// BodyLimit caps how much of the body later middlewares and handlers can read. It has to run
+// before mw.XSS, which buffers the whole body. Reads past the limit fail with *http.MaxBytesError.
+func BodyLimit(options BodyLimitOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit := bodyLimitFor(r, options)
+			if limit <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// don't bother reading a body that announces it is too large
+			if r.ContentLength > limit {
+				utils.WriteProblem(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body must not exceed %d bytes", limit))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func bodyLimitFor(r *http.Request, options BodyLimitOptions) int64 {
+	limit, longest := options.Default, -1
+	for _, route := range options.Routes {
+		if route.Method != "" && route.Method != r.Method {
+			continue
+		}
+		if strings.HasPrefix(r.URL.Path, route.Prefix) && len(route.Prefix) > longest {
+			limit, longest = route.Limit, len(route.Prefix)
+		}
+	}
+	return limit
+}
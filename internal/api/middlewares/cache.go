@@ -0,0 +1,205 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"schoolapi/pkg/utils"
+	"strings"
+)
+
+type ETagKind int
+
+const (
+	ETagNone ETagKind = iota
+	// ETagStrong is for single resources, whose JSON is byte for byte the same until they change
+	ETagStrong
+	// ETagWeak is for collections, an equivalent list is all a client polling one can ask for
+	ETagWeak
+)
+
+// CachePolicy replaces the no-store SecurityHeaders sets on every response
+type CachePolicy struct {
+	CacheControl string
+	ETag         ETagKind
+}
+
+type CacheOptions struct {
+	// Routes are keyed by the GET route they apply to, e.g. "GET /teachers/{id}"
+	Routes map[string]CachePolicy
+}
+
+// Cache applies the route's cache policy to successful GETs and answers conditional requests:
+// If-None-Match with 304 Not Modified, If-Match on PUT/PATCH/DELETE with 412 Precondition
+// Failed when the resource's current ETag is different. The ETag is a hash of the body, unless
+// the handler set its own (a version), so the response is buffered and, for If-Match, the
+// resource is fetched once more through its GET route. It runs right around the router,
+// inside mw.Compression.
+func Cache(options CacheOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := utils.Route(r.Context())
+			switch r.Method {
+			case http.MethodGet, http.MethodHead:
+				policy, ok := options.Routes[route]
+				if !ok {
+					next.ServeHTTP(w, r)
+					return
+				}
+				serveCached(w, r, next, policy)
+
+			case http.MethodPut, http.MethodPatch, http.MethodDelete:
+				ifMatch := r.Header.Get("If-Match")
+				getRoute := http.MethodGet + strings.TrimPrefix(route, r.Method)
+				policy, ok := options.Routes[getRoute]
+				if ifMatch == "" || !ok || policy.ETag == ETagNone {
+					next.ServeHTTP(w, r)
+					return
+				}
+				etag, found := currentETag(r, next, getRoute, policy)
+				if _, match := matchETag(ifMatch, etag, true); !found || !match {
+					utils.WriteProblem(w, http.StatusPreconditionFailed, "the resource has changed since it was read, fetch it again")
+					return
+				}
+				next.ServeHTTP(w, r)
+
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+func serveCached(w http.ResponseWriter, r *http.Request, next http.Handler, policy CachePolicy) {
+	rec := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+	next.ServeHTTP(rec, r)
+
+	if rec.status != http.StatusOK {
+		w.WriteHeader(rec.status)
+		w.Write(rec.body.Bytes())
+		return
+	}
+
+	if policy.CacheControl != "" {
+		w.Header().Set("Cache-Control", policy.CacheControl)
+	}
+	if etag := w.Header().Get("ETag"); etag != "" || policy.ETag != ETagNone {
+		if etag == "" {
+			etag = makeETag(rec.body.Bytes(), policy.ETag)
+			w.Header().Set("ETag", etag)
+		}
+		if matched, match := matchETag(r.Header.Get("If-None-Match"), etag, false); match {
+			for _, name := range []string{"Content-Type", "Content-Length"} {
+				w.Header().Del(name)
+			}
+			// the client holds the compressed representation, mw.Compression leaves a 304 alone
+			w.Header().Set("ETag", matched)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.WriteHeader(rec.status)
+	w.Write(rec.body.Bytes())
+}
+
+// currentETag runs the resource's GET with the caller's credentials and hashes what it returns
+func currentETag(r *http.Request, next http.Handler, getRoute string, policy CachePolicy) (string, bool) {
+	ctx := context.WithValue(r.Context(), utils.ContextKey("route"), getRoute)
+	get := r.Clone(ctx)
+	get.Method = http.MethodGet
+	get.Body = http.NoBody
+	get.ContentLength = 0
+	get.Header.Del("If-Match")
+	get.Header.Del("If-None-Match")
+	get.Header.Del("Content-Type")
+
+	rec := &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+	next.ServeHTTP(rec, get)
+	if rec.status != http.StatusOK {
+		return "", false
+	}
+	if etag := rec.header.Get("ETag"); etag != "" {
+		return etag, true
+	}
+	return makeETag(rec.body.Bytes(), policy.ETag), true
+}
+
+func makeETag(body []byte, kind ETagKind) string {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if kind == ETagWeak {
+		return "W/" + etag
+	}
+	return etag
+}
+
+// matchETag compares etag against an If-Match/If-None-Match list and returns it as the
+// client holds it. The strong comparison (If-Match) never matches a weak ETag. mw.Compression
+// tags the ETags of compressed responses with the encoding, e.g. "…-gzip". The tag is ignored
+// when comparing, the hash is of the uncompressed body, but kept on the ETag returned.
+func matchETag(list, etag string, strong bool) (string, bool) {
+	if strings.TrimSpace(list) == "" {
+		return "", false
+	}
+	if strings.TrimSpace(list) == "*" {
+		return etag, true
+	}
+	if strong && strings.HasPrefix(etag, "W/") {
+		return "", false
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strings.HasPrefix(candidate, "W/") {
+			if strong {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		untagged, encoding := splitEncodingTag(candidate)
+		if untagged != strings.TrimPrefix(etag, "W/") {
+			continue
+		}
+		if encoding != "" {
+			return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`, true
+		}
+		return etag, true
+	}
+	return "", false
+}
+
+// splitEncodingTag undoes mw.Compression's tag, returning the ETag and the encoding
+func splitEncodingTag(etag string) (string, string) {
+	for _, encoding := range encodings {
+		if strings.HasSuffix(etag, `-`+encoding+`"`) {
+			return strings.TrimSuffix(etag, `-`+encoding+`"`) + `"`, encoding
+		}
+	}
+	return etag, ""
+}
+
+// bufferedResponse holds a response until Cache knows its ETag
+type bufferedResponse struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	if b.wroteHeader || code < 200 {
+		return
+	}
+	b.wroteHeader = true
+	b.status = code
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.wroteHeader = true
+	return b.body.Write(p)
+}
@@ -0,0 +1,63 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"schoolapi/pkg/utils"
+	"strings"
+	"testing"
+)
+
+// A client that got the compressed representation sends its tagged ETag back
+func TestConditionalGetCompressed(t *testing.T) {
+	const route = "GET /students"
+	body := strings.Repeat(`{"first_name":"Ada"},`, 100)
+	list := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	})
+	cache := Cache(CacheOptions{Routes: map[string]CachePolicy{route: {CacheControl: "no-cache", ETag: ETagWeak}}})
+	handler := Compression(CompressionOptions{MinSize: 64, ContentTypes: []string{"application/json"}})(cache(list))
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/students", nil)
+		req = req.WithContext(context.WithValue(req.Context(), utils.ContextKey("route"), route))
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := get(http.Header{"Accept-Encoding": {"gzip"}})
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || first.Header().Get("Content-Encoding") != "gzip" || !strings.HasSuffix(etag, `-gzip"`) {
+		t.Fatalf("first GET = %d, Content-Encoding %q, ETag %q, want a gzipped 200 with a tagged ETag",
+			first.Code, first.Header().Get("Content-Encoding"), etag)
+	}
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		wantCode int
+		wantETag string
+	}{
+		{"tagged ETag", http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {etag}}, http.StatusNotModified, etag},
+		{"tagged ETag in a list", http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {`"other", ` + etag}}, http.StatusNotModified, etag},
+		{"untagged ETag", http.Header{"If-None-Match": {strings.TrimSuffix(etag, `-gzip"`) + `"`}}, http.StatusNotModified, strings.TrimSuffix(etag, `-gzip"`) + `"`},
+		{"changed resource", http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {`W/"0123456789abcdef0123456789abcdef-gzip"`}}, http.StatusOK, etag},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(tt.header)
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := rec.Header().Get("ETag"); got != tt.wantETag {
+				t.Errorf("ETag = %q, want %q", got, tt.wantETag)
+			}
+		})
+	}
+}
@@ -1,40 +1,252 @@
 package middlewares
 
 import (
+	"bufio"
+	"bytes"
+	"compress/flate"
 	"compress/gzip"
-	"fmt"
+	"io"
+	"mime"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
 )
 
-func Compression(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// check if the request has the header that says the client accepts zipped content
-		if !isCompressedAccepted(r) {
-			next.ServeHTTP(w, r)
-		}
-		w.Header().Set("Content-Encoding", "gzip")
-		gz := gzip.NewWriter(w)
-		defer gz.Close()
-		// wrap the Responsewriter
+type CompressionOptions struct {
+	// MinSize is the smallest body worth compressing, smaller ones are sent as they are
+	MinSize int
+	// ContentTypes are the media types compressed, "text/" style entries match a whole type.
+	// Images, archives and the like are already compressed.
+	ContentTypes []string
+}
+
+// encoder is what every supported encoding's writer provides, so they can share one pool type
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
 
-		w = &gzipResponseWriter{ResponseWriter: w, Writer: gz}
+// encodings in order of preference when the client rates several the same
+var encodings = []string{"br", "zstd", "gzip", "deflate"}
 
-		next.ServeHTTP(w, r)
-		fmt.Println("Sent response from Compress middleware")
-	})
+var encoderPools = map[string]*sync.Pool{
+	"br": {New: func() any { return brotli.NewWriterLevel(nil, 4) }},
+	"zstd": {New: func() any {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedDefault))
+		return enc
+	}},
+	"gzip": {New: func() any { return gzip.NewWriter(nil) }},
+	"deflate": {New: func() any {
+		enc, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return enc
+	}},
 }
 
-func isCompressedAccepted(r *http.Request) bool {
-	return strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
+// Compression encodes responses with the best encoding the client accepts (Accept-Encoding
+// q-values), once the body is known to be large enough and of a compressible type. It buffers
+// up to MinSize bytes to decide, a Flush before that decides on the spot.
+func Compression(options CompressionOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the response depends on Accept-Encoding even when it ends up uncompressed
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, options: options, encoding: encoding, status: http.StatusOK}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
 }
 
-// gzip response writer wraps http.ResponseWriter to write gzipped responses
-type gzipResponseWriter struct {
+// negotiateEncoding picks the encoding with the highest q-value, "" means identity
+func negotiateEncoding(header string) string {
+	if header == "" {
+		return ""
+	}
+	q := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		value := 1.0
+		if name, raw, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+			if err != nil {
+				continue
+			}
+			value = parsed
+		}
+		q[coding] = value
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range encodings {
+		value, ok := q[encoding]
+		if !ok {
+			value, ok = q["*"]
+		}
+		if ok && value > bestQ {
+			best, bestQ = encoding, value
+		}
+	}
+	return best
+}
+
+type compressWriter struct {
 	http.ResponseWriter
-	Writer *gzip.Writer
+	options  CompressionOptions
+	encoding string
+	status   int
+
+	buf         bytes.Buffer
+	decided     bool
+	wroteHeader bool
+	enc         encoder
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	// informational responses go out at once, the final one waits for the decision
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = code
+	if !bodyAllowed(code) {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	cw.wroteHeader = true
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.buf.Write(b)
+	if cw.buf.Len() >= cw.options.MinSize {
+		if err := cw.decide(cw.compressible()); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide sends the headers, with or without compression, and whatever was buffered
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	header := cw.Header()
+	if header.Get("Content-Type") == "" && cw.buf.Len() > 0 && bodyAllowed(cw.status) {
+		// net/http would sniff it too, but only after we decided
+		header.Set("Content-Type", http.DetectContentType(cw.buf.Bytes()))
+	}
+	if compress {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+		// the compressed bytes are a different representation, see matchETag
+		if etag := header.Get("ETag"); strings.HasSuffix(etag, `"`) {
+			header.Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+cw.encoding+`"`)
+		}
+		cw.enc = encoderPools[cw.encoding].Get().(encoder)
+		cw.enc.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if cw.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(cw.buf.Bytes())
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf.Bytes())
+	}
+	cw.buf.Reset()
+	return err
+}
+
+func (cw *compressWriter) compressible() bool {
+	header := cw.Header()
+	if !bodyAllowed(cw.status) || header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(cw.buf.Bytes())
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range cw.options.ContentTypes {
+		if allowed == mediaType || (strings.HasSuffix(allowed, "/") && strings.HasPrefix(mediaType, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// close runs once the handler returned: a body that stayed under MinSize goes out as it is
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if !cw.wroteHeader {
+			return
+		}
+		cw.decide(false)
+	}
+	if cw.enc != nil {
+		cw.enc.Close()
+		cw.enc.Reset(nil)
+		encoderPools[cw.encoding].Put(cw.enc)
+		cw.enc = nil
+	}
+}
+
+// Flush is for streamed responses, they are compressed by type whatever their size so far
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(cw.compressible())
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(cw.ResponseWriter).Hijack()
+	if err == nil {
+		// the connection is the caller's from now on, close must not write anything
+		cw.decided = true
+	}
+	return conn, rw, err
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
 }
 
-func (g *gzipResponseWriter) Write(b []byte) (int, error) {
-	return g.Writer.Write(b)
+func bodyAllowed(status int) bool {
+	return status != http.StatusNoContent && status != http.StatusNotModified && status >= 200
 }
@@ -1,46 +1,123 @@
 package middlewares
 
 import (
-	"fmt"
 	"net/http"
 	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
-// Allowed orgins
-var allowedOrigins = []string{
-	"https://my-origin.com",
-	"https://their-origin.com",
-	"https://localhost:3000",
+type CorsOptions struct {
+	// AllowedOrigins are exact origins, "*" for any origin, or wildcard subdomain patterns
+	// like "https://*.example.com" (matches https://app.example.com but not https://example.com).
+	// With "*" credentials are never allowed.
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
 }
 
-func Cors(next http.Handler) http.Handler {
+type cors struct {
+	options atomic.Pointer[CorsOptions]
+}
+
+func NewCors(options CorsOptions) *cors {
+	c := &cors{}
+	c.options.Store(&options)
+	return c
+}
+
+// Update swaps the policy without a restart
+func (c *cors) Update(options CorsOptions) {
+	c.options.Store(&options)
+}
+
+// Middleware answers preflights itself, so it has to run before authentication. Requests without
+// an Origin header are not from a browser and pass through untouched.
+func (c *cors) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		options := c.options.Load()
+		w.Header().Add("Vary", "Origin")
+
 		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-		if isOriginAllowed(origin) {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-		} else {
+		if !isOriginAllowed(origin, options.AllowedOrigins) {
 			http.Error(w, "Not allowed by CORS", http.StatusForbidden)
 			return
 		}
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		w.Header().Set("Access-Control-Expose-Headers", "Authorization")
-		w.Header().Set("Access-Control-Max-Age", "3600")
+
+		// "*" is sent as is and never with credentials, so any origin can't make credentialed
+		// requests. Config validation refuses that combination, this covers options set in code.
+		if slices.Contains(options.AllowedOrigins, "*") {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if options.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
 
 		// this is to check if the req is a pre-flight request. In that case, CORS middleware can handle it, we do not need to proceed any further to sending the real response
+		requestedMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method == http.MethodOptions && requestedMethod != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
 
-		if r.Method == http.MethodOptions {
+			if !containsFold(options.AllowedMethods, requestedMethod) {
+				http.Error(w, "Method not allowed by CORS", http.StatusForbidden)
+				return
+			}
+			for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+				if header = strings.TrimSpace(header); header != "" && !containsFold(options.AllowedHeaders, header) {
+					http.Error(w, "Header not allowed by CORS", http.StatusForbidden)
+					return
+				}
+			}
+
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(options.AllowedMethods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(options.AllowedHeaders, ", "))
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(options.MaxAge.Seconds())))
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
-		fmt.Println("Sent response from Cors middleware")
+		if len(options.ExposedHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(options.ExposedHeaders, ", "))
+		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
-func isOriginAllowed(o string) bool {
-	return slices.Contains(allowedOrigins, o)
+func isOriginAllowed(origin string, allowedOrigins []string) bool {
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		scheme, pattern, ok := strings.Cut(allowed, "://*.")
+		if !ok {
+			continue
+		}
+		// the origin must be a subdomain of the pattern's domain under the same scheme
+		suffix := "." + strings.ToLower(pattern)
+		rest, found := strings.CutPrefix(strings.ToLower(origin), strings.ToLower(scheme)+"://")
+		if found && strings.HasSuffix(rest, suffix) && len(rest) > len(suffix) && !strings.ContainsAny(rest, "/@") {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	return slices.ContainsFunc(list, func(item string) bool {
+		return strings.EqualFold(item, s)
+	})
 }
@@ -0,0 +1,37 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCorsAnyOrigin(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name            string
+		origins         []string
+		wantOrigin      string
+		wantCredentials string
+	}{
+		{"any origin is never credentialed", []string{"*"}, "*", ""},
+		{"listed origin is echoed", []string{"https://app.example.com"}, "https://app.example.com", "true"},
+		{"subdomain pattern is echoed", []string{"https://*.example.com"}, "https://app.example.com", "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewCors(CorsOptions{AllowedOrigins: tt.origins, AllowCredentials: true}).Middleware(ok)
+			req := httptest.NewRequest(http.MethodGet, "/students", nil)
+			req.Header.Set("Origin", "https://app.example.com")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want %q", got, tt.wantCredentials)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package middlewares
+
+import (
+	"net/http"
+	"schoolapi/internal/config"
+	"schoolapi/internal/metrics"
+	"schoolapi/pkg/utils"
+)
+
+// CSRF checks the X-CSRF-Token header on unsafe methods when the caller authenticated with the
+// Bearer cookie, which the browser attaches on its own. API key and SCIM callers send their
+// credentials explicitly and are not exposed. Must run after mw.JWT.
+func CSRF(cfg config.AuthConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if authMethod, _ := r.Context().Value(utils.ContextKey("authMethod")).(string); authMethod != "cookie" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			sessionId, _ := r.Context().Value(utils.ContextKey("sessionID")).(string)
+			if !utils.VerifyCSRFToken([]byte(cfg.JWTSecret), sessionId, r.Header.Get("X-CSRF-Token")) {
+				metrics.AuthFailures.WithLabelValues("csrf").Inc()
+				http.Error(w, "invalid CSRF token", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
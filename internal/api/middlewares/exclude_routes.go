@@ -1,7 +1,6 @@
 package middlewares
 
 import (
-	"fmt"
 	"net/http"
 	"strings"
 )
@@ -16,7 +15,6 @@ func ExcludePaths(middleware func(http.Handler) http.Handler, excludedPaths ...s
 					return
 				}
 			}
-			fmt.Println("Sent response from Cors middleware")
 			middleware(next).ServeHTTP(w, r)
 		})
 	}
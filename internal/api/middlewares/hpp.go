@@ -16,74 +16,260 @@ An attacker might craft a URL with multiple "category" parameters. If the applic
 package middlewares
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"schoolapi/pkg/utils"
+	"slices"
 	"strings"
 )
 
+type HPPMultiValue string
+
+const (
+	HPPKeepFirst HPPMultiValue = "first"
+	HPPKeepLast  HPPMultiValue = "last"
+	HPPReject    HPPMultiValue = "reject"
+	// HPPKeepAll is for params that are meant to repeat, like sort_by
+	HPPKeepAll HPPMultiValue = "all"
+)
+
+// HPPRoute sets the whitelist for paths starting with Prefix (and Method only, if set).
+// MultiValue overrides how repeated params or duplicate JSON keys are handled, per name.
+type HPPRoute struct {
+	Method     string
+	Prefix     string
+	WhiteList  []string
+	MultiValue map[string]HPPMultiValue
+}
+
 type HPPOptions struct {
 	CheckQuery                  bool
 	CheckBody                   bool
 	CheckBodyOnlyForContentType string
-	WhiteList                   []string
+	// CheckJSONBody looks for duplicate keys in JSON objects, which encoding/json would
+	// otherwise resolve silently by keeping the last one
+	CheckJSONBody bool
+	// WhiteList applies to routes without their own, nil lets every param through
+	WhiteList  []string
+	MultiValue HPPMultiValue
+	Routes     []HPPRoute
+}
+
+type hppPolicy struct {
+	whiteList  []string
+	multiValue HPPMultiValue
+	perParam   map[string]HPPMultiValue
+}
+
+func (p hppPolicy) allowed(param string) bool {
+	return p.whiteList == nil || slices.Contains(p.whiteList, param)
+}
+
+func (p hppPolicy) multiValueFor(param string) HPPMultiValue {
+	if mv, ok := p.perParam[param]; ok {
+		return mv
+	}
+	return p.multiValue
 }
 
 // This is a higher‑order function returning a closure — a very common Go idiom for building middleware.
 func Hpp(options HPPOptions) func(http.Handler) http.Handler {
+	if options.MultiValue == "" {
+		options.MultiValue = HPPKeepFirst
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy := hppPolicyFor(r, options)
+
 			if options.CheckBody && r.Method == http.MethodPost && isCorrectContentType(r, options.CheckBodyOnlyForContentType) {
-				filterBodyParams(r, options.WhiteList)
+				if err := filterBodyParams(r, policy); err != nil {
+					writeHPPError(w, err)
+					return
+				}
+			}
+			if options.CheckJSONBody && r.Body != nil && isJSONContentType(r.Header.Get("Content-Type")) {
+				if err := checkJSONBody(r, policy); err != nil {
+					writeHPPError(w, err)
+					return
+				}
 			}
 			if options.CheckQuery && r.URL.Query() != nil {
-				filterQueryParams(r, options.WhiteList)
+				if err := filterQueryParams(r, policy); err != nil {
+					writeHPPError(w, err)
+					return
+				}
 			}
-			fmt.Println("Sent response from Hpp middleware")
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+func hppPolicyFor(r *http.Request, options HPPOptions) hppPolicy {
+	policy := hppPolicy{whiteList: options.WhiteList, multiValue: options.MultiValue}
+	longest := -1
+	for _, route := range options.Routes {
+		if route.Method != "" && route.Method != r.Method {
+			continue
+		}
+		if strings.HasPrefix(r.URL.Path, route.Prefix) && len(route.Prefix) > longest {
+			longest = len(route.Prefix)
+			policy.whiteList = route.WhiteList
+			policy.perParam = route.MultiValue
+		}
+	}
+	return policy
+}
+
+func writeHPPError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		utils.WriteProblem(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body must not exceed %d bytes", tooLarge.Limit))
+		return
+	}
+	utils.WriteProblem(w, http.StatusBadRequest, err.Error())
+}
+
 func isCorrectContentType(r *http.Request, contentType string) bool {
 	return strings.Contains(r.Header.Get("Content-Type"), contentType)
 }
 
-func filterBodyParams(r *http.Request, whiteList []string) {
+func filterBodyParams(r *http.Request, policy hppPolicy) error {
 	err := r.ParseForm()
 	if err != nil {
-		fmt.Println(err)
-		return
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return err
+		}
+		utils.Logger(r.Context()).Debug("Error parsing form body", "error", err)
+		return nil
 	}
+	return filterValues(r.Form, policy)
+}
+
+func filterQueryParams(r *http.Request, policy hppPolicy) error {
+	query := r.URL.Query()
+	if err := filterValues(query, policy); err != nil {
+		return err
+	}
+	r.URL.RawQuery = query.Encode()
+	return nil
+}
 
-	for k, v := range r.Form {
-		if len(v) > 1 {
-			r.Form.Set(k, v[0])
+func filterValues(values url.Values, policy hppPolicy) error {
+	for k, v := range values {
+		if !policy.allowed(k) {
+			values.Del(k)
+			continue
 		}
-		if !isWhiteListed(k, whiteList) {
-			delete(r.Form, k)
+		if len(v) < 2 {
+			continue
+		}
+		switch policy.multiValueFor(k) {
+		case HPPReject:
+			return fmt.Errorf("parameter %q must not be repeated", k)
+		case HPPKeepLast:
+			values.Set(k, v[len(v)-1])
+		case HPPKeepAll:
+		default:
+			values.Set(k, v[0])
 		}
 	}
+	return nil
 }
 
-func filterQueryParams(r *http.Request, whiteList []string) {
-	query := r.URL.Query()
+// checkJSONBody walks the body token by token, since decoding into a map would already have
+// collapsed duplicate keys. The body is only rewritten when a duplicate was dropped.
+func checkJSONBody(r *http.Request, policy hppPolicy) error {
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+	if len(bytes.TrimSpace(bodyBytes)) == 0 {
+		return nil
+	}
 
-	for k, v := range query {
-		if len(v) > 1 {
-			query.Set(k, v[0])
+	decoder := json.NewDecoder(bytes.NewReader(bodyBytes))
+	decoder.UseNumber()
+	value, changed, err := readJSONValue(decoder, policy)
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// malformed JSON is reported further down the chain
+			return nil
 		}
-		if !isWhiteListed(k, whiteList) {
-			query.Del(k)
+		return err
+	}
+	// trailing data is rejected further down the chain, rewriting would silently drop it
+	if _, err := decoder.Token(); changed && err == io.EOF {
+		rewritten, err := json.Marshal(value)
+		if err != nil {
+			return err
 		}
+		r.Body = io.NopCloser(bytes.NewReader(rewritten))
 	}
-	r.URL.RawQuery = query.Encode()
+	return nil
 }
 
-func isWhiteListed(param string, whitelist []string) bool {
-	for _, v := range whitelist {
-		if param == v {
-			return true
+func readJSONValue(decoder *json.Decoder, policy hppPolicy) (any, bool, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, false, err
+	}
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return token, false, nil
+	}
+
+	changed := false
+	switch delim {
+	case '{':
+		object := make(map[string]any)
+		for decoder.More() {
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return nil, false, err
+			}
+			key, _ := keyToken.(string)
+			value, valueChanged, err := readJSONValue(decoder, policy)
+			if err != nil {
+				return nil, false, err
+			}
+			changed = changed || valueChanged
+
+			if _, duplicate := object[key]; duplicate {
+				switch policy.multiValueFor(key) {
+				case HPPReject:
+					return nil, false, fmt.Errorf("key %q must not be repeated", key)
+				case HPPKeepLast, HPPKeepAll:
+					// same as encoding/json, nothing to rewrite
+				default:
+					changed = true
+					continue
+				}
+			}
+			object[key] = value
+		}
+		_, err = decoder.Token()
+		return object, changed, err
+	case '[':
+		array := []any{}
+		for decoder.More() {
+			value, valueChanged, err := readJSONValue(decoder, policy)
+			if err != nil {
+				return nil, false, err
+			}
+			changed = changed || valueChanged
+			array = append(array, value)
 		}
+		_, err = decoder.Token()
+		return array, changed, err
 	}
-	return false
+	return nil, false, fmt.Errorf("unexpected %v in JSON body", delim)
 }
@@ -3,55 +3,91 @@ package middlewares
 import (
 	"context"
 	"errors"
-	"log"
 	"net/http"
-	"os"
+	"schoolapi/internal/config"
+	"schoolapi/internal/metrics"
+	"schoolapi/internal/repository/sqlconnect"
+	"schoolapi/internal/store"
 	"schoolapi/pkg/utils"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func JWT(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token, err := r.Cookie("Bearer")
-		if err != nil {
-			http.Error(w, "authorization header missing", http.StatusUnauthorized)
-			return
-		}
-
-		jwtSecret := os.Getenv("JWT_SECRET")
-
-		parsedToken, err := jwt.Parse(token.Value, func(t *jwt.Token) (any, error) {
-			return []byte(jwtSecret), nil
-		}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
-		if err != nil {
-			if errors.Is(err, jwt.ErrTokenExpired) {
-				http.Error(w, "token expired", http.StatusUnauthorized)
-				return
-			}
-			utils.ErrorHandler(err, "")
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
-		}
-
-		if parsedToken.Valid {
-			log.Println("Valid JWT")
-		} else {
-			http.Error(w, "Invalid JWT", http.StatusUnauthorized)
-			return
-		}
-
-		claims, ok := parsedToken.Claims.(jwt.MapClaims)
-		if !ok {
-			http.Error(w, "Invalid JWT", http.StatusUnauthorized)
-			return
-		}
-
-		ctx := context.WithValue(r.Context(), utils.ContextKey("role"), claims["role"])
-		ctx = context.WithValue(ctx, utils.ContextKey("expiresAt"), claims["exp"])
-		ctx = context.WithValue(ctx, utils.ContextKey("username"), claims["user"])
-		ctx = context.WithValue(ctx, utils.ContextKey("userID"), claims["uid"])
-
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// JWT authenticates the Bearer cookie. Sessions in the revocations denylist are rejected
+// before the database is asked.
+func JWT(cfg config.AuthConfig, revocations store.Store, db *sqlconnect.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// machine clients authenticate with an API key instead of the session cookie
+			if r.Header.Get("X-API-Key") != "" {
+				APIKey(db)(next).ServeHTTP(w, r)
+				return
+			}
+
+			token, err := r.Cookie("Bearer")
+			if err != nil {
+				metrics.AuthFailures.WithLabelValues("token_missing").Inc()
+				http.Error(w, "authorization header missing", http.StatusUnauthorized)
+				return
+			}
+
+			parsedToken, err := jwt.Parse(token.Value, func(t *jwt.Token) (any, error) {
+				return []byte(cfg.JWTSecret), nil
+			}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+			if err != nil {
+				if errors.Is(err, jwt.ErrTokenExpired) {
+					metrics.AuthFailures.WithLabelValues("token_expired").Inc()
+					http.Error(w, "token expired", http.StatusUnauthorized)
+					return
+				}
+				utils.ErrorHandler(err, "")
+				metrics.AuthFailures.WithLabelValues("token_invalid").Inc()
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			if !parsedToken.Valid {
+				metrics.AuthFailures.WithLabelValues("token_invalid").Inc()
+				http.Error(w, "Invalid JWT", http.StatusUnauthorized)
+				return
+			}
+
+			claims, ok := parsedToken.Claims.(jwt.MapClaims)
+			if !ok {
+				metrics.AuthFailures.WithLabelValues("token_invalid").Inc()
+				http.Error(w, "Invalid JWT", http.StatusUnauthorized)
+				return
+			}
+
+			sessionId, _ := claims["sid"].(string)
+			if sessionId == "" {
+				metrics.AuthFailures.WithLabelValues("token_invalid").Inc()
+				http.Error(w, "Invalid JWT", http.StatusUnauthorized)
+				return
+			}
+			// the denylist is shared between replicas, so a logout anywhere takes effect without a DB hit
+			if revoked, err := revocations.IsRevoked(r.Context(), sessionId); err != nil {
+				utils.Logger(r.Context()).Error("Revocation store error", "error", err)
+			} else if revoked {
+				metrics.AuthFailures.WithLabelValues("session_revoked").Inc()
+				http.Error(w, "session has been revoked", http.StatusUnauthorized)
+				return
+			}
+			if err := db.TouchSessionDB(r.Context(), sessionId); err != nil {
+				metrics.AuthFailures.WithLabelValues("session_invalid").Inc()
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), utils.ContextKey("role"), claims["role"])
+			ctx = context.WithValue(ctx, utils.ContextKey("expiresAt"), claims["exp"])
+			ctx = context.WithValue(ctx, utils.ContextKey("username"), claims["user"])
+			ctx = context.WithValue(ctx, utils.ContextKey("userID"), claims["uid"])
+			ctx = context.WithValue(ctx, utils.ContextKey("sessionID"), sessionId)
+			ctx = context.WithValue(ctx, utils.ContextKey("authMethod"), "cookie")
+			utils.AddLogAttrs(ctx, "user_id", claims["uid"], "auth_method", "cookie")
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
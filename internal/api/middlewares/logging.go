@@ -0,0 +1,38 @@
+package middlewares
+
+import (
+	"log/slog"
+	"net/http"
+	"schoolapi/pkg/utils"
+	"time"
+)
+
+// Logging gives every request its own logger, carrying the method, path and route, and
+// writes one access log record once the response is done. Later layers add the request id
+// and user.
+func Logging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		logger := slog.Default().With("method", r.Method, "path", r.URL.Path)
+		if route := utils.Route(r.Context()); route != "" {
+			logger = logger.With("route", route)
+		}
+		ctx := utils.WithLogger(r.Context(), logger)
+
+		wrappedWriter := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(wrappedWriter, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		switch {
+		case wrappedWriter.status >= 500:
+			level = slog.LevelError
+		case wrappedWriter.status >= 400:
+			level = slog.LevelWarn
+		}
+		utils.Logger(ctx).Log(ctx, level, "request completed",
+			"status", wrappedWriter.status,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"client_ip", utils.ClientIP(r),
+		)
+	})
+}
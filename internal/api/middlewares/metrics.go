@@ -0,0 +1,34 @@
+package middlewares
+
+import (
+	"net/http"
+	"schoolapi/internal/metrics"
+	"schoolapi/pkg/utils"
+	"strconv"
+	"time"
+)
+
+// Metrics counts requests and their latency by route pattern, method and status. Requests no
+// route matches share one "unmatched" series so random paths can't blow up the cardinality.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		wrappedWriter := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(wrappedWriter, r)
+
+		route := utils.Route(r.Context())
+		if route == "" {
+			route = "unmatched"
+		}
+		method := r.Method
+		switch method {
+		case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		default:
+			method = "OTHER"
+		}
+		status := strconv.Itoa(wrappedWriter.status)
+
+		metrics.HTTPRequests.WithLabelValues(route, method, status).Inc()
+		metrics.HTTPDuration.WithLabelValues(route, method, status).Observe(time.Since(start).Seconds())
+	})
+}
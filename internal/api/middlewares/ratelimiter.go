@@ -2,62 +2,126 @@ package middlewares
 
 import (
 	"fmt"
+	"math"
 	"net/http"
-	"sync"
+	"schoolapi/internal/metrics"
+	"schoolapi/internal/store"
+	"schoolapi/pkg/utils"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
-type rateLimiter struct {
-	mu        sync.Mutex
-	visitors  map[string]int
-	limit     int
-	resetTime time.Duration
+// RateLimitPolicy allows Limit requests per Window. Requests are metered with a token bucket
+// holding up to Limit tokens that refills continuously, so bursts are allowed but the
+// long-run rate never exceeds Limit/Window.
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
 }
 
-func NewRateLimiter(limit int, resetTime time.Duration) *rateLimiter {
-	rl := &rateLimiter{
-		visitors:  make(map[string]int),
-		limit:     limit,
-		resetTime: resetTime,
-	}
-	go rl.resetVisitorCount()
-	return rl
+// RouteRateLimit applies a policy to every path starting with Prefix (and to Method only, if set)
+type RouteRateLimit struct {
+	Method string
+	Prefix string
+	Policy RateLimitPolicy
 }
 
-func (rl *rateLimiter) resetVisitorCount() {
-	for {
-		time.Sleep(rl.resetTime)
-		rl.mu.Lock()
-		rl.visitors = make(map[string]int) // why though? more on that below
-		rl.mu.Unlock()
-	}
+type RateLimiterOptions struct {
+	Default RateLimitPolicy
+	Routes  []RouteRateLimit
+	// Store holds the buckets, share a Redis store so replicas enforce one limit between them
+	Store store.Store
 }
 
-/*
-Why replace the map instead of clearing keys?
-Two main reasons:
+type rateLimiter struct {
+	store    store.Store
+	policies atomic.Pointer[RateLimiterOptions]
+}
 
-Performance:
-Instead of iterating through every entry and resetting counts to zero, just allocate a new map.
-This is cheap and fast in Go (maps are reference types; dropping the old one lets the GC reclaim it eventually).
+func NewRateLimiter(options RateLimiterOptions) *rateLimiter {
+	if options.Store == nil {
+		options.Store = store.NewMemory()
+	}
+	rl := &rateLimiter{store: options.Store}
+	rl.policies.Store(&options)
+	return rl
+}
 
-Simplicity:
-No need to keep track of which users to remove. Everything is gone in one shot.
-*/
+// Update swaps the policies without a restart. Buckets are kept, the store can't be changed.
+func (rl *rateLimiter) Update(options RateLimiterOptions) {
+	rl.policies.Store(&options)
+}
 
+// Middleware limits each caller per policy. The caller is the authenticated user or API key
+// when mw.JWT ran before it, otherwise the client IP.
 func (rl *rateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		rl.mu.Lock()
-		defer rl.mu.Unlock()
+		policy := rl.policyFor(r)
+		if policy.Limit <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		result, err := rl.store.Take(r.Context(), policy.Name+"|"+rateLimitKey(r), policy.Limit, policy.Window)
+		if err != nil {
+			// an unreachable store shouldn't take the API down with it
+			utils.Logger(r.Context()).Error("Rate limit store error", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
 
-		visitorIP := r.RemoteAddr
-		rl.visitors[visitorIP]++
-		fmt.Printf("Visitor count from %v is %v\n", visitorIP, rl.visitors[visitorIP])
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, int(policy.Window.Seconds())))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(policy.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
 
-		if rl.visitors[visitorIP] > rl.limit {
-			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		if !result.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			metrics.RateLimitRejections.WithLabelValues(policy.Name).Inc()
+			utils.WriteProblem(w, http.StatusTooManyRequests, fmt.Sprintf("too many requests, retry after %d seconds", ceilSeconds(result.RetryAfter)))
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+func (rl *rateLimiter) policyFor(r *http.Request) RateLimitPolicy {
+	options := rl.policies.Load()
+	best := -1
+	for i, route := range options.Routes {
+		if route.Method != "" && route.Method != r.Method {
+			continue
+		}
+		if !strings.HasPrefix(r.URL.Path, route.Prefix) {
+			continue
+		}
+		if best < 0 || len(route.Prefix) > len(options.Routes[best].Prefix) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return options.Default
+	}
+	policy := options.Routes[best].Policy
+	if policy.Name == "" {
+		policy.Name = options.Routes[best].Prefix
+	}
+	return policy
+}
+
+func rateLimitKey(r *http.Request) string {
+	if uid, ok := r.Context().Value(utils.ContextKey("userID")).(string); ok && uid != "" {
+		return "user:" + uid
+	}
+	if keyId, ok := r.Context().Value(utils.ContextKey("apiKeyID")).(int); ok {
+		return "apikey:" + strconv.Itoa(keyId)
+	}
+	return "ip:" + utils.ClientIP(r)
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,68 @@
+package middlewares
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"schoolapi/pkg/utils"
+	"strings"
+)
+
+// RealIP resolves the client address once for the rest of the chain (see utils.ClientIP).
+// X-Forwarded-For is only honoured when the peer is one of the trusted proxies; the list is
+// then walked right to left and the first address that is not a trusted proxy is the client.
+func RealIP(trustedProxies []string) func(http.Handler) http.Handler {
+	var trusted []*net.IPNet
+	for _, cidr := range trustedProxies {
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			slog.Warn("Ignoring invalid trusted proxy", "proxy", cidr, "error", err)
+			continue
+		}
+		trusted = append(trusted, network)
+	}
+
+	isTrusted := func(ip net.IP) bool {
+		for _, network := range trusted {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			client := host
+
+			if peer := net.ParseIP(host); peer != nil && isTrusted(peer) {
+				hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+				for i := len(hops) - 1; i >= 0; i-- {
+					hop := net.ParseIP(strings.TrimSpace(hops[i]))
+					if hop == nil {
+						break
+					}
+					client = hop.String()
+					if !isTrusted(hop) {
+						break
+					}
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), utils.ContextKey("clientIP"), client)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
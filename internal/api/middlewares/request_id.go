@@ -0,0 +1,72 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"schoolapi/pkg/utils"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// client-supplied ids end up in logs and headers, so only plain tokens are taken as they are
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:\-]{1,128}$`)
+
+// version 00 of https://www.w3.org/TR/trace-context/#traceparent-header
+var validTraceparent = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+// RequestID takes the client's X-Request-ID, or makes one up, and joins the client's trace
+// (W3C traceparent) or starts a new one. Both go into the context, the request's logger and
+// the response headers, so a client can quote them when reporting an error. When tracing is
+// on, the ids of the request's span (see tracing.Server) are used instead.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(requestID) {
+			requestID = randomHex(16)
+		}
+
+		var traceID, traceparent string
+		if span := trace.SpanFromContext(r.Context()); span.IsRecording() {
+			sc := span.SpanContext()
+			traceID = sc.TraceID().String()
+			traceparent = "00-" + traceID + "-" + sc.SpanID().String() + "-" + sc.TraceFlags().String()
+		} else {
+			var flags string
+			traceID, flags = parseTraceparent(r.Header.Get("traceparent"))
+			if traceID == "" {
+				traceID, flags = randomHex(16), "01"
+			}
+			// this server is the parent of anything it calls on the request's behalf
+			traceparent = "00-" + traceID + "-" + randomHex(8) + "-" + flags
+		}
+
+		ctx := context.WithValue(r.Context(), utils.ContextKey("requestID"), requestID)
+		ctx = context.WithValue(ctx, utils.ContextKey("traceparent"), traceparent)
+		utils.AddLogAttrs(ctx, "request_id", requestID, "trace_id", traceID)
+
+		w.Header().Set("X-Request-ID", requestID)
+		w.Header().Set("traceparent", traceparent)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// parseTraceparent returns the trace id and flags, or "" for a missing or invalid header
+func parseTraceparent(header string) (string, string) {
+	match := validTraceparent.FindStringSubmatch(strings.TrimSpace(header))
+	if match == nil || strings.Trim(match[1], "0") == "" || strings.Trim(match[2], "0") == "" {
+		return "", ""
+	}
+	return match[1], match[3]
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return strings.Repeat("0", 2*n)
+	}
+	return hex.EncodeToString(b)
+}
@@ -1,28 +1,44 @@
 package middlewares
 
 import (
-	"fmt"
 	"net/http"
 	"time"
 )
 
+// ResponseTime sets X-Response-Time to the time taken until the headers went out, which is
+// the last moment a header can still be added
 func ResponseTime(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("Received request in Response Time middleware")
-		start := time.Now()
-		// Create a custome response writer to capture the status code
-		wrappedWriter := &responseWriter{ResponseWriter: w, status: http.StatusOK}
-		next.ServeHTTP(wrappedWriter, r)
-		// Calculate the duration
-		duration := time.Since(start)
-		wrappedWriter.Header().Set("X-Response-Time", duration.String())
-
-		// Log request details
-		fmt.Printf("Method; %s, URL: %s, Status: %d, Duration: %v\n", r.Method, r.URL, wrappedWriter.status, duration.String())
-		fmt.Println("Sent response from Response Time middleware")
+		next.ServeHTTP(&timingWriter{ResponseWriter: w, start: time.Now()}, r)
 	})
 }
 
+type timingWriter struct {
+	http.ResponseWriter
+	start       time.Time
+	wroteHeader bool
+}
+
+func (tw *timingWriter) WriteHeader(code int) {
+	if !tw.wroteHeader {
+		tw.wroteHeader = true
+		tw.Header().Set("X-Response-Time", time.Since(tw.start).String())
+	}
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *timingWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+func (tw *timingWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// responseWriter records the status code for the access log and metrics
 type responseWriter struct {
 	http.ResponseWriter
 	status int
@@ -32,3 +48,7 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.status = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
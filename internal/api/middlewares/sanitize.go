@@ -3,151 +3,316 @@ package middlewares
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"io"
-	"log"
+	"log/slog"
+	"mime"
 	"net/http"
 	"net/url"
 	"schoolapi/pkg/utils"
+	"sort"
 	"strings"
 
 	"github.com/microcosm-cc/bluemonday"
 )
 
-func XSS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// sanitize the URL path
-		sanitizedPath, err := clean(r.URL.Path)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
+type XSSAction string
 
-		fmt.Println("Original path:", r.URL.Path)
-		fmt.Println("sanitizedPath:", sanitizedPath)
+const (
+	// XSSAllow passes the value through untouched, for passwords and other opaque secrets
+	XSSAllow XSSAction = "allow"
+	// XSSStrip removes markup but keeps the text as typed, "O'Brien & Sons" is stored as is
+	XSSStrip XSSAction = "strip"
+	// XSSReject answers 400 when a value contains markup
+	XSSReject XSSAction = "reject"
+	// XSSEncode stores input untouched and HTML-escapes the strings of JSON responses instead
+	XSSEncode XSSAction = "encode"
+)
 
-		params := r.URL.Query()
-		sanitizedQuery := make(map[string][]string)
-		for k, values := range params {
-			sanitizedKey, err := clean(k)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
+// XSSRoute overrides the action for paths starting with Prefix (and Method only, if set).
+// Fields override it again for individual JSON fields (at any depth) and query parameters.
+type XSSRoute struct {
+	Method string
+	Prefix string
+	Action XSSAction
+	Fields map[string]XSSAction
+}
 
-			var sanitizedValues []string
-			for _, value := range values {
-				cleanValue, err := clean(value)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusBadRequest)
-					return
-				}
-				strValue, ok := cleanValue.(string)
-				if !ok {
-					http.Error(w, "sanitized value is not a string", http.StatusBadRequest)
-					return
-				}
-				sanitizedValues = append(sanitizedValues, strValue)
-			}
-			sanitizedQuery[sanitizedKey.(string)] = sanitizedValues
-		}
+type XSSOptions struct {
+	Default XSSAction
+	Routes  []XSSRoute
+}
 
-		r.URL.Path = sanitizedPath.(string)
-		r.URL.RawQuery = url.Values(sanitizedQuery).Encode()
+// xssPolicy is the action for one request after the matching routes are applied
+type xssPolicy struct {
+	action XSSAction
+	fields map[string]XSSAction
+}
 
-		// Sanitized request body
+func (p xssPolicy) forField(name string) XSSAction {
+	if action, ok := p.fields[name]; ok {
+		return action
+	}
+	return p.action
+}
 
-		if r.Header.Get("Content-Type") == "application/json" {
-			if r.Body != nil {
-				bodyBytes, err := io.ReadAll(r.Body)
-				if err != nil {
-					http.Error(w, "error reading request body", http.StatusBadRequest)
-					return
-				}
+var errMarkup = errors.New("must not contain markup")
 
-				bodyString := strings.TrimSpace(string(bodyBytes))
-				// reset the request body
-				r.Body = io.NopCloser(bytes.NewReader([]byte(bodyString)))
+var strictPolicy = bluemonday.StrictPolicy()
 
-				if len(bodyString) > 0 {
-					var inputData any
-					if err := json.NewDecoder(bytes.NewReader([]byte(bodyString))).Decode(&inputData); err != nil {
-						http.Error(w, "invalid JSON data", http.StatusBadRequest)
-						return
-					}
-					fmt.Println("Original JSON data:", inputData)
+func XSS(options XSSOptions) func(http.Handler) http.Handler {
+	if options.Default == "" {
+		options.Default = XSSStrip
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy := xssPolicyFor(r, options)
 
-					sanitizedData, err := clean(inputData)
+			// path segments are never rewritten, the route would silently change
+			if policy.action != XSSAllow && policy.action != XSSEncode && containsMarkup(r.URL.Path) {
+				utils.WriteProblem(w, http.StatusBadRequest, "path "+errMarkup.Error())
+				return
+			}
+
+			params := r.URL.Query()
+			for k, values := range params {
+				if containsMarkup(k) {
+					utils.WriteProblem(w, http.StatusBadRequest, "query parameter names "+errMarkup.Error())
+					return
+				}
+				for i, value := range values {
+					cleanValue, err := sanitizeString(value, policy.forField(k))
 					if err != nil {
-						http.Error(w, err.Error(), http.StatusBadRequest)
+						utils.WriteProblem(w, http.StatusBadRequest, fmt.Sprintf("query parameter %q %v", k, err))
 						return
 					}
+					values[i] = cleanValue
+				}
+			}
+			r.URL.RawQuery = url.Values(params).Encode()
+
+			// Sanitized request body
 
-					sanitizedBody, err := json.Marshal(sanitizedData)
+			if isJSONContentType(r.Header.Get("Content-Type")) {
+				if r.Body != nil {
+					bodyBytes, err := io.ReadAll(r.Body)
 					if err != nil {
-						http.Error(w, utils.ErrorHandler(err, "error sanitizing body").Error(), http.StatusBadRequest)
+						var tooLarge *http.MaxBytesError
+						if errors.As(err, &tooLarge) {
+							utils.WriteProblem(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body must not exceed %d bytes", tooLarge.Limit))
+							return
+						}
+						http.Error(w, "error reading request body", http.StatusBadRequest)
 						return
 					}
 
-					r.Body = io.NopCloser(bytes.NewReader(sanitizedBody))
-					fmt.Println("Sanitized body", string(sanitizedBody))
+					bodyString := strings.TrimSpace(string(bodyBytes))
+					// reset the request body
+					r.Body = io.NopCloser(bytes.NewReader([]byte(bodyString)))
+
+					if len(bodyString) > 0 {
+						var inputData any
+						decoder := json.NewDecoder(bytes.NewReader([]byte(bodyString)))
+						// numbers stay json.Number so large ids survive the round trip
+						decoder.UseNumber()
+						if err := decoder.Decode(&inputData); err != nil {
+							http.Error(w, "invalid JSON data", http.StatusBadRequest)
+							return
+						}
+						// only the first value would be re-encoded below, anything after it must not be silently dropped
+						if _, err := decoder.Token(); err != io.EOF {
+							utils.WriteProblem(w, http.StatusBadRequest, "request body must contain a single JSON value")
+							return
+						}
+
+						sanitizedData, err := clean(inputData, "", policy)
+						if err != nil {
+							utils.WriteProblem(w, http.StatusBadRequest, err.Error())
+							return
+						}
+
+						sanitizedBody, err := json.Marshal(sanitizedData)
+						if err != nil {
+							http.Error(w, utils.ErrorHandler(err, "error sanitizing body").Error(), http.StatusBadRequest)
+							return
+						}
 
-				} else {
-					fmt.Println("Request body is empty")
+						r.Body = io.NopCloser(bytes.NewReader(sanitizedBody))
+					}
 				}
-			} else {
-				fmt.Println("No body in the request")
+			} else if r.Header.Get("Content-Type") != "" {
+				utils.Logger(r.Context()).Warn("Received request with unsupported content type, expected application/json", "content_type", r.Header.Get("Content-Type"))
+				http.Error(w, "Received request with unsupported content type. please use application/json", http.StatusUnsupportedMediaType)
+				return
+			}
+
+			if policy.action == XSSEncode {
+				ew := &encodingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+				next.ServeHTTP(ew, r)
+				ew.flush()
+				return
 			}
-		} else if r.Header.Get("Content-Type") != "" {
-			log.Printf("Received request with unsupported content type: %s. Expected application/json\n", r.Header.Get("Content-Type"))
-			http.Error(w, "Received request with unsupported content type. please use application/json", http.StatusUnsupportedMediaType)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func xssPolicyFor(r *http.Request, options XSSOptions) xssPolicy {
+	var routes []XSSRoute
+	for _, route := range options.Routes {
+		if route.Method != "" && route.Method != r.Method {
+			continue
 		}
+		if strings.HasPrefix(r.URL.Path, route.Prefix) {
+			routes = append(routes, route)
+		}
+	}
+	// more specific prefixes override less specific ones
+	sort.SliceStable(routes, func(i, j int) bool { return len(routes[i].Prefix) < len(routes[j].Prefix) })
 
-		next.ServeHTTP(w, r)
-	})
+	policy := xssPolicy{action: options.Default, fields: make(map[string]XSSAction)}
+	for _, route := range routes {
+		if route.Action != "" {
+			policy.action = route.Action
+		}
+		for field, action := range route.Fields {
+			policy.fields[field] = action
+		}
+	}
+	return policy
+}
 
+// isJSONContentType accepts application/json and JSON-based types such as application/scim+json
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
 }
 
-// Sanitize input data to prevent XSS
+// Sanitize input data to prevent XSS. Only strings are touched, numbers, booleans and null
+// come back as they went in, whether nested or at the top level.
 
-func clean(data any) (any, error) {
+func clean(data any, field string, policy xssPolicy) (any, error) {
 	switch val := data.(type) {
 	case map[string]any:
 		for k, v := range val {
-			val[k] = sanitizeValue(v)
+			cleaned, err := clean(v, k, policy)
+			if err != nil {
+				return nil, err
+			}
+			val[k] = cleaned
 		}
 		return val, nil
 	case []any:
 		for i, v := range val {
-			val[i] = sanitizeValue(v)
+			cleaned, err := clean(v, field, policy)
+			if err != nil {
+				return nil, err
+			}
+			val[i] = cleaned
 		}
 		return val, nil
 	case string:
-		return sanitizeString(val), nil
+		cleaned, err := sanitizeString(val, policy.forField(field))
+		if err != nil && field != "" {
+			return nil, fmt.Errorf("field %q %w", field, err)
+		}
+		return cleaned, err
 	default:
-		return nil, utils.ErrorHandler(fmt.Errorf("unsupported type: %T", data), fmt.Sprintf("unsupported type: %T", data))
+		return val, nil
+	}
+}
+
+func sanitizeString(v string, action XSSAction) (string, error) {
+	switch action {
+	case XSSAllow, XSSEncode:
+		return v, nil
+	case XSSReject:
+		if containsMarkup(v) {
+			return "", errMarkup
+		}
+		return v, nil
+	default:
+		return stripMarkup(v), nil
+	}
+}
+
+// maxStripPasses bounds stripMarkup, every pass only peels one level of escaping and an
+// unbounded loop would be quadratic in the body size
+const maxStripPasses = 10
+
+// stripMarkup removes tags and leaves the text unescaped. It repeats until nothing changes so
+// escaped markup inside a tag can't come out as live markup after unescaping. Input that is
+// still changing after maxStripPasses is HTML-encoded instead.
+func stripMarkup(v string) string {
+	if !strings.ContainsRune(v, '<') {
+		return v
+	}
+	for range maxStripPasses {
+		stripped := html.UnescapeString(strictPolicy.Sanitize(v))
+		if stripped == v {
+			return v
+		}
+		v = stripped
 	}
+	return html.EscapeString(v)
+}
+
+func containsMarkup(v string) bool {
+	return stripMarkup(v) != v
 }
 
-func sanitizeValue(data any) any {
+// encodingResponseWriter buffers a response and HTML-escapes every string in it when it is JSON
+type encodingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (e *encodingResponseWriter) WriteHeader(status int) {
+	e.status = status
+}
+
+func (e *encodingResponseWriter) Write(b []byte) (int, error) {
+	return e.buf.Write(b)
+}
+
+func (e *encodingResponseWriter) flush() {
+	body := e.buf.Bytes()
+	if isJSONContentType(e.Header().Get("Content-Type")) {
+		var data any
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&data); err == nil {
+			if encoded, err := json.Marshal(encodeStrings(data)); err == nil {
+				body = append(encoded, '\n')
+			}
+		}
+	}
+	e.Header().Del("Content-Length")
+	e.ResponseWriter.WriteHeader(e.status)
+	if _, err := e.ResponseWriter.Write(body); err != nil {
+		slog.Error("Error writing response", "error", err)
+	}
+}
+
+func encodeStrings(data any) any {
 	switch val := data.(type) {
-	case string:
-		return sanitizeString(val)
-	case map[string]interface{}:
+	case map[string]any:
 		for k, v := range val {
-			val[k] = sanitizeValue(v)
+			val[k] = encodeStrings(v)
 		}
-		return val
 	case []any:
 		for i, v := range val {
-			val[i] = sanitizeValue(v)
+			val[i] = encodeStrings(v)
 		}
-		return val
-	default:
-		return val // return the value back because the type is unsupported
+	case string:
+		return html.EscapeString(val)
 	}
-}
-
-func sanitizeString(v string) string {
-	return bluemonday.UGCPolicy().Sanitize(v)
+	return data
 }
@@ -0,0 +1,48 @@
+package middlewares
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStripMarkup(t *testing.T) {
+	payload := "<img src=x onerror=alert(1)>"
+	escaped := func(levels int) string {
+		s := strings.NewReplacer("<", "&lt;", ">", "&gt;").Replace(payload)
+		for range levels {
+			s = strings.ReplaceAll(s, "&", "&amp;")
+		}
+		return s
+	}
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain text", "Ada Lovelace", "Ada Lovelace"},
+		{"text with an ampersand", "Tom & Jerry", "Tom & Jerry"},
+		{"tag", "<b>Ada</b>", "Ada"},
+		{"script", "Ada<script>alert(1)</script>", "Ada"},
+		{"escaped markup next to a tag", "<b></b>" + escaped(0), ""},
+		{"escaped three times", "<b></b>" + escaped(3), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripMarkup(tt.in); got != tt.want {
+				t.Errorf("stripMarkup(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+
+	// more escaping levels than the passes peel off used to come out as live markup
+	for levels := maxStripPasses - 2; levels <= maxStripPasses+5; levels++ {
+		got := stripMarkup("<b></b>" + escaped(levels))
+		if strings.ContainsAny(got, "<>") {
+			t.Errorf("escaped %d times: stripMarkup = %q, contains markup", levels, got)
+		}
+		if !containsMarkup("<b></b>" + escaped(levels)) {
+			t.Errorf("escaped %d times: containsMarkup = false", levels)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"schoolapi/internal/config"
+	"schoolapi/internal/metrics"
+	"schoolapi/pkg/utils"
+	"strings"
+)
+
+// SCIMAuth protects the /scim/v2 endpoints with the dedicated SCIM bearer token.
+// The endpoints are disabled while no token is configured.
+func SCIMAuth(cfg config.SCIMConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			expected := cfg.BearerToken
+			if expected == "" {
+				http.NotFound(w, r)
+				return
+			}
+
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			// compare digests so the comparison time does not depend on the token length
+			expectedSum := sha256.Sum256([]byte(expected))
+			tokenSum := sha256.Sum256([]byte(token))
+			if !ok || subtle.ConstantTimeCompare(expectedSum[:], tokenSum[:]) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+				metrics.AuthFailures.WithLabelValues("scim_token_invalid").Inc()
+				http.Error(w, "invalid SCIM credential", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), utils.ContextKey("username"), "scim")
+			ctx = context.WithValue(ctx, utils.ContextKey("authMethod"), "scim")
+			utils.AddLogAttrs(ctx, "auth_method", "scim")
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
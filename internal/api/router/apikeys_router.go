@@ -0,0 +1,18 @@
+package router
+
+import (
+	"net/http"
+	"schoolapi/internal/api/handlers"
+)
+
+func apiKeysRouter(h *handlers.Handlers) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /apikeys", h.GetAPIKeys)
+	mux.HandleFunc("POST /apikeys", h.AddAPIKey)
+
+	mux.HandleFunc("GET /apikeys/{id}", h.GetAPIKey)
+	mux.HandleFunc("DELETE /apikeys/{id}", h.RevokeAPIKey)
+
+	return mux
+}
@@ -0,0 +1,14 @@
+package router
+
+import (
+	"net/http"
+	"schoolapi/internal/api/handlers"
+)
+
+func auditRouter(h *handlers.Handlers) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /audit", h.GetAuditLog)
+
+	return mux
+}
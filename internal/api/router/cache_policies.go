@@ -0,0 +1,26 @@
+package router
+
+import (
+	mw "schoolapi/internal/api/middlewares"
+)
+
+// CachePolicies lets browsers keep lists for a few seconds, so a dashboard polling them
+// doesn't rerun the queries on every poll, and revalidate single resources every time.
+// Everything is private, it all comes from behind a login.
+func CachePolicies() map[string]mw.CachePolicy {
+	collection := mw.CachePolicy{CacheControl: "private, max-age=10", ETag: mw.ETagWeak}
+	resource := mw.CachePolicy{CacheControl: "private, no-cache", ETag: mw.ETagStrong}
+
+	return map[string]mw.CachePolicy{
+		"GET /teachers":                   collection,
+		"GET /teachers/{id}":              resource,
+		"GET /teachers/{id}/students":     collection,
+		"GET /teachers/{id}/studentcount": collection,
+		"GET /students":                   collection,
+		"GET /students/{id}":              resource,
+		"GET /execs":                      collection,
+		"GET /execs/{id}":                 resource,
+		"GET /roles":                      collection,
+		"GET /roles/{id}":                 resource,
+	}
+}
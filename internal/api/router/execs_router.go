@@ -5,23 +5,34 @@ import (
 	"schoolapi/internal/api/handlers"
 )
 
-func execsRouter() *http.ServeMux {
+func execsRouter(h *handlers.Handlers) *http.ServeMux {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("GET /execs", handlers.GetExecs)
-	mux.HandleFunc("POST /execs", handlers.AddExecs)
-	mux.HandleFunc("PATCH /execs", handlers.PatchExecs)
+	mux.HandleFunc("GET /execs", h.GetExecs)
+	mux.HandleFunc("POST /execs", h.AddExecs)
+	mux.HandleFunc("PATCH /execs", h.PatchExecs)
 
-	mux.HandleFunc("GET /execs/{id}", handlers.GetExec)
-	mux.HandleFunc("PATCH /execs/{id}", handlers.PatchExec)
-	mux.HandleFunc("DELETE /execs/{id}", handlers.DeleteExec)
+	mux.HandleFunc("GET /execs/{id}", h.GetExec)
+	mux.HandleFunc("PATCH /execs/{id}", h.PatchExec)
+	mux.HandleFunc("DELETE /execs/{id}", h.DeleteExec)
 
-	mux.HandleFunc("POST /execs/{id}/update-password", handlers.UpdatePassword)
+	mux.HandleFunc("POST /execs/{id}/update-password", h.UpdatePassword)
+	mux.HandleFunc("POST /execs/{id}/activate", h.ActivateExec)
+	mux.HandleFunc("POST /execs/{id}/deactivate", h.DeactivateExec)
+	mux.HandleFunc("GET /execs/{id}/role-history", h.GetExecRoleHistory)
+	mux.HandleFunc("GET /execs/{id}/sessions", h.GetExecSessions)
+	mux.HandleFunc("DELETE /execs/{id}/sessions/{sessionid}", h.DeleteExecSession)
+	mux.HandleFunc("GET /execs/{id}/login-attempts", h.GetExecLoginAttempts)
 
-	mux.HandleFunc("POST /execs/login", handlers.Login)
-	mux.HandleFunc("POST /execs/logout", handlers.Logout)
-	mux.HandleFunc("POST /execs/forgot-password", handlers.ForgotPassword)
-	mux.HandleFunc("POST /execs/reset-password/reset/{resetcode}", handlers.ResetPassword)
+	mux.HandleFunc("GET /execs/me/sessions", h.GetMySessions)
+	mux.HandleFunc("DELETE /execs/me/sessions/{sessionid}", h.DeleteMySession)
+
+	mux.HandleFunc("POST /execs/login", h.Login)
+	mux.HandleFunc("GET /execs/login/oidc", h.OIDCLogin)
+	mux.HandleFunc("GET /execs/login/oidc/callback", h.OIDCCallback)
+	mux.HandleFunc("POST /execs/logout", h.Logout)
+	mux.HandleFunc("POST /execs/forgot-password", h.ForgotPassword)
+	mux.HandleFunc("POST /execs/reset-password/reset/{resetcode}", h.ResetPassword)
 
 	return mux
 }
@@ -0,0 +1,20 @@
+package router
+
+import (
+	"net/http"
+	"schoolapi/internal/api/handlers"
+)
+
+// ProbePaths are polled by the orchestrator, so main serves them without a token, HPP
+// checks or rate limits
+var ProbePaths = []string{"/healthz", "/readyz", "/version"}
+
+func healthRouter(h *handlers.Handlers) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /healthz", handlers.Healthz)
+	mux.HandleFunc("GET /readyz", h.Readyz)
+	mux.HandleFunc("GET /version", handlers.Version)
+
+	return mux
+}
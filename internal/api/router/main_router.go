@@ -1,16 +1,57 @@
 package router
 
 import (
+	"context"
 	"net/http"
+	"schoolapi/internal/api/handlers"
+	"schoolapi/internal/config"
+	"schoolapi/pkg/utils"
 )
 
-func MainRouter() *http.ServeMux {
+func MainRouter(cfg *config.Config, h *handlers.Handlers) *http.ServeMux {
 
-	tRouter := teachersRouter()
-	sRouter := studentsRouter()
+	tRouter := teachersRouter(h)
+	sRouter := studentsRouter(h)
+	eRouter := execsRouter(h)
+	rRouter := rolesRouter(h)
+	aRouter := apiKeysRouter(h)
+	auRouter := auditRouter(h)
+	hRouter := healthRouter(h)
 
-	sRouter.Handle("/", execsRouter())
+	aRouter.Handle("/scim/v2/", scimRouter(cfg.SCIM, h))
+	// served on the admin listener instead when one is configured
+	if cfg.Metrics.Addr == "" {
+		aRouter.HandleFunc("GET /metrics", h.Metrics)
+	}
+	auRouter.Handle("/", hRouter)
+	aRouter.Handle("/", auRouter)
+	rRouter.Handle("/", aRouter)
+	eRouter.Handle("/", rRouter)
+	sRouter.Handle("/", eRouter)
 	tRouter.Handle("/", sRouter)
 
 	return tRouter
 }
+
+// Route puts the pattern mux will match, e.g. "GET /students/{id}", in the request context
+// (see utils.Route) before any middleware runs, so logs and metrics carry it even when a
+// middleware answers first. The routers fall through to one another on "/".
+func Route(mux *http.ServeMux) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var handler http.Handler = mux
+			var pattern string
+			for {
+				nested, ok := handler.(*http.ServeMux)
+				if !ok {
+					break
+				}
+				handler, pattern = nested.Handler(r)
+			}
+			if pattern != "" {
+				r = r.WithContext(context.WithValue(r.Context(), utils.ContextKey("route"), pattern))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package router
+
+import (
+	"net/http"
+	mw "schoolapi/internal/api/middlewares"
+	"schoolapi/internal/repository/sqlconnect"
+)
+
+// HPPRoutes whitelists the query params each endpoint reads. The list endpoints take theirs
+// from the filters sqlconnect declares, so a new filter column is allowed through automatically.
+func HPPRoutes() []mw.HPPRoute {
+	listParams := func(filters map[string]string, extra ...string) []string {
+		return append(sqlconnect.FilterParams(filters), append([]string{"sort_by"}, extra...)...)
+	}
+	repeatable := map[string]mw.HPPMultiValue{"sort_by": mw.HPPKeepAll}
+
+	return []mw.HPPRoute{
+		{Method: http.MethodGet, Prefix: "/students", WhiteList: listParams(sqlconnect.StudentFilters, "page", "limit"), MultiValue: repeatable},
+		{Method: http.MethodGet, Prefix: "/teachers", WhiteList: listParams(sqlconnect.TeacherFilters), MultiValue: repeatable},
+		{Method: http.MethodGet, Prefix: "/execs", WhiteList: listParams(sqlconnect.ExecFilters), MultiValue: repeatable},
+		{Method: http.MethodGet, Prefix: "/execs/login/oidc", WhiteList: []string{}},
+		{Method: http.MethodGet, Prefix: "/execs/login/oidc/callback", WhiteList: []string{"code", "state", "error", "error_description"}},
+		{Prefix: "/scim/v2", WhiteList: []string{"filter", "startIndex", "count", "attributes", "excludedAttributes"}},
+	}
+}
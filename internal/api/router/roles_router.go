@@ -0,0 +1,19 @@
+package router
+
+import (
+	"net/http"
+	"schoolapi/internal/api/handlers"
+)
+
+func rolesRouter(h *handlers.Handlers) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /roles", h.GetRoles)
+	mux.HandleFunc("POST /roles", h.AddRoles)
+
+	mux.HandleFunc("GET /roles/{id}", h.GetRole)
+	mux.HandleFunc("PATCH /roles/{id}", h.PatchRole)
+	mux.HandleFunc("DELETE /roles/{id}", h.DeleteRole)
+
+	return mux
+}
@@ -0,0 +1,30 @@
+package router
+
+import (
+	"net/http"
+	"schoolapi/internal/api/handlers"
+	mw "schoolapi/internal/api/middlewares"
+	"schoolapi/internal/config"
+)
+
+func scimRouter(cfg config.SCIMConfig, h *handlers.Handlers) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /scim/v2/ServiceProviderConfig", handlers.SCIMServiceProviderConfig)
+
+	mux.HandleFunc("GET /scim/v2/Users", h.SCIMGetUsers)
+	mux.HandleFunc("POST /scim/v2/Users", h.SCIMCreateUser)
+	mux.HandleFunc("GET /scim/v2/Users/{id}", h.SCIMGetUser)
+	mux.HandleFunc("PUT /scim/v2/Users/{id}", h.SCIMReplaceUser)
+	mux.HandleFunc("PATCH /scim/v2/Users/{id}", h.SCIMPatchUser)
+	mux.HandleFunc("DELETE /scim/v2/Users/{id}", h.SCIMDeleteUser)
+
+	mux.HandleFunc("GET /scim/v2/Groups", h.SCIMGetGroups)
+	mux.HandleFunc("POST /scim/v2/Groups", h.SCIMCreateGroup)
+	mux.HandleFunc("GET /scim/v2/Groups/{id}", h.SCIMGetGroup)
+	mux.HandleFunc("PUT /scim/v2/Groups/{id}", h.SCIMReplaceGroup)
+	mux.HandleFunc("PATCH /scim/v2/Groups/{id}", h.SCIMPatchGroup)
+	mux.HandleFunc("DELETE /scim/v2/Groups/{id}", h.SCIMDeleteGroup)
+
+	return mw.SCIMAuth(cfg)(mux)
+}
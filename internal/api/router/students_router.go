@@ -5,19 +5,19 @@ import (
 	"schoolapi/internal/api/handlers"
 )
 
-func studentsRouter() *http.ServeMux {
+func studentsRouter(h *handlers.Handlers) *http.ServeMux {
 
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("GET /students", handlers.GetStudents)
-	mux.HandleFunc("POST /students", handlers.AddStudents)
-	mux.HandleFunc("PATCH /students", handlers.PatchStudents)
-	mux.HandleFunc("DELETE /students", handlers.DeleteStudents)
+	mux.HandleFunc("GET /students", h.GetStudents)
+	mux.HandleFunc("POST /students", h.AddStudents)
+	mux.HandleFunc("PATCH /students", h.PatchStudents)
+	mux.HandleFunc("DELETE /students", h.DeleteStudents)
 
-	mux.HandleFunc("GET /students/{id}", handlers.GetStudent)
-	mux.HandleFunc("PUT /students/{id}", handlers.UpdateStudent)
-	mux.HandleFunc("PATCH /students/{id}", handlers.PatchStudent)
-	mux.HandleFunc("DELETE /students/{id}", handlers.DeleteStudent)
+	mux.HandleFunc("GET /students/{id}", h.GetStudent)
+	mux.HandleFunc("PUT /students/{id}", h.UpdateStudent)
+	mux.HandleFunc("PATCH /students/{id}", h.PatchStudent)
+	mux.HandleFunc("DELETE /students/{id}", h.DeleteStudent)
 
 	return mux
 }
@@ -5,19 +5,19 @@ import (
 	"schoolapi/internal/api/handlers"
 )
 
-func teachersRouter() *http.ServeMux {
+func teachersRouter(h *handlers.Handlers) *http.ServeMux {
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /teachers", handlers.GetTeachers)
-	mux.HandleFunc("POST /teachers", handlers.AddTeachers)
-	mux.HandleFunc("PATCH /teachers", handlers.PatchTeachers)
-	mux.HandleFunc("DELETE /teachers", handlers.DeleteTeachers)
+	mux.HandleFunc("GET /teachers", h.GetTeachers)
+	mux.HandleFunc("POST /teachers", h.AddTeachers)
+	mux.HandleFunc("PATCH /teachers", h.PatchTeachers)
+	mux.HandleFunc("DELETE /teachers", h.DeleteTeachers)
 
-	mux.HandleFunc("GET /teachers/{id}", handlers.GetTeacher)
-	mux.HandleFunc("PUT /teachers/{id}", handlers.UpdateTeacher)
-	mux.HandleFunc("PATCH /teachers/{id}", handlers.PatchTeacher)
-	mux.HandleFunc("DELETE /teachers/{id}", handlers.DeleteTeacher)
+	mux.HandleFunc("GET /teachers/{id}", h.GetTeacher)
+	mux.HandleFunc("PUT /teachers/{id}", h.UpdateTeacher)
+	mux.HandleFunc("PATCH /teachers/{id}", h.PatchTeacher)
+	mux.HandleFunc("DELETE /teachers/{id}", h.DeleteTeacher)
 
-	mux.HandleFunc("GET /teachers/{id}/students", handlers.GetStudentsByTeacherId)
-	mux.HandleFunc("GET /teachers/{id}/studentcount", handlers.GetStudentsCountByTeacherId)
+	mux.HandleFunc("GET /teachers/{id}/students", h.GetStudentsByTeacherId)
+	mux.HandleFunc("GET /teachers/{id}/studentcount", h.GetStudentsCountByTeacherId)
 	return mux
 }
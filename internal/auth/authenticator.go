@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"schoolapi/internal/config"
+	"schoolapi/internal/models"
+	"schoolapi/internal/repository/sqlconnect"
+	"schoolapi/pkg/utils"
+)
+
+var ErrNoAuthenticators = errors.New("no authentication method is enabled")
+
+// Authenticator verifies a username and password and returns the exec to issue a token for
+type Authenticator interface {
+	Authenticate(ctx context.Context, username, password string) (models.Exec, error)
+}
+
+// Chain tries each authenticator in order and returns the first exec that authenticates
+type Chain []Authenticator
+
+func (c Chain) Authenticate(ctx context.Context, username, password string) (models.Exec, error) {
+	if len(c) == 0 {
+		return models.Exec{}, ErrNoAuthenticators
+	}
+	var errs []error
+	for _, a := range c {
+		user, err := a.Authenticate(ctx, username, password)
+		if err == nil {
+			return user, nil
+		}
+		errs = append(errs, err)
+	}
+	return models.Exec{}, errors.Join(errs...)
+}
+
+// NewChain returns the local argon2 authenticator (unless password login is disabled)
+// followed by LDAP when a directory is configured
+func NewChain(authCfg config.AuthConfig, ldapCfg config.LDAPConfig, db *sqlconnect.DB) Chain {
+	var chain Chain
+	if authCfg.PasswordLoginEnabled {
+		chain = append(chain, LocalAuthenticator{DB: db})
+	}
+	if ldapAuth := NewLDAPAuthenticator(ldapCfg, db); ldapAuth != nil {
+		chain = append(chain, ldapAuth)
+	}
+	return chain
+}
+
+// LocalAuthenticator checks the argon2 hash stored in the execs table
+type LocalAuthenticator struct {
+	DB *sqlconnect.DB
+}
+
+func (a LocalAuthenticator) Authenticate(ctx context.Context, username, password string) (models.Exec, error) {
+	user, err := a.DB.GetUserByUsername(ctx, nil, models.Exec{Username: username})
+	if err != nil {
+		return models.Exec{}, err
+	}
+	if err := utils.VerifyPassword(password, user.Password); err != nil {
+		return models.Exec{}, err
+	}
+	user.Password = ""
+	return user, nil
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"schoolapi/internal/config"
+	"schoolapi/internal/models"
+	"schoolapi/internal/repository/sqlconnect"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPConn is the subset of *ldap.Conn the authenticator uses, so tests can swap in a stand-in directory
+type LDAPConn interface {
+	Bind(username, password string) error
+	Search(req *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// LDAPAuthenticator binds as the user against Active Directory / OpenLDAP and provisions
+// the exec record on first login. The role comes from the first matching group (full DN or CN)
+// in GroupRoles.
+type LDAPAuthenticator struct {
+	URL          string
+	StartTLS     bool
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter is a search filter with a single %s for the escaped username, e.g. (uid=%s)
+	UserFilter  string
+	GroupRoles  []config.LDAPGroupRole
+	DefaultRole string
+	Timeout     time.Duration
+	Dial        func(ctx context.Context) (LDAPConn, error)
+	// Provision creates or syncs the exec for a directory entry, NewLDAPAuthenticator sets
+	// ProvisionExecDB
+	Provision func(ctx context.Context, exec models.Exec, directoryDN, changedBy string) (models.Exec, error)
+}
+
+// NewLDAPAuthenticator returns nil when no directory URL is configured
+func NewLDAPAuthenticator(cfg config.LDAPConfig, db *sqlconnect.DB) *LDAPAuthenticator {
+	if cfg.URL == "" {
+		return nil
+	}
+	return &LDAPAuthenticator{
+		URL:          cfg.URL,
+		StartTLS:     cfg.StartTLS,
+		BindDN:       cfg.BindDN,
+		BindPassword: cfg.BindPassword,
+		BaseDN:       cfg.BaseDN,
+		UserFilter:   cfg.UserFilter,
+		GroupRoles:   cfg.GroupRoles,
+		DefaultRole:  cfg.DefaultRole,
+		Timeout:      cfg.Timeout,
+		Provision:    db.ProvisionExecDB,
+	}
+}
+
+func (a *LDAPAuthenticator) Authenticate(ctx context.Context, username, password string) (models.Exec, error) {
+	// an empty password would be an unauthenticated bind, which most servers accept
+	if username == "" || password == "" {
+		return models.Exec{}, errors.New("username and password are required")
+	}
+
+	conn, err := a.dial(ctx)
+	if err != nil {
+		return models.Exec{}, fmt.Errorf("ldap: %w", err)
+	}
+	defer conn.Close()
+
+	if a.BindDN != "" {
+		if err := conn.Bind(a.BindDN, a.BindPassword); err != nil {
+			return models.Exec{}, fmt.Errorf("ldap service bind: %w", err)
+		}
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		a.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(a.UserFilter, ldap.EscapeFilter(username)),
+		[]string{"mail", "givenName", "sn", "memberOf"},
+		nil,
+	))
+	if err != nil {
+		return models.Exec{}, fmt.Errorf("ldap search: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return models.Exec{}, errors.New("ldap: user not found")
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		return models.Exec{}, errors.New("ldap: invalid credentials")
+	}
+
+	role := a.roleFor(entry.GetAttributeValues("memberOf"))
+	if role == "" {
+		return models.Exec{}, errors.New("ldap: user is not in any group mapped to a role")
+	}
+
+	return a.Provision(ctx, models.Exec{
+		FirstName: entry.GetAttributeValue("givenName"),
+		LastName:  entry.GetAttributeValue("sn"),
+		Email:     entry.GetAttributeValue("mail"),
+		Username:  username,
+		Role:      role,
+	}, entry.DN, "ldap")
+}
+
+func (a *LDAPAuthenticator) roleFor(groups []string) string {
+	for _, mapping := range a.GroupRoles {
+		for _, group := range groups {
+			if strings.EqualFold(group, mapping.Group) || strings.EqualFold(groupCN(group), mapping.Group) {
+				return mapping.Role
+			}
+		}
+	}
+	return a.DefaultRole
+}
+
+func groupCN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 {
+		return ""
+	}
+	for _, attr := range parsed.RDNs[0].Attributes {
+		if strings.EqualFold(attr.Type, "cn") {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func (a *LDAPAuthenticator) dial(ctx context.Context) (LDAPConn, error) {
+	if a.Dial != nil {
+		return a.Dial(ctx)
+	}
+	dialer := &net.Dialer{Timeout: a.Timeout}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+	conn, err := ldap.DialURL(a.URL, ldap.DialWithDialer(dialer))
+	if err != nil {
+		return nil, err
+	}
+	if a.Timeout > 0 {
+		conn.SetTimeout(a.Timeout)
+	}
+	// closing the connection fails whatever request is waiting on it once the login is abandoned
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	if a.StartTLS {
+		host := strings.TrimPrefix(strings.TrimPrefix(a.URL, "ldap://"), "ldaps://")
+		host, _, _ = strings.Cut(host, ":")
+		if err := conn.StartTLS(&tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}); err != nil {
+			stop()
+			conn.Close()
+			return nil, err
+		}
+	}
+	return &ctxConn{Conn: conn, stop: stop}, nil
+}
+
+// ctxConn stops watching the request context once the connection is closed normally
+type ctxConn struct {
+	*ldap.Conn
+	stop func() bool
+}
+
+func (c *ctxConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
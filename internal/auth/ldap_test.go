@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"schoolapi/internal/config"
+	"schoolapi/internal/models"
+	"schoolapi/internal/repository/sqlconnect"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const serviceDN = "cn=svc,dc=district,dc=org"
+
+// fakeDirectory is an LDAPConn over a fixed set of entries. Passwords are keyed by DN and the
+// search filter must be the one the authenticator builds from UserFilter.
+type fakeDirectory struct {
+	entries   map[string]*ldap.Entry // filter -> entry
+	passwords map[string]string      // DN -> password
+	binds     []string
+	closed    bool
+}
+
+func (d *fakeDirectory) Bind(username, password string) error {
+	d.binds = append(d.binds, username)
+	if want, ok := d.passwords[username]; !ok || want != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	return nil
+}
+
+func (d *fakeDirectory) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	result := &ldap.SearchResult{}
+	if entry, ok := d.entries[req.Filter]; ok {
+		result.Entries = append(result.Entries, entry)
+	}
+	return result, nil
+}
+
+func (d *fakeDirectory) Close() error {
+	d.closed = true
+	return nil
+}
+
+// fakeExecs provisions like ProvisionExecDB: execs are found by directory DN and a username
+// taken by an account from elsewhere is refused
+type fakeExecs struct {
+	byDN   map[string]models.Exec
+	local  map[string]bool // usernames of local-only accounts
+	writes int
+}
+
+func (f *fakeExecs) provision(ctx context.Context, exec models.Exec, directoryDN, changedBy string) (models.Exec, error) {
+	existing, ok := f.byDN[directoryDN]
+	if !ok {
+		if f.local[exec.Username] {
+			return models.Exec{}, sqlconnect.ErrLocalAccount
+		}
+		exec.ID = len(f.byDN) + 1
+		f.byDN[directoryDN] = exec
+		f.writes++
+		return exec, nil
+	}
+	if existing.Role != exec.Role {
+		existing.Role = exec.Role
+		f.byDN[directoryDN] = existing
+		f.writes++
+	}
+	return existing, nil
+}
+
+func newTestDirectory() *fakeDirectory {
+	jane := ldap.NewEntry("uid=jane,ou=people,dc=district,dc=org", map[string][]string{
+		"givenName": {"Jane"},
+		"sn":        {"Doe"},
+		"mail":      {"jane@district.org"},
+		"memberOf":  {"cn=teachers,ou=groups,dc=district,dc=org", "CN=School-Admins,OU=Groups,DC=district,DC=org"},
+	})
+	admin := ldap.NewEntry("uid=admin,ou=people,dc=district,dc=org", map[string][]string{
+		"memberOf": {"cn=it,ou=groups,dc=district,dc=org"},
+	})
+	nobody := ldap.NewEntry("uid=nobody,ou=people,dc=district,dc=org", nil)
+	return &fakeDirectory{
+		entries: map[string]*ldap.Entry{
+			"(uid=jane)":   jane,
+			"(uid=admin)":  admin,
+			"(uid=nobody)": nobody,
+		},
+		passwords: map[string]string{
+			serviceDN: "svc-secret",
+			jane.DN:   "jane-secret",
+			admin.DN:  "admin-secret",
+			nobody.DN: "nobody-secret",
+		},
+	}
+}
+
+func newTestLDAP(dir *fakeDirectory, execs *fakeExecs) *LDAPAuthenticator {
+	return &LDAPAuthenticator{
+		BindDN:       serviceDN,
+		BindPassword: "svc-secret",
+		BaseDN:       "dc=district,dc=org",
+		UserFilter:   "(uid=%s)",
+		GroupRoles: []config.LDAPGroupRole{
+			{Group: "school-admins", Role: "admin"},
+			{Group: "cn=teachers,ou=groups,dc=district,dc=org", Role: "exec"},
+		},
+		Dial:      func(ctx context.Context) (LDAPConn, error) { return dir, nil },
+		Provision: execs.provision,
+	}
+}
+
+func newFakeExecs() *fakeExecs {
+	return &fakeExecs{byDN: map[string]models.Exec{}, local: map[string]bool{"admin": true}}
+}
+
+func TestLDAPBind(t *testing.T) {
+	tests := []struct {
+		name, username, password string
+		wantErr                  bool
+	}{
+		{"valid credentials", "jane", "jane-secret", false},
+		{"wrong password", "jane", "wrong", true},
+		{"empty password", "jane", "", true},
+		{"unknown user", "mallory", "anything", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newTestDirectory()
+			_, err := newTestLDAP(dir, newFakeExecs()).Authenticate(context.Background(), tt.username, tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.password != "" && (len(dir.binds) == 0 || dir.binds[0] != serviceDN) {
+				t.Errorf("binds = %v, want the service account first", dir.binds)
+			}
+			if tt.password != "" && !dir.closed {
+				t.Error("connection not closed")
+			}
+		})
+	}
+
+	t.Run("service bind fails", func(t *testing.T) {
+		dir := newTestDirectory()
+		a := newTestLDAP(dir, newFakeExecs())
+		a.BindPassword = "stale"
+		if _, err := a.Authenticate(context.Background(), "jane", "jane-secret"); err == nil {
+			t.Fatal("authenticated with a failing service bind")
+		}
+		if len(dir.binds) != 1 {
+			t.Errorf("binds = %v, the user bind should not be attempted", dir.binds)
+		}
+	})
+}
+
+func TestLDAPRoleFor(t *testing.T) {
+	a := newTestLDAP(newTestDirectory(), newFakeExecs())
+	tests := []struct {
+		name        string
+		groups      []string
+		defaultRole string
+		want        string
+	}{
+		{"by full DN", []string{"cn=teachers,ou=groups,dc=district,dc=org"}, "", "exec"},
+		{"by CN, case-insensitive", []string{"CN=School-Admins,OU=Groups,DC=district,DC=org"}, "", "admin"},
+		{"first mapping wins", []string{"cn=teachers,ou=groups,dc=district,dc=org", "cn=school-admins,ou=groups,dc=district,dc=org"}, "", "admin"},
+		{"unmapped group uses the default", []string{"cn=it,ou=groups,dc=district,dc=org"}, "viewer", "viewer"},
+		{"unmapped group without a default", []string{"cn=it,ou=groups,dc=district,dc=org"}, "", ""},
+		{"malformed DN", []string{"not a dn"}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a.DefaultRole = tt.defaultRole
+			if got := a.roleFor(tt.groups); got != tt.want {
+				t.Errorf("roleFor(%v) = %q, want %q", tt.groups, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("no role refuses the login", func(t *testing.T) {
+		a := newTestLDAP(newTestDirectory(), newFakeExecs())
+		if _, err := a.Authenticate(context.Background(), "nobody", "nobody-secret"); err == nil {
+			t.Error("authenticated a user without a mapped group or default role")
+		}
+	})
+}
+
+func TestLDAPProvision(t *testing.T) {
+	ctx := context.Background()
+	execs := newFakeExecs()
+	a := newTestLDAP(newTestDirectory(), execs)
+
+	exec, err := a.Authenticate(ctx, "jane", "jane-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := models.Exec{ID: 1, FirstName: "Jane", LastName: "Doe", Email: "jane@district.org", Username: "jane", Role: "admin"}
+	if exec != want {
+		t.Errorf("provisioned %+v, want %+v", exec, want)
+	}
+	if _, ok := execs.byDN["uid=jane,ou=people,dc=district,dc=org"]; !ok {
+		t.Error("exec not provisioned under the entry's DN")
+	}
+
+	t.Run("second login changes nothing", func(t *testing.T) {
+		writes := execs.writes
+		if _, err := a.Authenticate(ctx, "jane", "jane-secret"); err != nil {
+			t.Fatal(err)
+		}
+		if execs.writes != writes {
+			t.Errorf("%d writes on an unchanged login", execs.writes-writes)
+		}
+	})
+
+	t.Run("username of a local account", func(t *testing.T) {
+		a.DefaultRole = "exec"
+		_, err := a.Authenticate(ctx, "admin", "admin-secret")
+		if !errors.Is(err, sqlconnect.ErrLocalAccount) {
+			t.Errorf("Authenticate error = %v, want ErrLocalAccount", err)
+		}
+	})
+}
+
+// stubAuthenticator authenticates nobody, like a local login for an exec that only exists in the directory
+type stubAuthenticator struct{ calls int }
+
+func (s *stubAuthenticator) Authenticate(ctx context.Context, username, password string) (models.Exec, error) {
+	s.calls++
+	return models.Exec{}, errors.New("user not found")
+}
+
+func TestChainFallsThroughToLDAP(t *testing.T) {
+	ctx := context.Background()
+	local := &stubAuthenticator{}
+	chain := Chain{local, newTestLDAP(newTestDirectory(), newFakeExecs())}
+
+	exec, err := chain.Authenticate(ctx, "jane", "jane-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if local.calls != 1 || exec.Username != "jane" {
+		t.Errorf("local calls = %d, exec = %+v; want the local attempt then the directory exec", local.calls, exec)
+	}
+
+	if _, err := chain.Authenticate(ctx, "jane", "wrong"); err == nil {
+		t.Error("chain authenticated with a wrong password")
+	}
+	if _, err := (Chain{}).Authenticate(ctx, "jane", "jane-secret"); !errors.Is(err, ErrNoAuthenticators) {
+		t.Errorf("empty chain error = %v, want ErrNoAuthenticators", err)
+	}
+}
@@ -0,0 +1,367 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"schoolapi/internal/config"
+	"schoolapi/pkg/utils"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDCProvider runs the authorization code flow with PKCE against an OpenID Connect identity provider
+type OIDCProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	HTTPClient   *http.Client
+
+	// mu guards the cache, it is never held across a request to the IdP
+	mu             sync.Mutex
+	discovery      *oidcDiscovery
+	discoveryFetch *oidcFetch
+	keys           map[string]any
+	keysFetched    time.Time
+	keysFetch      *oidcFetch
+}
+
+// oidcFetch is a request to the IdP in flight, callers needing its result meanwhile wait for
+// it instead of sending their own
+type oidcFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// jwksMinRefresh limits how often an unknown kid refetches the JWKS, so tokens signed with
+// made-up kids can't have us fetch it on every request
+const jwksMinRefresh = time.Minute
+
+// OIDCIdentity is what we take from a verified ID token
+type OIDCIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewOIDCProvider returns nil when no issuer is configured
+func NewOIDCProvider(cfg config.OIDCConfig) *OIDCProvider {
+	if cfg.Issuer == "" {
+		return nil
+	}
+	return &OIDCProvider{
+		Issuer:       strings.TrimSuffix(cfg.Issuer, "/"),
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+}
+
+// NewPKCE returns a random code verifier and its S256 challenge
+func NewPKCE() (verifier, challenge string, err error) {
+	verifier, err = RandomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthCodeURL is where the browser is sent to sign in at the identity provider
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, challenge string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange redeems the authorization code and verifies the returned ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (OIDCIdentity, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	utils.PropagateRequestID(ctx, req.Header)
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return OIDCIdentity{}, fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+
+	var tokenResp struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return OIDCIdentity{}, err
+	}
+	if tokenResp.IDToken == "" {
+		return OIDCIdentity{}, errors.New("token response has no id_token")
+	}
+	return p.verifyIDToken(ctx, tokenResp.IDToken, nonce)
+}
+
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, rawToken, nonce string) (OIDCIdentity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawToken, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return OIDCIdentity{}, errors.New("ID token nonce mismatch")
+	}
+
+	identity := OIDCIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	if identity.Subject == "" {
+		return OIDCIdentity{}, errors.New("ID token has no subject")
+	}
+	return identity, nil
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.discovery == nil {
+		err := p.fetchShared(ctx, &p.discoveryFetch, func() (func(), error) {
+			var d oidcDiscovery
+			if err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+				return nil, fmt.Errorf("OIDC discovery: %w", err)
+			}
+			if strings.TrimSuffix(d.Issuer, "/") != p.Issuer {
+				return nil, fmt.Errorf("OIDC discovery: issuer mismatch %q", d.Issuer)
+			}
+			return func() { p.discovery = &d }, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p.discovery, nil
+}
+
+// key returns the signing key for kid, refetching the JWKS if the key is unknown (key rotation),
+// at most once per jwksMinRefresh
+func (p *OIDCProvider) key(ctx context.Context, kid string) (any, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		if key, ok := p.keys[kid]; ok {
+			return key, nil
+		}
+		// a fetch in flight may still bring the key, otherwise ours has just missed it too
+		if p.keysFetch == nil && p.keys != nil && time.Since(p.keysFetched) < jwksMinRefresh {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		err := p.fetchShared(ctx, &p.keysFetch, func() (func(), error) {
+			var set struct {
+				Keys []jsonWebKey `json:"keys"`
+			}
+			if err := p.getJSON(ctx, d.JWKSURI, &set); err != nil {
+				return nil, fmt.Errorf("OIDC JWKS: %w", err)
+			}
+			keys := make(map[string]any, len(set.Keys))
+			for _, jwk := range set.Keys {
+				if jwk.Use != "" && jwk.Use != "sig" {
+					continue
+				}
+				key, err := jwk.publicKey()
+				if err != nil {
+					continue
+				}
+				keys[jwk.Kid] = key
+			}
+			return func() { p.keys, p.keysFetched = keys, time.Now() }, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fetchShared is called and returns with p.mu held. Unless *inflight is already fetching, it
+// runs fetch with the lock released and applies the result under it. Either way it waits for
+// that fetch to finish, so a slow IdP only holds up the callers that need what it fetches.
+func (p *OIDCProvider) fetchShared(ctx context.Context, inflight **oidcFetch, fetch func() (apply func(), err error)) error {
+	if f := *inflight; f != nil {
+		p.mu.Unlock()
+		select {
+		case <-f.done:
+			p.mu.Lock()
+			return f.err
+		case <-ctx.Done():
+			p.mu.Lock()
+			return ctx.Err()
+		}
+	}
+
+	f := &oidcFetch{done: make(chan struct{})}
+	*inflight = f
+	p.mu.Unlock()
+	apply, err := fetch()
+	p.mu.Lock()
+	if err == nil {
+		apply()
+	}
+	f.err = err
+	*inflight = nil
+	close(f.done)
+	return err
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	utils.PropagateRequestID(ctx, req.Header)
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *OIDCProvider) client() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
@@ -0,0 +1,385 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIdP is an in-process OpenID Connect provider. It hands out one authorization code per
+// AuthCodeURL and checks the PKCE verifier when the code is redeemed.
+type mockIdP struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu         sync.Mutex
+	kid        string
+	key        *rsa.PrivateKey
+	published  map[string]*rsa.PrivateKey
+	jwksHits   int
+	jwksStall  chan struct{}     // while set, JWKS responses wait for it to close
+	challenges map[string]string // code -> code_challenge
+	nonces     map[string]string // code -> nonce
+	subject    string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	idp := &mockIdP{
+		t:          t,
+		published:  map[string]*rsa.PrivateKey{},
+		challenges: map[string]string{},
+		nonces:     map[string]string{},
+		subject:    "user-123",
+	}
+	idp.rotate("key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JWKSURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", idp.serveJWKS)
+	mux.HandleFunc("POST /token", idp.serveToken)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// rotate signs new tokens with a fresh key, publishing it alongside the old ones
+func (idp *mockIdP) rotate(kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.kid, idp.key = kid, key
+	idp.published[kid] = key
+}
+
+func (idp *mockIdP) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	idp.jwksHits++
+	stall := idp.jwksStall
+	idp.mu.Unlock()
+	if stall != nil {
+		<-stall
+	}
+
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	keys := []jsonWebKey{}
+	for kid, key := range idp.published {
+		keys = append(keys, jsonWebKey{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+}
+
+func (idp *mockIdP) jwksFetches() int {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	return idp.jwksHits
+}
+
+func (idp *mockIdP) serveToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	code := r.PostForm.Get("code")
+	idp.mu.Lock()
+	challenge, ok := idp.challenges[code]
+	nonce := idp.nonces[code]
+	delete(idp.challenges, code)
+	idp.mu.Unlock()
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idp.idToken(nonce, nil)})
+}
+
+// idToken signs an ID token with the current key, extra overrides the default claims
+func (idp *mockIdP) idToken(nonce string, extra jwt.MapClaims) string {
+	claims := jwt.MapClaims{
+		"iss":            idp.server.URL,
+		"aud":            "school-api",
+		"sub":            idp.subject,
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"nonce":          nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = idp.kid
+	signed, err := token.SignedString(idp.key)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	return signed
+}
+
+// authorize plays the browser's trip to the authorization endpoint and returns the code
+func (idp *mockIdP) authorize(authURL string) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		idp.t.Fatalf("authorization URL without an S256 PKCE challenge: %s", authURL)
+	}
+	code, _ := RandomString(8)
+	idp.mu.Lock()
+	idp.challenges[code] = q.Get("code_challenge")
+	idp.nonces[code] = q.Get("nonce")
+	idp.mu.Unlock()
+	return code
+}
+
+func (idp *mockIdP) provider() *OIDCProvider {
+	return &OIDCProvider{
+		Issuer:      idp.server.URL,
+		ClientID:    "school-api",
+		RedirectURL: "https://school.example/execs/login/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+		HTTPClient:  idp.server.Client(),
+	}
+}
+
+func TestOIDCDiscovery(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+
+	authURL, err := p.AuthCodeURL(context.Background(), "state-1", "nonce-1", "challenge-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, idp.server.URL+"/authorize?") {
+		t.Errorf("authorization URL %q doesn't use the discovered endpoint", authURL)
+	}
+	q, _ := url.ParseQuery(authURL[strings.Index(authURL, "?")+1:])
+	for name, want := range map[string]string{
+		"state":          "state-1",
+		"nonce":          "nonce-1",
+		"code_challenge": "challenge-1",
+		"client_id":      "school-api",
+		"response_type":  "code",
+	} {
+		if got := q.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	t.Run("issuer mismatch", func(t *testing.T) {
+		p := idp.provider()
+		p.Issuer = strings.Replace(idp.server.URL, "127.0.0.1", "localhost", 1)
+		if _, err := p.AuthCodeURL(context.Background(), "s", "n", "c"); err == nil {
+			t.Error("discovery accepted a document for another issuer")
+		}
+	})
+}
+
+func TestOIDCExchange(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+	ctx := context.Background()
+
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := p.AuthCodeURL(ctx, "state", "nonce-1", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := idp.authorize(authURL)
+
+	identity, err := p.Exchange(ctx, code, verifier, "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	want := OIDCIdentity{Subject: "user-123", Email: "jane@example.com", EmailVerified: true}
+	if identity != want {
+		t.Errorf("identity = %+v, want %+v", identity, want)
+	}
+
+	t.Run("wrong PKCE verifier", func(t *testing.T) {
+		_, challenge, _ := NewPKCE()
+		authURL, _ := p.AuthCodeURL(ctx, "state", "nonce-1", challenge)
+		code := idp.authorize(authURL)
+		if _, err := p.Exchange(ctx, code, verifier, "nonce-1"); err == nil {
+			t.Error("Exchange succeeded with a verifier that doesn't match the challenge")
+		}
+	})
+
+	t.Run("code redeemed twice", func(t *testing.T) {
+		if _, err := p.Exchange(ctx, code, verifier, "nonce-1"); err == nil {
+			t.Error("Exchange succeeded with an already redeemed code")
+		}
+	})
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		verifier, challenge, _ := NewPKCE()
+		authURL, _ := p.AuthCodeURL(ctx, "state", "nonce-from-idp", challenge)
+		code := idp.authorize(authURL)
+		if _, err := p.Exchange(ctx, code, verifier, "nonce-in-cookie"); err == nil || !strings.Contains(err.Error(), "nonce") {
+			t.Errorf("Exchange error = %v, want a nonce mismatch", err)
+		}
+	})
+}
+
+func TestOIDCVerifyIDToken(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		extra jwt.MapClaims
+	}{
+		{"other audience", jwt.MapClaims{"aud": "another-client"}},
+		{"other issuer", jwt.MapClaims{"iss": "https://evil.example"}},
+		{"expired", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}},
+		{"no subject", jwt.MapClaims{"sub": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := p.verifyIDToken(ctx, idp.idToken("n", tt.extra), "n"); err == nil {
+				t.Error("token accepted")
+			}
+		})
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+	ctx := context.Background()
+
+	if _, err := p.verifyIDToken(ctx, idp.idToken("n", nil), "n"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the IdP rotates after our last fetch, a token with the new kid refetches the JWKS
+	idp.rotate("key-2")
+	p.keysFetched = time.Now().Add(-2 * jwksMinRefresh)
+	if _, err := p.verifyIDToken(ctx, idp.idToken("n", nil), "n"); err != nil {
+		t.Fatalf("token signed with the rotated key: %v", err)
+	}
+	if hits := idp.jwksFetches(); hits != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", hits)
+	}
+
+	t.Run("unknown kids are throttled", func(t *testing.T) {
+		hits := idp.jwksFetches()
+		forged := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"iss": idp.server.URL, "aud": "school-api", "sub": "x", "nonce": "n", "exp": time.Now().Add(time.Minute).Unix()})
+		attacker, _ := rsa.GenerateKey(rand.Reader, 2048)
+		for i := 0; i < 5; i++ {
+			forged.Header["kid"] = "forged-" + string(rune('a'+i))
+			raw, _ := forged.SignedString(attacker)
+			if _, err := p.verifyIDToken(ctx, raw, "n"); err == nil {
+				t.Fatal("forged token accepted")
+			}
+		}
+		if got := idp.jwksFetches(); got != hits {
+			t.Errorf("forged kids caused %d JWKS fetches, want none within the refresh interval", got-hits)
+		}
+	})
+}
+
+// a slow JWKS endpoint only holds up the lookups that need the refetch, and those share it
+func TestOIDCKeyFetchUnlocked(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+	ctx := context.Background()
+
+	if _, err := p.key(ctx, "key-1"); err != nil {
+		t.Fatal(err)
+	}
+	idp.rotate("key-2")
+	p.keysFetched = time.Now().Add(-2 * jwksMinRefresh)
+	stall := make(chan struct{})
+	idp.mu.Lock()
+	idp.jwksStall = stall
+	idp.mu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := p.key(ctx, "key-2")
+			errs <- err
+		}()
+	}
+	for idp.jwksFetches() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cached := make(chan error, 1)
+	go func() {
+		if _, err := p.key(ctx, "key-1"); err != nil {
+			cached <- err
+			return
+		}
+		_, err := p.discover(ctx)
+		cached <- err
+	}()
+	select {
+	case err := <-cached:
+		if err != nil {
+			t.Errorf("cached key during a refetch: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("cached key lookup waited for the JWKS refetch")
+	}
+
+	close(stall)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("rotated key: %v", err)
+		}
+	}
+	if hits := idp.jwksFetches(); hits != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", hits)
+	}
+}
@@ -0,0 +1,41 @@
+// Package buildinfo holds the version the binary was built as. Release builds set it with
+//
+//	go build -ldflags "-X schoolapi/internal/buildinfo.Version=v1.4.0 -X schoolapi/internal/buildinfo.Commit=$(git rev-parse HEAD)" ./cmd/api
+package buildinfo
+
+import "runtime/debug"
+
+var (
+	Version = "dev"
+	Commit  = ""
+	// BuildTime is optional, e.g. -X schoolapi/internal/buildinfo.BuildTime=$(date -u +%FT%TZ)
+	BuildTime = ""
+)
+
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get falls back to the VCS stamp go build records when no commit was passed in
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildTime: BuildTime}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		info.GoVersion = build.GoVersion
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildTime == "" {
+					info.BuildTime = setting.Value
+				}
+			}
+		}
+	}
+	return info
+}
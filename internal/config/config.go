@@ -0,0 +1,412 @@
+// Package config loads the API's settings from a YAML file, then env vars, then flags, each
+// layer overriding the one before, and validates them once at startup. main hands each
+// component the section it needs; nothing else should read the environment.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Server      ServerConfig      `yaml:"server"`
+	Database    DatabaseConfig    `yaml:"database"`
+	Auth        AuthConfig        `yaml:"auth"`
+	Mail        MailConfig        `yaml:"mail"`
+	OIDC        OIDCConfig        `yaml:"oidc"`
+	LDAP        LDAPConfig        `yaml:"ldap"`
+	SCIM        SCIMConfig        `yaml:"scim"`
+	RateLimit   RateLimitConfig   `yaml:"rate_limit"`
+	CORS        CORSConfig        `yaml:"cors"`
+	Security    SecurityConfig    `yaml:"security"`
+	Compression CompressionConfig `yaml:"compression"`
+	Log         LogConfig         `yaml:"log"`
+	Metrics     MetricsConfig     `yaml:"metrics"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+}
+
+type ServerConfig struct {
+	// Port is the listen address, e.g. ":3000"
+	Port string `yaml:"port" env:"API_PORT" flag:"port"`
+	// Host is the public host name used in links sent to users
+	Host           string   `yaml:"host" env:"HOST_ADDRESS"`
+	CertFile       string   `yaml:"cert_file" env:"TLS_CERT_FILE" flag:"cert"`
+	KeyFile        string   `yaml:"key_file" env:"TLS_KEY_FILE" flag:"key"`
+	TrustedProxies []string `yaml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	// RedisURL shares rate limits and revoked sessions between replicas, memory is used when empty
+	RedisURL string `yaml:"redis_url" env:"REDIS_URL"`
+	// timeouts of the http.Server, 0 means none (IdleTimeout then falls back to ReadTimeout)
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"SERVER_READ_HEADER_TIMEOUT"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+	// ShutdownTimeout is how long in-flight requests get to finish after SIGTERM or SIGINT
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SERVER_SHUTDOWN_TIMEOUT"`
+}
+
+type DatabaseConfig struct {
+	User     string `yaml:"user" env:"DB_USER"`
+	Password string `yaml:"password" env:"DB_PASSWORD"`
+	Host     string `yaml:"host" env:"HOST_IP"`
+	Port     string `yaml:"port" env:"DB_PORT"`
+	Name     string `yaml:"name" env:"DB_NAME"`
+	// SlowQueryThreshold logs statements running longer than this, 0 turns it off
+	SlowQueryThreshold time.Duration `yaml:"slow_query_threshold" env:"DB_SLOW_QUERY_THRESHOLD"`
+	// the pool is shared by every request, 0 means unlimited
+	MaxOpenConns    int           `yaml:"max_open_conns" env:"DB_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME"`
+}
+
+func (d DatabaseConfig) DSN() string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", d.User, d.Password, d.Host, d.Port, d.Name)
+}
+
+type AuthConfig struct {
+	JWTSecret    string        `yaml:"jwt_secret" env:"JWT_SECRET"`
+	JWTExpiresIn time.Duration `yaml:"jwt_expires_in" env:"JWT_EXPIRES_IN"`
+	// ResetTokenExpiresIn bounds password reset links. A bare number is read as minutes.
+	ResetTokenExpiresIn  time.Duration `yaml:"reset_token_expires_in" env:"RESET_JWT_EXP_DURATION" unit:"m"`
+	CookieSameSite       string        `yaml:"cookie_samesite" env:"COOKIE_SAMESITE"`
+	PasswordLoginEnabled bool          `yaml:"password_login_enabled" env:"PASSWORD_LOGIN_ENABLED"`
+	// PublicPaths are served without a token
+	PublicPaths []string `yaml:"public_paths" env:"AUTH_PUBLIC_PATHS"`
+}
+
+// SameSite is the SameSite mode for the auth cookies. Portals embedding the app need lax or
+// none, which is what the CSRF check is there for.
+func (a AuthConfig) SameSite() http.SameSite {
+	switch strings.ToLower(a.CookieSameSite) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteStrictMode
+	}
+}
+
+type MailConfig struct {
+	Host string `yaml:"host" env:"SMTP_HOST"`
+	Port int    `yaml:"port" env:"SMTP_PORT"`
+	From string `yaml:"from" env:"MAIL_FROM"`
+}
+
+// OIDCConfig enables OpenID Connect login when Issuer is set
+type OIDCConfig struct {
+	Issuer       string `yaml:"issuer" env:"OIDC_ISSUER"`
+	ClientID     string `yaml:"client_id" env:"OIDC_CLIENT_ID"`
+	ClientSecret string `yaml:"client_secret" env:"OIDC_CLIENT_SECRET"`
+	RedirectURL  string `yaml:"redirect_url" env:"OIDC_REDIRECT_URL"`
+}
+
+// LDAPConfig enables directory login when URL is set
+type LDAPConfig struct {
+	URL          string `yaml:"url" env:"LDAP_URL"`
+	StartTLS     bool   `yaml:"start_tls" env:"LDAP_START_TLS"`
+	BindDN       string `yaml:"bind_dn" env:"LDAP_BIND_DN"`
+	BindPassword string `yaml:"bind_password" env:"LDAP_BIND_PASSWORD"`
+	BaseDN       string `yaml:"base_dn" env:"LDAP_BASE_DN"`
+	UserFilter   string `yaml:"user_filter" env:"LDAP_USER_FILTER"`
+	DefaultRole  string `yaml:"default_role" env:"LDAP_DEFAULT_ROLE"`
+	// Timeout bounds connecting to the directory and each request on the connection
+	Timeout time.Duration `yaml:"timeout" env:"LDAP_TIMEOUT"`
+	// GroupRoles in env is JSON, e.g. [{"group":"cn=school-admins,ou=groups,dc=district,dc=org","role":"admin"}]
+	GroupRoles []LDAPGroupRole `yaml:"group_roles" env:"LDAP_GROUP_ROLES"`
+}
+
+type LDAPGroupRole struct {
+	Group string `yaml:"group" json:"group"`
+	Role  string `yaml:"role" json:"role"`
+}
+
+// SCIMConfig enables the /scim/v2 endpoints when BearerToken is set
+type SCIMConfig struct {
+	BearerToken string `yaml:"bearer_token" env:"SCIM_BEARER_TOKEN"`
+	DefaultRole string `yaml:"default_role" env:"SCIM_DEFAULT_ROLE"`
+}
+
+// RateLimitConfig is hot-reloadable
+type RateLimitConfig struct {
+	Default RateLimitPolicy      `yaml:"default"`
+	Routes  []RouteRateLimitRule `yaml:"routes"`
+}
+
+type RateLimitPolicy struct {
+	Name   string        `yaml:"name"`
+	Limit  int           `yaml:"limit" env:"RATE_LIMIT"`
+	Window time.Duration `yaml:"window" env:"RATE_LIMIT_WINDOW"`
+}
+
+type RouteRateLimitRule struct {
+	Method string          `yaml:"method"`
+	Prefix string          `yaml:"prefix"`
+	Policy RateLimitPolicy `yaml:"policy"`
+}
+
+// CORSConfig is hot-reloadable
+type CORSConfig struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS"`
+	AllowedMethods   []string      `yaml:"allowed_methods" env:"CORS_ALLOWED_METHODS"`
+	AllowedHeaders   []string      `yaml:"allowed_headers" env:"CORS_ALLOWED_HEADERS"`
+	ExposedHeaders   []string      `yaml:"exposed_headers" env:"CORS_EXPOSED_HEADERS"`
+	AllowCredentials bool          `yaml:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS"`
+	MaxAge           time.Duration `yaml:"max_age" env:"CORS_MAX_AGE"`
+}
+
+type SecurityConfig struct {
+	MaxBodyBytes     int64  `yaml:"max_body_bytes" env:"MAX_BODY_BYTES"`
+	StrictJSON       bool   `yaml:"strict_json" env:"STRICT_JSON"`
+	XSSDefaultAction string `yaml:"xss_default_action" env:"XSS_DEFAULT_ACTION"`
+	// XSSRoutes override the default action under a path prefix, longer prefixes win. Setting
+	// them in the file replaces the defaults, keep the secret fields on "allow".
+	XSSRoutes     []XSSRouteRule `yaml:"xss_routes"`
+	HPPMultiValue string         `yaml:"hpp_multi_value" env:"HPP_MULTI_VALUE"`
+}
+
+// XSSRouteRule sets the action for the requests under Prefix, and per field for the JSON and
+// form fields named in Fields. An empty Action keeps the one inherited.
+type XSSRouteRule struct {
+	Method string            `yaml:"method"`
+	Prefix string            `yaml:"prefix"`
+	Action string            `yaml:"action"`
+	Fields map[string]string `yaml:"fields"`
+}
+
+type CompressionConfig struct {
+	// MinSize in bytes, smaller responses aren't worth the CPU
+	MinSize      int      `yaml:"min_size" env:"COMPRESSION_MIN_SIZE"`
+	ContentTypes []string `yaml:"content_types" env:"COMPRESSION_CONTENT_TYPES"`
+}
+
+// LogConfig is hot-reloadable for Level only, the format is fixed at startup
+type LogConfig struct {
+	// Level is one of debug, info, warn, error
+	Level string `yaml:"level" env:"LOG_LEVEL" flag:"log-level"`
+	// Format is json, or text for reading logs in a terminal
+	Format string `yaml:"format" env:"LOG_FORMAT"`
+}
+
+// SlogLevel parses Level, Validate has already rejected anything else
+func (l LogConfig) SlogLevel() slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(l.Level)); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+type MetricsConfig struct {
+	// Addr serves /metrics on a separate plain HTTP listener, e.g. "127.0.0.1:9090", instead
+	// of on the API where it needs the metrics:read permission
+	Addr string `yaml:"addr" env:"METRICS_ADDR"`
+}
+
+type TracingConfig struct {
+	// Exporter is none, stdout for local use, or otlp
+	Exporter string `yaml:"exporter" env:"TRACING_EXPORTER"`
+	// Endpoint is the OTLP/HTTP collector, e.g. "localhost:4318". When empty the standard
+	// OTEL_EXPORTER_OTLP_* env vars apply.
+	Endpoint string `yaml:"endpoint" env:"TRACING_OTLP_ENDPOINT"`
+	Insecure bool   `yaml:"insecure" env:"TRACING_OTLP_INSECURE"`
+	// SampleRatio of new traces to keep, requests joining a sampled trace are always kept
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+// Defaults are the settings the API used before they were configurable
+func Defaults() *Config {
+	minute := time.Minute
+	credentialLimit := func(name, prefix string) RouteRateLimitRule {
+		return RouteRateLimitRule{Prefix: prefix, Policy: RateLimitPolicy{Name: name, Limit: 5, Window: minute}}
+	}
+
+	return &Config{
+		Server: ServerConfig{
+			CertFile:          "cert.pem",
+			KeyFile:           "key.pem",
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      60 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   30 * time.Second,
+		},
+		Database: DatabaseConfig{
+			SlowQueryThreshold: 200 * time.Millisecond,
+			MaxOpenConns:       25,
+			MaxIdleConns:       25,
+			ConnMaxLifetime:    5 * time.Minute,
+		},
+		Auth: AuthConfig{
+			JWTExpiresIn:         15 * time.Minute,
+			ResetTokenExpiresIn:  10 * time.Minute,
+			CookieSameSite:       "strict",
+			PasswordLoginEnabled: true,
+			PublicPaths:          []string{"/execs/login", "/execs/forgot-password", "/execs/reset-password/reset", "/scim/v2"},
+		},
+		Mail: MailConfig{Port: 1025, From: "schooladmin@school.com"},
+		LDAP: LDAPConfig{UserFilter: "(uid=%s)", Timeout: 5 * time.Second},
+		SCIM: SCIMConfig{DefaultRole: "exec"},
+		RateLimit: RateLimitConfig{
+			Default: RateLimitPolicy{Name: "default", Limit: 120, Window: minute},
+			// stricter limits on the unauthenticated credential endpoints
+			Routes: []RouteRateLimitRule{
+				credentialLimit("login", "/execs/login"),
+				credentialLimit("forgot-password", "/execs/forgot-password"),
+				credentialLimit("reset-password", "/execs/reset-password"),
+			},
+		},
+		CORS: CORSConfig{
+			AllowedOrigins:   []string{"https://my-origin.com", "https://their-origin.com", "https://localhost:3000"},
+			AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders:   []string{"Content-Type", "Authorization", "X-API-Key", "X-CSRF-Token", "X-Request-ID", "traceparent", "If-Match", "If-None-Match"},
+			ExposedHeaders:   []string{"Authorization", "X-CSRF-Token", "X-Request-ID", "traceparent", "ETag", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+			AllowCredentials: true,
+			MaxAge:           time.Hour,
+		},
+		Security: SecurityConfig{
+			MaxBodyBytes:     1 << 20,
+			StrictJSON:       true,
+			XSSDefaultAction: "strip",
+			// secrets are compared byte for byte, so they must reach the handlers exactly as sent
+			XSSRoutes: []XSSRouteRule{{Prefix: "/", Fields: map[string]string{
+				"password":         "allow",
+				"current_password": "allow",
+				"new_password":     "allow",
+				"confirm_password": "allow",
+			}}},
+			HPPMultiValue: "first",
+		},
+		Compression: CompressionConfig{
+			MinSize:      1024,
+			ContentTypes: []string{"text/", "application/json", "application/problem+json", "application/scim+json", "application/javascript", "application/xml", "image/svg+xml"},
+		},
+		Log:     LogConfig{Level: "info", Format: "json"},
+		Tracing: TracingConfig{Exporter: "none", SampleRatio: 1},
+	}
+}
+
+// normalize lower-cases the settings that take one of a fixed set of values, so "Strip" works
+// like "strip" everywhere they are read and not only in Validate
+func (c *Config) normalize() {
+	for _, value := range []*string{
+		&c.Auth.CookieSameSite,
+		&c.Security.XSSDefaultAction,
+		&c.Security.HPPMultiValue,
+		&c.Tracing.Exporter,
+		&c.Log.Level,
+		&c.Log.Format,
+	} {
+		*value = strings.ToLower(strings.TrimSpace(*value))
+	}
+	for i, route := range c.Security.XSSRoutes {
+		c.Security.XSSRoutes[i].Method = strings.ToUpper(strings.TrimSpace(route.Method))
+		c.Security.XSSRoutes[i].Action = strings.ToLower(strings.TrimSpace(route.Action))
+		for field, action := range route.Fields {
+			route.Fields[field] = strings.ToLower(strings.TrimSpace(action))
+		}
+	}
+}
+
+// Validate reports every problem at once so a bad deploy fails with the full list
+func (c *Config) Validate() error {
+	var errs []error
+	require := func(name, value string) {
+		if strings.TrimSpace(value) == "" {
+			errs = append(errs, fmt.Errorf("%s is required", name))
+		}
+	}
+	positive := func(name string, d time.Duration) {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be a positive duration, got %s", name, d))
+		}
+	}
+	nonNegative := func(name string, d time.Duration) {
+		if d < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative, got %s", name, d))
+		}
+	}
+	// case-sensitive, Load has already normalized the values
+	oneOf := func(name, value string, allowed ...string) {
+		if !slices.Contains(allowed, value) {
+			errs = append(errs, fmt.Errorf("%s must be one of %s, got %q", name, strings.Join(allowed, ", "), value))
+		}
+	}
+
+	require("server.port (API_PORT)", c.Server.Port)
+	require("server.cert_file", c.Server.CertFile)
+	require("server.key_file", c.Server.KeyFile)
+	nonNegative("server.read_header_timeout (SERVER_READ_HEADER_TIMEOUT)", c.Server.ReadHeaderTimeout)
+	nonNegative("server.read_timeout (SERVER_READ_TIMEOUT)", c.Server.ReadTimeout)
+	nonNegative("server.write_timeout (SERVER_WRITE_TIMEOUT)", c.Server.WriteTimeout)
+	nonNegative("server.idle_timeout (SERVER_IDLE_TIMEOUT)", c.Server.IdleTimeout)
+	positive("server.shutdown_timeout (SERVER_SHUTDOWN_TIMEOUT)", c.Server.ShutdownTimeout)
+	require("database.user (DB_USER)", c.Database.User)
+	require("database.host (HOST_IP)", c.Database.Host)
+	require("database.port (DB_PORT)", c.Database.Port)
+	require("database.name (DB_NAME)", c.Database.Name)
+	require("auth.jwt_secret (JWT_SECRET)", c.Auth.JWTSecret)
+	positive("auth.jwt_expires_in (JWT_EXPIRES_IN)", c.Auth.JWTExpiresIn)
+	positive("auth.reset_token_expires_in (RESET_JWT_EXP_DURATION)", c.Auth.ResetTokenExpiresIn)
+	oneOf("auth.cookie_samesite (COOKIE_SAMESITE)", c.Auth.CookieSameSite, "strict", "lax", "none")
+
+	if c.OIDC.Issuer != "" {
+		require("oidc.client_id (OIDC_CLIENT_ID)", c.OIDC.ClientID)
+		require("oidc.redirect_url (OIDC_REDIRECT_URL)", c.OIDC.RedirectURL)
+	}
+	if c.LDAP.URL != "" {
+		require("ldap.base_dn (LDAP_BASE_DN)", c.LDAP.BaseDN)
+		positive("ldap.timeout (LDAP_TIMEOUT)", c.LDAP.Timeout)
+	}
+
+	if c.RateLimit.Default.Limit < 0 {
+		errs = append(errs, errors.New("rate_limit.default.limit must not be negative"))
+	}
+	for i, route := range append([]RouteRateLimitRule{{Policy: c.RateLimit.Default}}, c.RateLimit.Routes...) {
+		if route.Policy.Limit > 0 {
+			positive(fmt.Sprintf("rate_limit window of rule %d", i), route.Policy.Window)
+		}
+	}
+	if c.Database.SlowQueryThreshold < 0 {
+		errs = append(errs, errors.New("database.slow_query_threshold must not be negative"))
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("database.max_open_conns and database.max_idle_conns must not be negative"))
+	}
+	if c.CORS.MaxAge < 0 {
+		errs = append(errs, errors.New("cors.max_age must not be negative"))
+	}
+	// any site could make credentialed requests as the signed-in user
+	if c.CORS.AllowCredentials && slices.Contains(c.CORS.AllowedOrigins, "*") {
+		errs = append(errs, errors.New(`cors.allowed_origins must not contain "*" when cors.allow_credentials is set`))
+	}
+	if c.Compression.MinSize < 0 {
+		errs = append(errs, errors.New("compression.min_size must not be negative"))
+	}
+	if c.Security.MaxBodyBytes < 0 {
+		errs = append(errs, errors.New("security.max_body_bytes must not be negative"))
+	}
+	oneOf("security.xss_default_action (XSS_DEFAULT_ACTION)", c.Security.XSSDefaultAction, "strip", "reject", "allow", "encode")
+	xssActions := []string{"strip", "reject", "allow", "encode"}
+	for i, route := range c.Security.XSSRoutes {
+		if route.Action != "" {
+			oneOf(fmt.Sprintf("security.xss_routes[%d].action", i), route.Action, xssActions...)
+		}
+		for field, action := range route.Fields {
+			oneOf(fmt.Sprintf("security.xss_routes[%d].fields.%s", i, field), action, xssActions...)
+		}
+	}
+	oneOf("security.hpp_multi_value (HPP_MULTI_VALUE)", c.Security.HPPMultiValue, "first", "last", "reject")
+	oneOf("tracing.exporter (TRACING_EXPORTER)", c.Tracing.Exporter, "none", "stdout", "otlp")
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("tracing.sample_ratio (TRACING_SAMPLE_RATIO) must be between 0 and 1, got %v", c.Tracing.SampleRatio))
+	}
+	oneOf("log.level (LOG_LEVEL)", c.Log.Level, "debug", "info", "warn", "error")
+	oneOf("log.format (LOG_FORMAT)", c.Log.Format, "json", "text")
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setRequired sets the env vars Validate insists on, and configFile as CONFIG_FILE
+func setRequired(t *testing.T, configFile string) {
+	for name, value := range map[string]string{
+		"CONFIG_FILE": configFile,
+		"API_PORT":    "3000",
+		"DB_USER":     "school",
+		"HOST_IP":     "127.0.0.1",
+		"DB_PORT":     "3306",
+		"DB_NAME":     "school",
+		"JWT_SECRET":  "secret",
+	} {
+		t.Setenv(name, value)
+	}
+}
+
+func TestLoadNormalizesCase(t *testing.T) {
+	setRequired(t, "")
+	tests := []struct {
+		env, value, want string
+		got              func(*Config) string
+	}{
+		{"XSS_DEFAULT_ACTION", "Reject", "reject", func(c *Config) string { return c.Security.XSSDefaultAction }},
+		{"HPP_MULTI_VALUE", " LAST", "last", func(c *Config) string { return c.Security.HPPMultiValue }},
+		{"COOKIE_SAMESITE", "Lax", "lax", func(c *Config) string { return c.Auth.CookieSameSite }},
+		{"TRACING_EXPORTER", "None", "none", func(c *Config) string { return c.Tracing.Exporter }},
+		{"LOG_LEVEL", "DEBUG", "debug", func(c *Config) string { return c.Log.Level }},
+		{"LOG_FORMAT", "Text", "text", func(c *Config) string { return c.Log.Format }},
+	}
+	for _, tt := range tests {
+		t.Setenv(tt.env, tt.value)
+	}
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		if got := tt.got(cfg); got != tt.want {
+			t.Errorf("%s=%q loaded as %q, want %q", tt.env, tt.value, got, tt.want)
+		}
+	}
+
+	// Validate itself is case-sensitive, only Load normalizes
+	cfg.Security.XSSDefaultAction = "Strip"
+	if err := cfg.Validate(); err == nil {
+		t.Error(`Validate accepted "Strip"`)
+	}
+}
+
+func TestXSSRoutes(t *testing.T) {
+	setRequired(t, "")
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Security.XSSRoutes) != 1 || cfg.Security.XSSRoutes[0].Fields["password"] != "allow" {
+		t.Errorf("default xss_routes = %+v, want password allowed everywhere", cfg.Security.XSSRoutes)
+	}
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := `
+security:
+  xss_routes:
+    - prefix: /students
+      method: post
+      action: Reject
+      fields:
+        notes: Encode
+`
+	if err := os.WriteFile(file, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	setRequired(t, file)
+	cfg, err = Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := XSSRouteRule{Method: "POST", Prefix: "/students", Action: "reject", Fields: map[string]string{"notes": "encode"}}
+	if routes := cfg.Security.XSSRoutes; len(routes) != 1 || routes[0].Method != want.Method || routes[0].Prefix != want.Prefix ||
+		routes[0].Action != want.Action || routes[0].Fields["notes"] != "encode" {
+		t.Errorf("xss_routes = %+v, want only %+v", routes, want)
+	}
+
+	cfg.Security.XSSRoutes[0].Fields["notes"] = "escape"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "security.xss_routes[0].fields.notes") {
+		t.Errorf("Validate = %v, want the unknown field action reported", err)
+	}
+}
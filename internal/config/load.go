@@ -0,0 +1,182 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Load builds the config from defaults, the YAML file named by -config (or CONFIG_FILE),
+// env vars and finally flags, then validates it
+func Load(args []string) (*Config, error) {
+	cfg := Defaults()
+
+	fs := flag.NewFlagSet("schoolapi", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	flags := make(map[string]reflect.Value)
+	registerFlags(fs, reflect.ValueOf(cfg).Elem(), flags)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		if err := loadFile(*path, cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(cfg).Elem()); err != nil {
+		return nil, err
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		if field, ok := flags[f.Name]; ok {
+			if err := setField(field, f.Value.String(), ""); err != nil {
+				flagErr = errors.Join(flagErr, fmt.Errorf("-%s: %w", f.Name, err))
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+	// mail used to go out through the API host, keep that when no SMTP host is set
+	if cfg.Mail.Host == "" {
+		cfg.Mail.Host = cfg.Server.Host
+	}
+
+	cfg.normalize()
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// OnReload calls apply with a freshly loaded config on every SIGHUP. Only the sections marked
+// hot-reloadable are expected to take effect. A config that fails to load is logged and the
+// running settings stay in place. The returned func stops listening.
+func OnReload(args []string, apply func(*Config)) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			cfg, err := Load(args)
+			if err != nil {
+				slog.Error("Config reload failed, keeping the current settings", "error", err)
+				continue
+			}
+			apply(cfg)
+			slog.Info("Config reloaded")
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
+
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	// a typo in a key would otherwise silently leave the default in place
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func registerFlags(fs *flag.FlagSet, v reflect.Value, flags map[string]reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if name := t.Field(i).Tag.Get("flag"); name != "" {
+			fs.String(name, "", fmt.Sprintf("overrides %s", t.Field(i).Tag.Get("yaml")))
+			flags[name] = field
+		} else if field.Kind() == reflect.Struct && field.Type() != reflect.TypeOf(time.Duration(0)) {
+			registerFlags(fs, field, flags)
+		}
+	}
+}
+
+func applyEnv(v reflect.Value) error {
+	var errs []error
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if name := t.Field(i).Tag.Get("env"); name != "" {
+			if raw, ok := os.LookupEnv(name); ok && raw != "" {
+				if err := setField(field, raw, t.Field(i).Tag.Get("unit")); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				}
+			}
+		} else if field.Kind() == reflect.Struct {
+			if err := applyEnv(field); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// setField parses raw into the field's type. unit is appended to bare numbers for durations,
+// for settings that used to be given in minutes.
+func setField(field reflect.Value, raw, unit string) error {
+	raw = strings.TrimSpace(raw)
+	switch {
+	case field.Type() == reflect.TypeOf(time.Duration(0)):
+		if _, err := strconv.Atoi(raw); err == nil && unit != "" {
+			raw += unit
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.String:
+		field.SetString(raw)
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case field.Kind() == reflect.Int || field.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		var list []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		// structured values such as LDAP_GROUP_ROLES are given as JSON
+		target := reflect.New(field.Type())
+		if err := json.Unmarshal([]byte(raw), target.Interface()); err != nil {
+			return err
+		}
+		field.Set(target.Elem())
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+type AuditEntry struct {
+	ID        int64                  `json:"id"`
+	Actor     string                 `json:"actor"`
+	Action    string                 `json:"action"`
+	Entity    string                 `json:"entity"`
+	EntityID  int                    `json:"entity_id"`
+	Changes   map[string]AuditChange `json:"changes"`
+	RequestID string                 `json:"request_id,omitempty"`
+	CreatedAt string                 `json:"created_at"`
+}
+
+// AuditChange is one field's values around a change, Before is absent on creates and After on deletes
+type AuditChange struct {
+	Before any `json:"before,omitempty"`
+	After  any `json:"after,omitempty"`
+}
+
+// AuditFilter narrows GET /audit, zero values don't filter
+type AuditFilter struct {
+	Entity   string
+	EntityID int
+	Actor    string
+	From     time.Time
+	To       time.Time
+}
@@ -4,17 +4,19 @@ import "database/sql"
 
 type Exec struct {
 	ID                   int            `json:"id,omitempty" db:"id,omitempty"`
-	FirstName            string         `json:"first_name,omitempty" db:"first_name,omitempty"`
-	LastName             string         `json:"last_name,omitempty" db:"last_name,omitempty"`
-	Email                string         `json:"email,omitempty" db:"email,omitempty"`
-	Username             string         `json:"username,omitempty" db:"username,omitempty"`
-	Password             string         `json:"password,omitempty" db:"password,omitempty"`
+	FirstName            string         `json:"first_name,omitempty" db:"first_name,omitempty" validate:"required,max=255"`
+	LastName             string         `json:"last_name,omitempty" db:"last_name,omitempty" validate:"required,max=255"`
+	Email                string         `json:"email,omitempty" db:"email,omitempty" validate:"required,email,max=255"`
+	Username             string         `json:"username,omitempty" db:"username,omitempty" validate:"required,min=3,max=255"`
+	Password             string         `json:"password,omitempty" db:"password,omitempty" validate:"required,min=8,max=128"`
 	PasswordChangedAt    sql.NullString `json:"password_changed_at,omitempty" db:"password_changed_at,omitempty"`
 	UserCreatedAt        sql.NullString `json:"user_created_at,omitempty" db:"user_created_at,omitempty"`
 	PasswordResetToken   sql.NullString `json:"password_reset_token,omitempty" db:"password_reset_token,omitempty"`
 	PasswordTokenExpires sql.NullString `json:"password_token_expires,omitempty" db:"password_token_expires,omitempty"`
 	StatusInactive       bool           `json:"status_inactive,omitempty" db:"status_inactive,omitempty"`
-	Role                 string         `json:"role,omitempty" db:"role,omitempty"`
+	Role                 string         `json:"role,omitempty" db:"role,omitempty" validate:"max=50"`
+	// Version is bumped by every write, send it back to update the exec
+	Version int `json:"version,omitempty"`
 }
 
 type UpdatePasswordRequest struct {
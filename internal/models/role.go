@@ -0,0 +1,17 @@
+package models
+
+type Role struct {
+	ID          int      `json:"id,omitempty" db:"id,omitempty"`
+	Name        string   `json:"name,omitempty" db:"name,omitempty"`
+	Description string   `json:"description,omitempty" db:"description,omitempty"`
+	Permissions []string `json:"permissions,omitempty" db:"permissions,omitempty"`
+}
+
+type RoleChange struct {
+	ID        int    `json:"id"`
+	ExecID    int    `json:"exec_id"`
+	OldRole   string `json:"old_role"`
+	NewRole   string `json:"new_role"`
+	ChangedBy string `json:"changed_by"`
+	ChangedAt string `json:"changed_at"`
+}
@@ -0,0 +1,94 @@
+package models
+
+const (
+	SCIMUserSchema           = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMGroupSchema          = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SCIMEnterpriseUserSchema = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+	SCIMListResponseSchema   = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMPatchOpSchema        = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIMErrorSchema          = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// SCIMUser represents either an exec or a teacher, told apart by UserType
+type SCIMUser struct {
+	Schemas    []string        `json:"schemas"`
+	ID         string          `json:"id,omitempty"`
+	UserName   string          `json:"userName"`
+	UserType   string          `json:"userType"`
+	Name       SCIMName        `json:"name"`
+	Emails     []SCIMEmail     `json:"emails,omitempty"`
+	Active     *bool           `json:"active,omitempty"`
+	Password   string          `json:"password,omitempty"`
+	Enterprise *SCIMEnterprise `json:"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User,omitempty"`
+	Groups     []SCIMMember    `json:"groups,omitempty"`
+	Meta       *SCIMMeta       `json:"meta,omitempty"`
+}
+
+type SCIMName struct {
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type SCIMEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// SCIMEnterprise carries a teacher's subject as the department
+type SCIMEnterprise struct {
+	Department string `json:"department,omitempty"`
+}
+
+// SCIMGroup represents a role. Its members are the execs holding that role.
+type SCIMGroup struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []SCIMMember `json:"members,omitempty"`
+	Meta        *SCIMMeta    `json:"meta,omitempty"`
+}
+
+type SCIMMember struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+type SCIMMeta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	Location     string `json:"location,omitempty"`
+}
+
+type SCIMListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    any      `json:"Resources"`
+}
+
+type SCIMPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations"`
+}
+
+type SCIMPatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path,omitempty"`
+	Value any    `json:"value,omitempty"`
+}
+
+// SCIMFilter is one "attribute operator value" comparison of a filter expression
+type SCIMFilter struct {
+	Attribute string
+	Operator  string
+	Value     any
+}
+
+type SCIMError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	SCIMType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
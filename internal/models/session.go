@@ -0,0 +1,21 @@
+package models
+
+type Session struct {
+	ID         string  `json:"id"`
+	ExecID     int     `json:"exec_id"`
+	IP         string  `json:"ip"`
+	UserAgent  string  `json:"user_agent"`
+	CreatedAt  string  `json:"created_at"`
+	LastSeenAt string  `json:"last_seen_at"`
+	RevokedAt  *string `json:"revoked_at,omitempty"`
+}
+
+type LoginAttempt struct {
+	ID          int    `json:"id"`
+	Username    string `json:"username"`
+	IP          string `json:"ip"`
+	UserAgent   string `json:"user_agent"`
+	Success     bool   `json:"success"`
+	Reason      string `json:"reason,omitempty"`
+	AttemptedAt string `json:"attempted_at"`
+}
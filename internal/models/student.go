@@ -2,8 +2,10 @@ package models
 
 type Student struct {
 	ID        int    `json:"id,omitempty" db:"id,omitempty"`
-	FirstName string `json:"first_name,omitempty" db:"first_name,omitempty"`
-	LastName  string `json:"last_name,omitempty" db:"last_name,omitempty"`
-	Email     string `json:"email,omitempty" db:"email,omitempty"`
-	Class     string `json:"class,omitempty" db:"class,omitempty"`
+	FirstName string `json:"first_name,omitempty" db:"first_name,omitempty" validate:"required,max=255"`
+	LastName  string `json:"last_name,omitempty" db:"last_name,omitempty" validate:"required,max=255"`
+	Email     string `json:"email,omitempty" db:"email,omitempty" validate:"required,email,max=255"`
+	Class     string `json:"class,omitempty" db:"class,omitempty" validate:"required,max=255"`
+	// Version is bumped by every write, send it back to update the student
+	Version int `json:"version,omitempty"`
 }
@@ -0,0 +1,9 @@
+package models
+
+// VersionConflict is one row an update was refused for, because it changed since the client
+// read it
+type VersionConflict struct {
+	ID              int `json:"id"`
+	ExpectedVersion int `json:"expected_version"`
+	CurrentVersion  int `json:"current_version"`
+}
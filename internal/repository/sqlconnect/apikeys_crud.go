@@ -0,0 +1,225 @@
+package sqlconnect
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"schoolapi/internal/models"
+	"schoolapi/pkg/utils"
+	"strings"
+	"time"
+)
+
+// API keys look like sk_<8 hex prefix>_<64 hex secret>
+const apiKeyPrefix = "sk_"
+
+const apiKeyColumns = "id, name, prefix, role, scopes, expires_at, last_used_at, revoked_at, created_by, created_at"
+
+func (db *DB) CreateAPIKeyDB(ctx context.Context, newKey models.APIKey, createdBy string) (models.APIKey, error) {
+	defer observe("CreateAPIKeyDB")()
+	if newKey.Name == "" || newKey.Role == "" {
+		return models.APIKey{}, utils.ErrorHandler(errors.New("missing name or role"), "name and role are required")
+	}
+
+	if err := roleExists(ctx, db, newKey.Role); err != nil {
+		return models.APIKey{}, err
+	}
+
+	var expiresAt any
+	if newKey.ExpiresAt != nil {
+		expiry, err := time.Parse(time.RFC3339, *newKey.ExpiresAt)
+		if err != nil {
+			return models.APIKey{}, utils.ErrorHandler(err, "expires_at must be an RFC 3339 timestamp")
+		}
+		expiresAt = expiry.UTC().Format(time.DateTime)
+	}
+
+	if newKey.Scopes == nil {
+		newKey.Scopes = []string{}
+	}
+	scopes, err := json.Marshal(newKey.Scopes)
+	if err != nil {
+		return models.APIKey{}, utils.ErrorHandler(err, "error creating API key")
+	}
+
+	prefixBytes := make([]byte, 4)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return models.APIKey{}, utils.ErrorHandler(err, "error creating API key")
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return models.APIKey{}, utils.ErrorHandler(err, "error creating API key")
+	}
+	prefix := hex.EncodeToString(prefixBytes)
+	rawKey := fmt.Sprintf("%s%s_%s", apiKeyPrefix, prefix, hex.EncodeToString(secretBytes))
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.APIKey{}, utils.ErrorHandler(err, "error creating API key")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "INSERT INTO api_keys (name, prefix, key_hash, role, scopes, expires_at, created_by) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		newKey.Name, prefix, hashAPIKey(rawKey), newKey.Role, scopes, expiresAt, createdBy)
+	if err != nil {
+		return models.APIKey{}, utils.ErrorHandler(err, "error creating API key")
+	}
+	lastId, err := res.LastInsertId()
+	if err != nil {
+		return models.APIKey{}, utils.ErrorHandler(err, "error getting last inserted ID")
+	}
+
+	key, err := scanAPIKey(tx.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE id = ?", lastId))
+	if err != nil {
+		return models.APIKey{}, utils.ErrorHandler(err, "error creating API key")
+	}
+	if err := recordAudit(ctx, tx, auditCreate, "api_key", key.ID, nil, key); err != nil {
+		return models.APIKey{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.APIKey{}, utils.ErrorHandler(err, "error creating API key")
+	}
+	key.Key = rawKey
+	return key, nil
+}
+
+func (db *DB) GetAPIKeysDB(ctx context.Context) ([]models.APIKey, error) {
+	defer observe("GetAPIKeysDB")()
+	rows, err := db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY id")
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "error retrieving API keys")
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, utils.ErrorHandler(err, "error retrieving API keys")
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, utils.ErrorHandler(err, "error retrieving API keys")
+	}
+	return keys, nil
+}
+
+func (db *DB) GetAPIKeyDB(ctx context.Context, id int) (models.APIKey, error) {
+	defer observe("GetAPIKeyDB")()
+	key, err := scanAPIKey(db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return models.APIKey{}, utils.ErrorHandler(err, "API key not found")
+	} else if err != nil {
+		return models.APIKey{}, utils.ErrorHandler(err, "Database query error")
+	}
+	return key, nil
+}
+
+func (db *DB) RevokeAPIKeyDB(ctx context.Context, id int) error {
+	defer observe("RevokeAPIKeyDB")()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return utils.ErrorHandler(err, "error revoking API key")
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL", id)
+	if err != nil {
+		return utils.ErrorHandler(err, "error revoking API key")
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return utils.ErrorHandler(err, "error revoking API key")
+	}
+	if n == 0 {
+		return utils.ErrorHandler(errors.New("no rows affected"), "API key not found")
+	}
+	after, err := scanAPIKey(tx.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE id = ?", id))
+	if err != nil {
+		return utils.ErrorHandler(err, "error revoking API key")
+	}
+	before := after
+	before.RevokedAt = nil
+	if err := recordAudit(ctx, tx, auditUpdate, "api_key", id, before, after); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return utils.ErrorHandler(err, "error revoking API key")
+	}
+	return nil
+}
+
+// apiKeyTouchInterval is how stale last_used_at gets before a request writes it again, so
+// authenticating a key doesn't cost a write every time
+const apiKeyTouchInterval = time.Minute
+
+// AuthenticateAPIKeyDB looks the key up by its prefix, verifies the hash and records its use,
+// at most once per apiKeyTouchInterval
+func (db *DB) AuthenticateAPIKeyDB(ctx context.Context, rawKey string) (models.APIKey, error) {
+	defer observe("AuthenticateAPIKeyDB")()
+	prefix, ok := parseAPIKeyPrefix(rawKey)
+	if !ok {
+		return models.APIKey{}, utils.ErrorHandler(errors.New("malformed API key"), "invalid API key")
+	}
+
+	var keyHash string
+	var expired bool
+	if err := db.QueryRowContext(ctx, "SELECT key_hash, expires_at IS NOT NULL AND expires_at <= UTC_TIMESTAMP() FROM api_keys WHERE prefix = ?", prefix).Scan(&keyHash, &expired); err != nil {
+		return models.APIKey{}, utils.ErrorHandler(err, "invalid API key")
+	}
+	if subtle.ConstantTimeCompare([]byte(keyHash), []byte(hashAPIKey(rawKey))) != 1 {
+		return models.APIKey{}, utils.ErrorHandler(errors.New("API key hash mismatch"), "invalid API key")
+	}
+
+	key, err := scanAPIKey(db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE prefix = ?", prefix))
+	if err != nil {
+		return models.APIKey{}, utils.ErrorHandler(err, "internal error")
+	}
+	if key.RevokedAt != nil {
+		return models.APIKey{}, utils.ErrorHandler(errors.New("API key revoked"), "API key has been revoked")
+	}
+	if expired {
+		return models.APIKey{}, utils.ErrorHandler(errors.New("API key expired"), "API key has expired")
+	}
+
+	if _, err := db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = ? AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL ? SECOND)", key.ID, int(apiKeyTouchInterval.Seconds())); err != nil {
+		return models.APIKey{}, utils.ErrorHandler(err, "internal error")
+	}
+	return key, nil
+}
+
+func parseAPIKeyPrefix(rawKey string) (string, bool) {
+	rest, ok := strings.CutPrefix(rawKey, apiKeyPrefix)
+	if !ok {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(prefix) != 8 || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+func hashAPIKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func scanAPIKey(row interface{ Scan(...any) error }) (models.APIKey, error) {
+	var key models.APIKey
+	var scopes []byte
+	if err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.Role, &scopes, &key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt, &key.CreatedBy, &key.CreatedAt); err != nil {
+		return models.APIKey{}, err
+	}
+	if err := json.Unmarshal(scopes, &key.Scopes); err != nil {
+		return models.APIKey{}, err
+	}
+	return key, nil
+}
@@ -0,0 +1,154 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"schoolapi/internal/models"
+	"schoolapi/pkg/utils"
+)
+
+const (
+	auditCreate = "create"
+	auditUpdate = "update"
+	auditDelete = "delete"
+)
+
+// redactedFields are recorded as changed without their values
+var redactedFields = map[string]bool{
+	"password":             true,
+	"password_reset_token": true,
+	"key":                  true,
+}
+
+// recordAudit writes an audit_log row in the transaction making the change, so one is never
+// committed without the other. before is nil on creates and after on deletes.
+func recordAudit(ctx context.Context, tx *sql.Tx, action, entity string, id int, before, after any) error {
+	changes, err := auditChanges(before, after)
+	if err != nil {
+		return utils.ErrorHandler(err, "error recording audit log")
+	}
+	if action == auditUpdate && len(changes) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return utils.ErrorHandler(err, "error recording audit log")
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO audit_log (actor, action, entity, entity_id, changes, request_id) VALUES (?, ?, ?, ?, ?, ?)",
+		truncate(utils.Actor(ctx), 255), action, entity, id, data, utils.RequestID(ctx))
+	if err != nil {
+		return utils.ErrorHandler(err, "error recording audit log")
+	}
+	return nil
+}
+
+// auditChanges compares the JSON of both snapshots, so the fields are named as in the API
+func auditChanges(before, after any) (map[string]models.AuditChange, error) {
+	old, err := auditSnapshot(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := auditSnapshot(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]models.AuditChange{}
+	for field, value := range old {
+		if !reflect.DeepEqual(value, updated[field]) {
+			changes[field] = models.AuditChange{Before: value, After: updated[field]}
+		}
+	}
+	for field, value := range updated {
+		if _, ok := old[field]; !ok {
+			changes[field] = models.AuditChange{After: value}
+		}
+	}
+	delete(changes, "id")
+
+	for field, change := range changes {
+		if !redactedFields[field] {
+			continue
+		}
+		if change.Before != nil {
+			change.Before = "[redacted]"
+		}
+		if change.After != nil {
+			change.After = "[redacted]"
+		}
+		changes[field] = change
+	}
+	return changes, nil
+}
+
+func auditSnapshot(v any) (map[string]any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot map[string]any
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func (db *DB) GetAuditLogDB(ctx context.Context, filter models.AuditFilter, limit, page int) ([]models.AuditEntry, int, error) {
+	defer observe("GetAuditLogDB")()
+	where := " WHERE 1=1"
+	var args []any
+	if filter.Entity != "" {
+		where += " AND entity = ?"
+		args = append(args, filter.Entity)
+	}
+	if filter.EntityID != 0 {
+		where += " AND entity_id = ?"
+		args = append(args, filter.EntityID)
+	}
+	if filter.Actor != "" {
+		where += " AND actor = ?"
+		args = append(args, filter.Actor)
+	}
+	if !filter.From.IsZero() {
+		where += " AND created_at >= ?"
+		args = append(args, filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		where += " AND created_at < ?"
+		args = append(args, filter.To.UTC())
+	}
+
+	var totalCount int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_log"+where, args...).Scan(&totalCount); err != nil {
+		return nil, 0, utils.ErrorHandler(err, "error retrieving audit log")
+	}
+
+	query := "SELECT id, actor, action, entity, entity_id, changes, request_id, created_at FROM audit_log" + where + " ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?"
+	rows, err := db.QueryContext(ctx, query, append(args, limit, (page-1)*limit)...)
+	if err != nil {
+		return nil, 0, utils.ErrorHandler(err, "error retrieving audit log")
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var entry models.AuditEntry
+		var changes []byte
+		if err := rows.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.Entity, &entry.EntityID, &changes, &entry.RequestID, &entry.CreatedAt); err != nil {
+			return nil, 0, utils.ErrorHandler(err, "error retrieving audit log")
+		}
+		if err := json.Unmarshal(changes, &entry.Changes); err != nil {
+			return nil, 0, utils.ErrorHandler(err, "error retrieving audit log")
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, utils.ErrorHandler(err, "error retrieving audit log")
+	}
+	return entries, totalCount, nil
+}
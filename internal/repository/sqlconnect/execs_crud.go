@@ -1,16 +1,17 @@
 package sqlconnect
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
 	"reflect"
+	"schoolapi/internal/config"
 	"schoolapi/internal/models"
 	"schoolapi/pkg/utils"
 	"strconv"
@@ -19,14 +20,10 @@ import (
 	"github.com/go-mail/mail/v2"
 )
 
-func GetExecDB(id int) (models.Exec, error) {
-	db, err := ConnectDB()
-	if err != nil {
-		return models.Exec{}, utils.ErrorHandler(err, "error connecting to DB")
-	}
-	defer db.Close()
+func (db *DB) GetExecDB(ctx context.Context, id int) (models.Exec, error) {
+	defer observe("GetExecDB")()
 	var exec models.Exec
-	err = db.QueryRow("SELECT id, first_name, last_name, email, username, user_created_at, status_inactive, role FROM execs WHERE id = ?", id).Scan(&exec.ID, &exec.FirstName, &exec.LastName, &exec.Email, &exec.Username, &exec.UserCreatedAt, &exec.StatusInactive, &exec.Role)
+	err := db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, username, user_created_at, status_inactive, role, version FROM execs WHERE id = ?", id).Scan(&exec.ID, &exec.FirstName, &exec.LastName, &exec.Email, &exec.Username, &exec.UserCreatedAt, &exec.StatusInactive, &exec.Role, &exec.Version)
 	if err == sql.ErrNoRows {
 		return models.Exec{}, utils.ErrorHandler(err, "Exec not found")
 	} else if err != nil {
@@ -35,32 +32,28 @@ func GetExecDB(id int) (models.Exec, error) {
 	return exec, nil
 }
 
-func GetExecsDB(execs []models.Exec, r *http.Request) ([]models.Exec, error) {
+func (db *DB) GetExecsDB(execs []models.Exec, r *http.Request) ([]models.Exec, error) {
+	defer observe("GetExecsDB")()
+	ctx := r.Context()
 
-	query := "SELECT id, first_name, last_name, email, username, user_created_at, status_inactive, role FROM execs WHERE 1=1"
+	query := "SELECT id, first_name, last_name, email, username, user_created_at, status_inactive, role, version FROM execs WHERE 1=1"
 	var args []any
 
-	query, args = addFilters(r, query, args)
-	query = addSorting(r, query)
-
-	db, err := ConnectDB()
-	if err != nil {
-		return nil, utils.ErrorHandler(err, "error connecting to DB")
-	}
-	defer db.Close()
+	query, args = addFilters(r, query, args, ExecFilters)
+	query = addSorting(r, query, ExecFilters)
 
-	rows, err := db.Query(query, args...)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
-		log.Printf("Query error: %v", err)
+		slog.Error("Query error", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var exec models.Exec
-		err := rows.Scan(&exec.ID, &exec.FirstName, &exec.LastName, &exec.Email, &exec.Username, &exec.UserCreatedAt, &exec.StatusInactive, &exec.Role)
+		err := rows.Scan(&exec.ID, &exec.FirstName, &exec.LastName, &exec.Email, &exec.Username, &exec.UserCreatedAt, &exec.StatusInactive, &exec.Role, &exec.Version)
 		if err != nil {
-			log.Printf("Row scan error: %v", err)
+			slog.Error("Row scan error", "error", err)
 			return nil, err
 		}
 		execs = append(execs, exec)
@@ -68,14 +61,16 @@ func GetExecsDB(execs []models.Exec, r *http.Request) ([]models.Exec, error) {
 	return execs, nil
 }
 
-func AddExecsDB(newExecs []models.Exec) ([]models.Exec, error) {
-	db, err := ConnectDB()
+// AddExecsDB inserts the execs and records the role each starts with in the role history
+func (db *DB) AddExecsDB(ctx context.Context, newExecs []models.Exec, changedBy string) ([]models.Exec, error) {
+	defer observe("AddExecsDB")()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "error connecting to DB")
+		return nil, utils.ErrorHandler(err, "error inserting data into DB")
 	}
-	defer db.Close()
+	defer tx.Rollback()
 
-	stmt, err := db.Prepare(generateInsertQuery(models.Exec{}, "execs"))
+	stmt, err := tx.PrepareContext(ctx, generateInsertQuery(models.Exec{}, "execs"))
 	if err != nil {
 		return nil, utils.ErrorHandler(err, "error preparing SQL statement")
 	}
@@ -83,6 +78,12 @@ func AddExecsDB(newExecs []models.Exec) ([]models.Exec, error) {
 
 	addedExecs := make([]models.Exec, len(newExecs))
 	for i, newExec := range newExecs {
+		if newExec.Role != "" {
+			if err := roleExists(ctx, tx, newExec.Role); err != nil {
+				return nil, err
+			}
+		}
+
 		// check if password exists
 		newExec.Password, err = utils.HashPassword(newExec.Password)
 		if err != nil {
@@ -90,7 +91,7 @@ func AddExecsDB(newExecs []models.Exec) ([]models.Exec, error) {
 		}
 
 		values := getStructValues(newExec)
-		res, err := stmt.Exec(values...)
+		res, err := stmt.ExecContext(ctx, values...)
 		if err != nil {
 			return nil, utils.ErrorHandler(err, "error inserting data into DB")
 		}
@@ -99,25 +100,50 @@ func AddExecsDB(newExecs []models.Exec) ([]models.Exec, error) {
 			return nil, utils.ErrorHandler(err, "error getting last inserted ID")
 		}
 		newExec.ID = int(lastId)
+		newExec.Version = 1
+		if newExec.Role == "" {
+			// the column default applied
+			if err := tx.QueryRowContext(ctx, "SELECT role FROM execs WHERE id = ?", newExec.ID).Scan(&newExec.Role); err != nil {
+				return nil, utils.ErrorHandler(err, "error inserting data into DB")
+			}
+		}
+		if err := recordRoleChange(ctx, tx, newExec.ID, "", newExec.Role, changedBy); err != nil {
+			return nil, err
+		}
+		if err := recordAudit(ctx, tx, auditCreate, "exec", newExec.ID, nil, execAudit(newExec)); err != nil {
+			return nil, err
+		}
 		addedExecs[i] = newExec
 	}
+	if err := tx.Commit(); err != nil {
+		return nil, utils.ErrorHandler(err, "error inserting data into DB")
+	}
 	return addedExecs, nil
 }
 
-func PatchExecDB(id int, updates map[string]any) (models.Exec, error) {
-	db, err := ConnectDB()
+// PatchExecDB applies updates if the exec is still at version, otherwise it returns a
+// *ConflictError. A role change revokes the exec's sessions, their tokens carry the old role.
+func (db *DB) PatchExecDB(ctx context.Context, id, version int, updates map[string]any, changedBy string) (models.Exec, error) {
+	defer observe("PatchExecDB")()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return models.Exec{}, utils.ErrorHandler(err, "DB connection failed")
+		return models.Exec{}, utils.ErrorHandler(err, "error updating exec")
 	}
-	defer db.Close()
+	defer tx.Rollback()
 
 	var existingExec models.Exec
-	err = db.QueryRow("SELECT id, first_name, last_name, email, username, role FROM execs WHERE id = ?", id).Scan(&existingExec.ID, &existingExec.FirstName, &existingExec.LastName, &existingExec.Email, &existingExec.Username, &existingExec.Role)
+	err = tx.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, username, role, version FROM execs WHERE id = ? FOR UPDATE", id).Scan(&existingExec.ID, &existingExec.FirstName, &existingExec.LastName, &existingExec.Email, &existingExec.Username, &existingExec.Role, &existingExec.Version)
 	if err == sql.ErrNoRows {
 		return models.Exec{}, utils.ErrorHandler(err, "Exec data not found")
 	} else if err != nil {
 		return models.Exec{}, utils.ErrorHandler(err, "Failed to retrieve exec data")
 	}
+	// the row is locked, so the version can't change before the update below
+	if err := checkVersion(id, version, existingExec.Version); err != nil {
+		return models.Exec{}, err
+	}
+	before := execAudit(existingExec)
+	oldRole := existingExec.Role
 
 	execVal := reflect.ValueOf(&existingExec).Elem()
 	execType := execVal.Type()
@@ -131,24 +157,43 @@ func PatchExecDB(id int, updates map[string]any) (models.Exec, error) {
 		}
 	}
 
-	if _, err = db.Exec("UPDATE execs SET first_name = ?, last_name = ?, email = ?, username = ?, role = ? WHERE id = ?", &existingExec.FirstName, &existingExec.LastName, &existingExec.Email, &existingExec.Username, &existingExec.Role, &existingExec.ID); err != nil {
+	if existingExec.Role != oldRole {
+		if err := roleExists(ctx, tx, existingExec.Role); err != nil {
+			return models.Exec{}, err
+		}
+		if err := recordRoleChange(ctx, tx, existingExec.ID, oldRole, existingExec.Role, changedBy); err != nil {
+			return models.Exec{}, err
+		}
+		if err := revokeExecSessions(ctx, tx, existingExec.ID); err != nil {
+			return models.Exec{}, utils.ErrorHandler(err, "error revoking sessions")
+		}
+	}
+
+	if _, err = tx.ExecContext(ctx, "UPDATE execs SET first_name = ?, last_name = ?, email = ?, username = ?, role = ?, version = version + 1 WHERE id = ?", &existingExec.FirstName, &existingExec.LastName, &existingExec.Email, &existingExec.Username, &existingExec.Role, &existingExec.ID); err != nil {
 		return models.Exec{}, utils.ErrorHandler(err, "error updating exec")
 	}
-	return existingExec, nil
-}
+	existingExec.Version++
+	if err := recordAudit(ctx, tx, auditUpdate, "exec", id, before, execAudit(existingExec)); err != nil {
+		return models.Exec{}, err
+	}
 
-func PatchExecsDB(updates []map[string]any) error {
-	db, err := ConnectDB()
-	if err != nil {
-		return err
+	if err := tx.Commit(); err != nil {
+		return models.Exec{}, utils.ErrorHandler(err, "error updating exec")
 	}
-	defer db.Close()
+	return existingExec, nil
+}
 
-	tx, err := db.Begin()
+// PatchExecsDB applies every update or none. Each carries the "version" it was read at, the
+// ones that changed since are all returned in one *ConflictError.
+// Like PatchExecDB, a role change revokes the exec's sessions.
+func (db *DB) PatchExecsDB(ctx context.Context, updates []map[string]any, changedBy string) error {
+	defer observe("PatchExecsDB")()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
+	var conflicts []models.VersionConflict
 	for _, update := range updates {
 		idStr, ok := update["id"].(string)
 		if !ok {
@@ -163,7 +208,7 @@ func PatchExecsDB(updates []map[string]any) error {
 		}
 
 		var execFromDb models.Exec
-		err = db.QueryRow("SELECT id, first_name, last_name, email, username, role FROM execs WHERE id = ?", id).Scan(&execFromDb.ID, &execFromDb.FirstName, &execFromDb.LastName, &execFromDb.Email, &execFromDb.Username, &execFromDb.Role)
+		err = tx.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, username, role, version FROM execs WHERE id = ? FOR UPDATE", id).Scan(&execFromDb.ID, &execFromDb.FirstName, &execFromDb.LastName, &execFromDb.Email, &execFromDb.Username, &execFromDb.Role, &execFromDb.Version)
 		if err != nil {
 			tx.Rollback()
 			if err == sql.ErrNoRows {
@@ -171,13 +216,20 @@ func PatchExecsDB(updates []map[string]any) error {
 			}
 			return utils.ErrorHandler(err, "error patching exec information")
 		}
+		version, _ := update["version"].(float64)
+		if int(version) != execFromDb.Version {
+			conflicts = append(conflicts, models.VersionConflict{ID: id, ExpectedVersion: int(version), CurrentVersion: execFromDb.Version})
+			continue
+		}
+		oldRole := execFromDb.Role
+		before := execAudit(execFromDb)
 
 		execVal := reflect.ValueOf(&execFromDb).Elem()
 		execType := execVal.Type()
 
 		for k, v := range update {
-			if k == "id" {
-				continue // skip updating the ID field
+			if k == "id" || k == "version" {
+				continue // skip updating the ID and version fields
 			}
 			for i := 0; i < execVal.NumField(); i++ {
 				field := execType.Field(i)
@@ -189,7 +241,7 @@ func PatchExecsDB(updates []map[string]any) error {
 							fieldVal.Set(val.Convert(fieldVal.Type()))
 						} else {
 							tx.Rollback()
-							log.Printf("cannot convert %v to %v", val.Type(), fieldVal.Type())
+							slog.Warn("Cannot convert patch value", "from", val.Type().String(), "to", fieldVal.Type().String())
 							return utils.ErrorHandler(err, "Failed to patch exec information")
 						}
 					}
@@ -198,11 +250,35 @@ func PatchExecsDB(updates []map[string]any) error {
 			}
 		}
 
-		_, err = tx.Exec("UPDATE execs SET first_name = ?, last_name = ?, email = ?, username = ?, role = ? WHERE id = ?", execFromDb.FirstName, execFromDb.LastName, execFromDb.Email, execFromDb.Username, execFromDb.Role, execFromDb.ID)
+		if execFromDb.Role != oldRole {
+			if err := roleExists(ctx, tx, execFromDb.Role); err != nil {
+				tx.Rollback()
+				return err
+			}
+			if err := recordRoleChange(ctx, tx, execFromDb.ID, oldRole, execFromDb.Role, changedBy); err != nil {
+				tx.Rollback()
+				return err
+			}
+			if err := revokeExecSessions(ctx, tx, execFromDb.ID); err != nil {
+				tx.Rollback()
+				return utils.ErrorHandler(err, "error revoking sessions")
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE execs SET first_name = ?, last_name = ?, email = ?, username = ?, role = ?, version = version + 1 WHERE id = ?", execFromDb.FirstName, execFromDb.LastName, execFromDb.Email, execFromDb.Username, execFromDb.Role, execFromDb.ID)
 		if err != nil {
 			tx.Rollback()
 			return utils.ErrorHandler(err, "Failed to patch exec information")
 		}
+		execFromDb.Version++
+		if err := recordAudit(ctx, tx, auditUpdate, "exec", id, before, execAudit(execFromDb)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if len(conflicts) > 0 {
+		tx.Rollback()
+		return &ConflictError{Conflicts: conflicts}
 	}
 
 	err = tx.Commit()
@@ -212,38 +288,68 @@ func PatchExecsDB(updates []map[string]any) error {
 	return nil
 }
 
-func DeleteExecDB(id int) error {
-	db, err := ConnectDB()
+func (db *DB) DeleteExecDB(ctx context.Context, id int) error {
+	defer observe("DeleteExecDB")()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return utils.ErrorHandler(err, "DB connection failed")
+		return utils.ErrorHandler(err, "error deleting exec")
 	}
-	defer db.Close()
+	defer tx.Rollback()
 
-	result, err := db.Exec("DELETE FROM execs WHERE id = ?", id)
-	if err != nil {
+	exec, err := lockExec(ctx, tx, id)
+	if err == sql.ErrNoRows {
+		return utils.ErrorHandler(err, "Exec not found")
+	} else if err != nil {
 		return utils.ErrorHandler(err, "error deleting exec")
 	}
-	n, err := result.RowsAffected()
-	if err != nil {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM execs WHERE id = ?", id); err != nil {
 		return utils.ErrorHandler(err, "error deleting exec")
 	}
-
-	if n == 0 {
-		return utils.ErrorHandler(err, "Exec not found")
+	if err := recordAudit(ctx, tx, auditDelete, "exec", id, execAudit(exec), nil); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return utils.ErrorHandler(err, "error deleting exec")
 	}
 	return nil
 }
 
-func GetUserByUsername(w http.ResponseWriter, req models.Exec) (models.Exec, error) {
-	db, err := ConnectDB()
+// SetExecStatusDB activates or deactivates an exec account. Inactive execs cannot log in.
+func (db *DB) SetExecStatusDB(ctx context.Context, id int, inactive bool) error {
+	defer observe("SetExecStatusDB")()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return models.Exec{}, utils.ErrorHandler(err, "internal error")
+		return utils.ErrorHandler(err, "error updating exec status")
 	}
-	defer db.Close()
+	defer tx.Rollback()
 
+	exec, err := lockExec(ctx, tx, id)
+	if err != nil {
+		return utils.ErrorHandler(err, "Exec not found")
+	}
+	if exec.StatusInactive == inactive {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE execs SET status_inactive = ?, version = version + 1 WHERE id = ?", inactive, id); err != nil {
+		return utils.ErrorHandler(err, "error updating exec status")
+	}
+	before := execAudit(exec)
+	exec.StatusInactive = inactive
+	exec.Version++
+	if err := recordAudit(ctx, tx, auditUpdate, "exec", id, before, execAudit(exec)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return utils.ErrorHandler(err, "error updating exec status")
+	}
+	return nil
+}
+
+func (db *DB) GetUserByUsername(ctx context.Context, w http.ResponseWriter, req models.Exec) (models.Exec, error) {
+	defer observe("GetUserByUsername")()
 	var user models.Exec
 	query := `SELECT id, first_name, last_name, email, username, password, status_inactive, role FROM execs WHERE username = ?`
-	if err = db.QueryRow(query, req.Username).Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.Username, &user.Password, &user.StatusInactive, &user.Role); err != nil {
+	if err := db.QueryRowContext(ctx, query, req.Username).Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.Username, &user.Password, &user.StatusInactive, &user.Role); err != nil {
 		if err == sql.ErrNoRows {
 			return models.Exec{}, utils.ErrorHandler(err, "user does not exist")
 		}
@@ -251,21 +357,164 @@ func GetUserByUsername(w http.ResponseWriter, req models.Exec) (models.Exec, err
 	}
 
 	if user.StatusInactive {
-		return models.Exec{}, utils.ErrorHandler(err, "account is inactive")
+		return models.Exec{}, utils.ErrorHandler(errors.New("account is inactive"), "account is inactive")
+	}
+	return user, nil
+}
+
+// GetExecByOIDCDB maps an identity provider subject to an exec. The first time a subject is seen
+// it is linked to the exec with the same (verified) email.
+func (db *DB) GetExecByOIDCDB(ctx context.Context, subject, email string, emailVerified bool) (models.Exec, error) {
+	defer observe("GetExecByOIDCDB")()
+	var user models.Exec
+	query := `SELECT id, first_name, last_name, email, username, status_inactive, role FROM execs WHERE oidc_subject = ?`
+	err := db.QueryRowContext(ctx, query, subject).Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.Username, &user.StatusInactive, &user.Role)
+	if err == sql.ErrNoRows {
+		if email == "" || !emailVerified {
+			return models.Exec{}, utils.ErrorHandler(err, "no exec is linked to this identity")
+		}
+		query = `SELECT id, first_name, last_name, email, username, status_inactive, role FROM execs WHERE email = ? AND oidc_subject IS NULL`
+		if err = db.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.Username, &user.StatusInactive, &user.Role); err != nil {
+			if err == sql.ErrNoRows {
+				return models.Exec{}, utils.ErrorHandler(err, "no exec is linked to this identity")
+			}
+			return models.Exec{}, utils.ErrorHandler(err, "error retrieving data")
+		}
+		// a deactivated account must not get an identity linked to it
+		if user.StatusInactive {
+			return models.Exec{}, utils.ErrorHandler(errors.New("account is inactive"), "account is inactive")
+		}
+		if err = linkOIDCSubject(ctx, db, user.ID, subject); err != nil {
+			return models.Exec{}, utils.ErrorHandler(err, "error linking identity")
+		}
+	} else if err != nil {
+		return models.Exec{}, utils.ErrorHandler(err, "error retrieving data")
+	}
+
+	if user.StatusInactive {
+		return models.Exec{}, utils.ErrorHandler(errors.New("account is inactive"), "account is inactive")
 	}
 	return user, nil
 }
 
-func UpdatePasswordDB(id, currentPassword, updatedPassword string) (string, string, error) {
+// ErrLocalAccount is returned when a directory user's username is taken by an exec that wasn't
+// provisioned from that directory entry. Linking them would hand the account to the directory user.
+var ErrLocalAccount = errors.New("username belongs to an account that is not managed by the directory")
 
-	db, err := ConnectDB()
+// ProvisionExecDB creates the exec on first login through an external directory, or syncs its
+// details and role on later logins. The exec is found by directoryDN, the entry it was provisioned
+// from, never by username. Provisioned execs get an unusable random local password.
+func (db *DB) ProvisionExecDB(ctx context.Context, exec models.Exec, directoryDN, changedBy string) (models.Exec, error) {
+	defer observe("ProvisionExecDB")()
+	if directoryDN == "" {
+		return models.Exec{}, utils.ErrorHandler(errors.New("missing directory DN"), "error provisioning exec")
+	}
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return "", "", utils.ErrorHandler(err, "internal error")
+		return models.Exec{}, utils.ErrorHandler(err, "internal error")
+	}
+	defer tx.Rollback()
+
+	if err := roleExists(ctx, tx, exec.Role); err != nil {
+		return models.Exec{}, err
 	}
-	defer db.Close()
+
+	var existing models.Exec
+	err = tx.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, username, status_inactive, role, version FROM execs WHERE directory_dn = ? FOR UPDATE", directoryDN).Scan(&existing.ID, &existing.FirstName, &existing.LastName, &existing.Email, &existing.Username, &existing.StatusInactive, &existing.Role, &existing.Version)
+	if err == sql.ErrNoRows {
+		var taken int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM execs WHERE username = ? FOR UPDATE", exec.Username).Scan(&taken); err != nil {
+			return models.Exec{}, utils.ErrorHandler(err, "error retrieving data")
+		}
+		if taken > 0 {
+			utils.Logger(ctx).Warn("Refusing directory login for a username taken by another account", "username", exec.Username, "dn", directoryDN)
+			return models.Exec{}, ErrLocalAccount
+		}
+
+		randomPassword := make([]byte, 32)
+		if _, err := rand.Read(randomPassword); err != nil {
+			return models.Exec{}, utils.ErrorHandler(err, "internal error")
+		}
+		hashedPassword, err := utils.HashPassword(hex.EncodeToString(randomPassword))
+		if err != nil {
+			return models.Exec{}, err
+		}
+		res, err := tx.ExecContext(ctx, "INSERT INTO execs (first_name, last_name, email, username, password, user_created_at, role, directory_dn) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			exec.FirstName, exec.LastName, exec.Email, exec.Username, hashedPassword, time.Now().Format(time.RFC3339), exec.Role, directoryDN)
+		if err != nil {
+			return models.Exec{}, utils.ErrorHandler(err, "error provisioning exec")
+		}
+		lastId, err := res.LastInsertId()
+		if err != nil {
+			return models.Exec{}, utils.ErrorHandler(err, "error provisioning exec")
+		}
+		exec.ID = int(lastId)
+		if err := recordRoleChange(ctx, tx, exec.ID, "", exec.Role, changedBy); err != nil {
+			return models.Exec{}, err
+		}
+		exec.Version = 1
+		if err := recordAudit(ctx, tx, auditCreate, "exec", exec.ID, nil, execAudit(exec)); err != nil {
+			return models.Exec{}, err
+		}
+	} else if err != nil {
+		return models.Exec{}, utils.ErrorHandler(err, "error retrieving data")
+	} else {
+		if existing.StatusInactive {
+			return models.Exec{}, utils.ErrorHandler(errors.New("account is inactive"), "account is inactive")
+		}
+		synced, changed := syncDirectoryExec(existing, exec)
+		if !changed {
+			return existing, nil
+		}
+		if existing.Role != synced.Role {
+			if err := recordRoleChange(ctx, tx, existing.ID, existing.Role, synced.Role, changedBy); err != nil {
+				return models.Exec{}, err
+			}
+			// the session this login starts is created afterwards, with the new role
+			if err := revokeExecSessions(ctx, tx, existing.ID); err != nil {
+				return models.Exec{}, utils.ErrorHandler(err, "error revoking sessions")
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE execs SET first_name = ?, last_name = ?, email = ?, role = ?, version = version + 1 WHERE id = ?", synced.FirstName, synced.LastName, synced.Email, synced.Role, existing.ID); err != nil {
+			return models.Exec{}, utils.ErrorHandler(err, "error provisioning exec")
+		}
+		synced.Version++
+		if err := recordAudit(ctx, tx, auditUpdate, "exec", synced.ID, execAudit(existing), execAudit(synced)); err != nil {
+			return models.Exec{}, err
+		}
+		exec = synced
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.Exec{}, utils.ErrorHandler(err, "error provisioning exec")
+	}
+	return exec, nil
+}
+
+// syncDirectoryExec applies what the directory says about an exec, keeping local values the
+// directory doesn't provide. changed is false when the exec is already up to date.
+func syncDirectoryExec(existing, directory models.Exec) (models.Exec, bool) {
+	synced := existing
+	if directory.FirstName != "" {
+		synced.FirstName = directory.FirstName
+	}
+	if directory.LastName != "" {
+		synced.LastName = directory.LastName
+	}
+	if directory.Email != "" {
+		synced.Email = directory.Email
+	}
+	synced.Role = directory.Role
+	changed := synced.FirstName != existing.FirstName || synced.LastName != existing.LastName ||
+		synced.Email != existing.Email || synced.Role != existing.Role
+	return synced, changed
+}
+
+func (db *DB) UpdatePasswordDB(ctx context.Context, id, currentPassword, updatedPassword string) (string, string, error) {
+	defer observe("UpdatePasswordDB")()
 
 	var username, userpassword, userRole string
-	err = db.QueryRow("SELECT username, password, role FROM execs WHERE id = ?", id).Scan(&username, &userpassword, &userRole)
+	err := db.QueryRowContext(ctx, "SELECT username, password, role FROM execs WHERE id = ?", id).Scan(&username, &userpassword, &userRole)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return "", "", utils.ErrorHandler(err, "user not found")
@@ -283,9 +532,16 @@ func UpdatePasswordDB(id, currentPassword, updatedPassword string) (string, stri
 		return "", "", utils.ErrorHandler(err, "internal error")
 	}
 
+	execId, err := strconv.Atoi(id)
+	if err != nil {
+		return "", "", utils.ErrorHandler(err, "user not found")
+	}
 	currentTime := time.Now().Format(time.RFC3339)
 
-	_, err = db.Exec("UPDATE execs SET password = ?, password_changed_at = ? WHERE id = ?", hashedPassword, currentTime, id)
+	err = updateExecCredentials(ctx, db, execId, "UPDATE execs SET password = ?, password_changed_at = ?, version = version + 1 WHERE id = ?",
+		map[string]any{"password": userpassword},
+		map[string]any{"password": hashedPassword, "password_changed_at": currentTime},
+		hashedPassword, currentTime)
 	if err != nil {
 		return "", "", utils.ErrorHandler(err, "error updating password")
 	}
@@ -293,30 +549,20 @@ func UpdatePasswordDB(id, currentPassword, updatedPassword string) (string, stri
 	return username, userRole, nil
 }
 
-func ForgotPasswordDB(email string) error {
-	db, err := ConnectDB()
-	if err != nil {
-		return utils.ErrorHandler(err, "internal error")
-	}
-	defer db.Close()
-
+// ForgotPasswordDB mails a reset link, resetBaseURL followed by the token, valid for expiresIn
+func (db *DB) ForgotPasswordDB(ctx context.Context, email, resetBaseURL string, expiresIn time.Duration, mailer config.MailConfig) error {
+	defer observe("ForgotPasswordDB")()
 	var exec models.Exec
-	if err = db.QueryRow("SELECT id FROM execs WHERE email = ?", email).Scan(&exec.ID); err != nil {
+	if err := db.QueryRowContext(ctx, "SELECT id FROM execs WHERE email = ?", email).Scan(&exec.ID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return utils.ErrorHandler(err, "user not found")
 		}
 		return utils.ErrorHandler(err, "internal error")
 	}
 
-	resetJtwDuration, err := strconv.Atoi(os.Getenv("RESET_JWT_EXP_DURATION"))
-	if err != nil {
-		return utils.ErrorHandler(err, "failed to sent password reset email")
-	}
-
-	mins := time.Duration(resetJtwDuration)
-	expiry := time.Now().Add(mins * time.Minute).Format(time.RFC3339)
+	expiry := time.Now().Add(expiresIn).Format(time.RFC3339)
 	tokenBytes := make([]byte, 32)
-	if _, err = rand.Read(tokenBytes); err != nil {
+	if _, err := rand.Read(tokenBytes); err != nil {
 		return utils.ErrorHandler(err, "failed to sent password reset email")
 	}
 	// token is sent to the user (as part of link), whilst hasnedTokenString is stored in the DB.
@@ -327,37 +573,39 @@ func ForgotPasswordDB(email string) error {
 	hashedToken := sha256.Sum256(tokenBytes)
 	hashedTokenString := hex.EncodeToString(hashedToken[:])
 
-	if _, err = db.Exec("UPDATE execs SET password_reset_token = ?, password_token_expires = ? WHERE id = ?", hashedTokenString, expiry, exec.ID); err != nil {
+	err := updateExecCredentials(ctx, db, exec.ID, "UPDATE execs SET password_reset_token = ?, password_token_expires = ?, version = version + 1 WHERE id = ?",
+		nil,
+		map[string]any{"password_reset_token": hashedTokenString, "password_token_expires": expiry},
+		hashedTokenString, expiry)
+	if err != nil {
 		return utils.ErrorHandler(err, "failed to send password reset email")
 	}
 
 	// Send the reset email
-	host := os.Getenv("HOST_ADDRESS")
-	port := os.Getenv("API_PORT")
-	resetLinkDuration := os.Getenv("RESET_JWT_EXP_DURATION")
-	resetUrl := fmt.Sprintf("https://%s%s/execs/reset-password/reset/%s", host, port, token)
-	message := fmt.Sprintf("Forgot your password? Reset your password using the following link: \n %s \nIf you did request a password reset, please ignore this email. This link is only valid for %s minutes", resetUrl, resetLinkDuration)
+	resetUrl := resetBaseURL + token
+	message := fmt.Sprintf("Forgot your password? Reset your password using the following link: \n %s \nIf you did request a password reset, please ignore this email. This link is only valid for %d minutes", resetUrl, int(expiresIn.Minutes()))
 
 	m := mail.NewMessage()
-	m.SetHeader("From", "schooladmin@school.com")
+	m.SetHeader("From", mailer.From)
 	m.SetHeader("To", email)
 	m.SetHeader("Subject", "Your password reset link")
 	m.SetBody("text/plain", message)
+	// lets a bounce or a complaint about the mail be traced back to the request
+	if requestID := utils.RequestID(ctx); requestID != "" {
+		m.SetHeader("X-Request-ID", requestID)
+	}
 
-	dialer := mail.NewDialer(host, 1025, "", "")
+	dialer := mail.NewDialer(mailer.Host, mailer.Port, "", "")
 	if err = dialer.DialAndSend(m); err != nil {
-		return utils.ErrorHandler(err, "failed to sent password reset email")
+		utils.Logger(ctx).Error("Error sending password reset email", "mail_host", mailer.Host, "error", err)
+		return fmt.Errorf("failed to sent password reset email")
 	}
+	utils.Logger(ctx).Info("Sent password reset email", "mail_host", mailer.Host)
 	return nil
 }
 
-func ResetPasswordDB(token, newPassword string) error {
-	db, err := ConnectDB()
-	if err != nil {
-		return utils.ErrorHandler(err, "internal error")
-	}
-	defer db.Close()
-
+func (db *DB) ResetPasswordDB(ctx context.Context, token, newPassword string) error {
+	defer observe("ResetPasswordDB")()
 	var user models.Exec
 	bytes, err := hex.DecodeString(token)
 	if err != nil {
@@ -368,7 +616,7 @@ func ResetPasswordDB(token, newPassword string) error {
 	hashedTokenString := hex.EncodeToString(hashedToken[:])
 
 	query := `SELECT id, email FROM execs WHERE password_reset_token = ? AND password_token_expires > ?`
-	if err := db.QueryRow(query, hashedTokenString, time.Now().Format(time.RFC3339)).Scan(&user.ID, &user.Email); err != nil {
+	if err := db.QueryRowContext(ctx, query, hashedTokenString, time.Now().Format(time.RFC3339)).Scan(&user.ID, &user.Email); err != nil {
 		return utils.ErrorHandler(err, "invalid or expired reset token")
 	}
 
@@ -380,8 +628,67 @@ func ResetPasswordDB(token, newPassword string) error {
 	}
 	passwordChangeDate := time.Now().Format(time.RFC3339)
 
-	if _, err := db.Exec("UPDATE execs SET password = ?, password_reset_token = NULL, password_token_expires = NULL, password_changed_at = ? WHERE id = ?", hashedPassword, passwordChangeDate, user.ID); err != nil {
+	err = updateExecCredentials(ctx, db, user.ID, "UPDATE execs SET password = ?, password_reset_token = NULL, password_token_expires = NULL, password_changed_at = ?, version = version + 1 WHERE id = ?",
+		map[string]any{"password_reset_token": hashedTokenString},
+		map[string]any{"password": hashedPassword, "password_changed_at": passwordChangeDate},
+		hashedPassword, passwordChangeDate)
+	if err != nil {
 		return utils.ErrorHandler(err, "failed to change password")
 	}
 	return nil
 }
+
+// lockExec reads the exec a write is about to change, for the audit log
+func lockExec(ctx context.Context, tx *sql.Tx, id int) (models.Exec, error) {
+	var exec models.Exec
+	err := tx.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, username, status_inactive, role, version FROM execs WHERE id = ? FOR UPDATE", id).Scan(&exec.ID, &exec.FirstName, &exec.LastName, &exec.Email, &exec.Username, &exec.StatusInactive, &exec.Role, &exec.Version)
+	return exec, err
+}
+
+// execAudit is what the audit log records of an exec. The credential columns are left out,
+// writes to them are audited by updateExecCredentials.
+func execAudit(exec models.Exec) map[string]any {
+	return map[string]any{
+		"first_name":      exec.FirstName,
+		"last_name":       exec.LastName,
+		"email":           exec.Email,
+		"username":        exec.Username,
+		"role":            exec.Role,
+		"status_inactive": exec.StatusInactive,
+		"version":         exec.Version,
+	}
+}
+
+// updateExecCredentials runs a password or reset token update together with its audit log
+// entry. The values are redacted by recordAudit, before and after only say what changed.
+func updateExecCredentials(ctx context.Context, db *DB, id int, query string, before, after map[string]any, args ...any) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, query, append(args, id)...); err != nil {
+		return err
+	}
+	if err := recordAudit(ctx, tx, auditUpdate, "exec", id, before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func linkOIDCSubject(ctx context.Context, db *DB, id int, subject string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE execs SET oidc_subject = ?, version = version + 1 WHERE id = ?", subject, id); err != nil {
+		return err
+	}
+	if err := recordAudit(ctx, tx, auditUpdate, "exec", id, nil, map[string]any{"oidc_subject": subject}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
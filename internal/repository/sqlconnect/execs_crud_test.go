@@ -0,0 +1,46 @@
+package sqlconnect
+
+import (
+	"schoolapi/internal/models"
+	"testing"
+)
+
+func TestSyncDirectoryExec(t *testing.T) {
+	existing := models.Exec{ID: 7, FirstName: "Jane", LastName: "Doe", Email: "jane@district.org", Username: "jane", Role: "exec", Version: 3}
+	tests := []struct {
+		name        string
+		directory   models.Exec
+		want        models.Exec
+		wantChanged bool
+	}{
+		{
+			name:      "unchanged",
+			directory: models.Exec{FirstName: "Jane", LastName: "Doe", Email: "jane@district.org", Username: "jane", Role: "exec"},
+			want:      existing,
+		},
+		{
+			name:      "blank attributes keep local values",
+			directory: models.Exec{Username: "jane", Role: "exec"},
+			want:      existing,
+		},
+		{
+			name:        "role and email from the directory",
+			directory:   models.Exec{Email: "j.doe@district.org", Username: "jane", Role: "admin"},
+			want:        models.Exec{ID: 7, FirstName: "Jane", LastName: "Doe", Email: "j.doe@district.org", Username: "jane", Role: "admin", Version: 3},
+			wantChanged: true,
+		},
+		{
+			name:      "a renamed directory user keeps the linked username",
+			directory: models.Exec{Username: "jdoe", Role: "exec"},
+			want:      existing,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := syncDirectoryExec(existing, tt.directory)
+			if got != tt.want || changed != tt.wantChanged {
+				t.Errorf("syncDirectoryExec = %+v, %v; want %+v, %v", got, changed, tt.want, tt.wantChanged)
+			}
+		})
+	}
+}
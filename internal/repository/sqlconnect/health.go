@@ -0,0 +1,53 @@
+package sqlconnect
+
+import (
+	"context"
+	"embed"
+	"io/fs"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// PingDB actually dials, opening the pool doesn't tell whether the database is up
+func (db *DB) PingDB(ctx context.Context) error {
+	defer observe("PingDB")()
+	return db.PingContext(ctx)
+}
+
+// PendingMigrationsDB lists the migrations shipped with this build that the database
+// hasn't recorded in schema_migrations
+func (db *DB) PendingMigrationsDB(ctx context.Context) ([]string, error) {
+	defer observe("PendingMigrationsDB")()
+	rows, err := db.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[string]bool{}
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	files, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	pending := []string{}
+	for _, file := range files {
+		version := strings.TrimSuffix(strings.TrimPrefix(file, "migrations/"), ".sql")
+		if !applied[version] {
+			pending = append(pending, version)
+		}
+	}
+	return pending, nil
+}
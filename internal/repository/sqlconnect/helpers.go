@@ -2,12 +2,48 @@ package sqlconnect
 
 import (
 	"fmt"
+	"log/slog"
 	"net/http"
 	"reflect"
+	"sort"
 	"strings"
 )
 
-func addSorting(r *http.Request, query string) string {
+// StudentFilters, TeacherFilters and ExecFilters map the query params each list endpoint
+// filters and sorts on to their columns. The router derives the HPP whitelists from them.
+var (
+	StudentFilters = map[string]string{
+		"first_name": "first_name",
+		"last_name":  "last_name",
+		"email":      "email",
+		"class":      "class",
+	}
+	TeacherFilters = map[string]string{
+		"first_name": "first_name",
+		"last_name":  "last_name",
+		"email":      "email",
+		"subject":    "subject",
+	}
+	ExecFilters = map[string]string{
+		"first_name": "first_name",
+		"last_name":  "last_name",
+		"email":      "email",
+		"username":   "username",
+		"role":       "role",
+	}
+)
+
+// FilterParams lists the query params of a filter map, e.g. for a whitelist
+func FilterParams(filters map[string]string) []string {
+	params := make([]string, 0, len(filters))
+	for param := range filters {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	return params
+}
+
+func addSorting(r *http.Request, query string, filters map[string]string) string {
 	sortParams := r.URL.Query()["sort_by"] // NB! this approach for getting a slice of strings instead of one big string
 	if len(sortParams) > 0 {
 		var orderClauses []string
@@ -17,10 +53,11 @@ func addSorting(r *http.Request, query string) string {
 				continue
 			}
 			field, order := parts[0], parts[1]
-			if !isValidSortField(field) || !isValidSortOrder(order) {
+			dbField, ok := filters[field]
+			if !ok || !isValidSortOrder(order) {
 				continue
 			}
-			orderClauses = append(orderClauses, field+" "+order)
+			orderClauses = append(orderClauses, dbField+" "+order)
 		}
 		if len(orderClauses) > 0 {
 			query += " ORDER BY " + strings.Join(orderClauses, ", ")
@@ -33,30 +70,11 @@ func isValidSortOrder(order string) bool {
 	return order == "asc" || order == "desc"
 }
 
-func isValidSortField(field string) bool {
-	validFields := map[string]bool{
-		"first_name": true,
-		"last_name":  true,
-		"email":      true,
-		"class":      true,
-		"subject":    true,
-	}
-	return validFields[field]
-}
-
-func addFilters(r *http.Request, query string, args []any) (string, []any) {
-	params := map[string]string{
-		"first_name": "first_name",
-		"last_name":  "last_name",
-		"email":      "email",
-		"class":      "class",
-		"subject":    "subject",
-	}
-
-	for param, dbField := range params {
+func addFilters(r *http.Request, query string, args []any, filters map[string]string) (string, []any) {
+	for _, param := range FilterParams(filters) {
 		value := r.URL.Query().Get(param)
 		if value != "" {
-			query += " AND " + dbField + " = ?"
+			query += " AND " + filters[param] + " = ?"
 			args = append(args, value)
 		}
 	}
@@ -68,7 +86,6 @@ func generateInsertQuery(model any, intoTableName string) string {
 	var columns, placeholders string // placeholder means a questionmark in a the query string
 	for i := 0; i < modelType.NumField(); i++ {
 		dbTag := modelType.Field(i).Tag.Get("db")
-		fmt.Println("DB tag: ", dbTag)
 		dbTag = strings.TrimSuffix(dbTag, ",omitempty")
 		if dbTag != "" && dbTag != "id" {
 			if columns != "" {
@@ -79,7 +96,7 @@ func generateInsertQuery(model any, intoTableName string) string {
 			placeholders += "?"
 		}
 	}
-	fmt.Printf("INSERT INTO %s (%s) VALUES (%s)\n", intoTableName, columns, placeholders)
+	slog.Debug("Generated insert query", "table", intoTableName, "columns", columns)
 	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", intoTableName, columns, placeholders)
 }
 
@@ -93,6 +110,5 @@ func getStructValues(model any) []any {
 			values = append(values, modelValue.Field(i).Interface())
 		}
 	}
-	fmt.Println("Values:", values)
 	return values
 }
@@ -0,0 +1,219 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"regexp"
+	"schoolapi/internal/metrics"
+	"schoolapi/pkg/utils"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// mysqlConn is everything database/sql uses of a go-sql-driver connection
+type mysqlConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.QueryerContext
+	driver.ExecerContext
+	driver.Pinger
+	driver.SessionResetter
+	driver.Validator
+	driver.NamedValueChecker
+}
+
+type mysqlStmt interface {
+	driver.Stmt
+	driver.StmtExecContext
+	driver.StmtQueryContext
+	driver.NamedValueChecker
+}
+
+type mysqlRows interface {
+	driver.Rows
+	driver.RowsNextResultSet
+	driver.RowsColumnTypeScanType
+	driver.RowsColumnTypeDatabaseTypeName
+	driver.RowsColumnTypeNullable
+	driver.RowsColumnTypePrecisionScale
+}
+
+// instrumentedConnector times and traces every statement. The request context reaches it
+// through the *Context calls, so a slow query is logged with the id of the request that ran
+// it and its span sits under the request's. Statements taking slowQueryThreshold or longer are
+// logged, zero turns that off.
+type instrumentedConnector struct {
+	driver.Connector
+	slowQueryThreshold time.Duration
+}
+
+func (c instrumentedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedConn{mysqlConn: conn.(mysqlConn), slowQueryThreshold: c.slowQueryThreshold}, nil
+}
+
+type instrumentedConn struct {
+	mysqlConn
+	slowQueryThreshold time.Duration
+}
+
+func (c *instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := c.mysqlConn.QueryContext(ctx, query, args)
+	return observeRows(ctx, query, start, c.slowQueryThreshold, rows, err)
+}
+
+func (c *instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	result, err := c.mysqlConn.ExecContext(ctx, query, args)
+	observeExec(ctx, query, start, c.slowQueryThreshold, result, err)
+	return result, err
+}
+
+func (c *instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.mysqlConn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedStmt{mysqlStmt: stmt.(mysqlStmt), query: query, slowQueryThreshold: c.slowQueryThreshold}, nil
+}
+
+// statements with arguments are prepared first, so this is where most queries are timed
+type instrumentedStmt struct {
+	mysqlStmt
+	query              string
+	slowQueryThreshold time.Duration
+}
+
+func (s *instrumentedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := s.mysqlStmt.QueryContext(ctx, args)
+	return observeRows(ctx, s.query, start, s.slowQueryThreshold, rows, err)
+}
+
+func (s *instrumentedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	result, err := s.mysqlStmt.ExecContext(ctx, args)
+	observeExec(ctx, s.query, start, s.slowQueryThreshold, result, err)
+	return result, err
+}
+
+// observe records the latency of a repository function, call it as defer observe("GetStudentsDB")()
+func observe(function string) func() {
+	start := time.Now()
+	return func() {
+		metrics.QueryDuration.WithLabelValues(function).Observe(time.Since(start).Seconds())
+	}
+}
+
+// observeQuery logs the statement text only, the arguments may hold personal data
+func observeQuery(ctx context.Context, query string, start time.Time, slowQueryThreshold time.Duration) {
+	duration := time.Since(start)
+	if slowQueryThreshold > 0 && duration >= slowQueryThreshold {
+		utils.Logger(ctx).Warn("Slow query", "query", compactQuery(query), "duration_ms", duration.Milliseconds())
+	}
+}
+
+func observeExec(ctx context.Context, query string, start time.Time, slowQueryThreshold time.Duration, result driver.Result, err error) {
+	// ErrSkip only means database/sql retries the statement prepared
+	if err == driver.ErrSkip {
+		return
+	}
+	observeQuery(ctx, query, start, slowQueryThreshold)
+	span := startSpan(ctx, query, start)
+	if result != nil {
+		if affected, err := result.RowsAffected(); err == nil {
+			span.SetAttributes(attribute.Int64("db.rows_affected", affected))
+		}
+	}
+	endSpan(span, err)
+}
+
+// observeRows leaves the span open until the rows are closed, so it also covers reading them
+func observeRows(ctx context.Context, query string, start time.Time, slowQueryThreshold time.Duration, rows driver.Rows, err error) (driver.Rows, error) {
+	if err == driver.ErrSkip {
+		return nil, err
+	}
+	observeQuery(ctx, query, start, slowQueryThreshold)
+	span := startSpan(ctx, query, start)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &tracedRows{mysqlRows: rows.(mysqlRows), span: span}, nil
+}
+
+// tableName finds the first table a statement touches, good enough for a span name
+var tableName = regexp.MustCompile("(?i)\\b(?:from|into|update|join)\\s+`?(\\w+)")
+
+// startSpan is called once the driver answered, a statement it skips never gets a span.
+// Only the statement text is recorded, never the arguments.
+func startSpan(ctx context.Context, query string, start time.Time) trace.Span {
+	operation := strings.ToUpper(strings.SplitN(strings.TrimSpace(query), " ", 2)[0])
+	name := operation
+	attributes := []attribute.KeyValue{
+		attribute.String("db.system", "mysql"),
+		attribute.String("db.operation.name", operation),
+		attribute.String("db.query.text", compactQuery(query)),
+	}
+	if match := tableName.FindStringSubmatch(query); match != nil {
+		name += " " + match[1]
+		attributes = append(attributes, attribute.String("db.collection.name", match[1]))
+	}
+	_, span := otel.Tracer("schoolapi/sqlconnect").Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(start),
+		trace.WithAttributes(attributes...),
+	)
+	return span
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func compactQuery(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// tracedRows counts the rows read and ends the statement's span on Close
+type tracedRows struct {
+	mysqlRows
+	span  trace.Span
+	count int64
+	err   error
+}
+
+func (r *tracedRows) Next(dest []driver.Value) error {
+	err := r.mysqlRows.Next(dest)
+	if err == nil {
+		r.count++
+	} else if err != io.EOF {
+		r.err = err
+	}
+	return err
+}
+
+func (r *tracedRows) Close() error {
+	err := r.mysqlRows.Close()
+	r.span.SetAttributes(attribute.Int64("db.response.returned_rows", r.count))
+	if r.err == nil {
+		r.err = err
+	}
+	endSpan(r.span, r.err)
+	return err
+}
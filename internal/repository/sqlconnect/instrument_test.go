@@ -0,0 +1,234 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"schoolapi/internal/tracing"
+	"schoolapi/pkg/utils"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fakeConn answers every statement with rows or a result, without a MySQL server. The
+// embedded mysqlConn is nil, database/sql only calls what is overridden here.
+type fakeConn struct {
+	mysqlConn
+	rows [][]driver.Value
+	err  error
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &fakeRows{rows: c.rows}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+func (c *fakeConn) ResetSession(ctx context.Context) error   { return nil }
+func (c *fakeConn) IsValid() bool                            { return true }
+func (c *fakeConn) Close() error                             { return nil }
+
+type fakeRows struct {
+	mysqlRows
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string      { return make([]string, len(r.rows[0])) }
+func (r *fakeRows) Close() error           { return nil }
+func (r *fakeRows) HasNextResultSet() bool { return false }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+type fakeConnector struct{ conn *fakeConn }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return c.conn, nil }
+func (c fakeConnector) Driver() driver.Driver                        { return nil }
+
+func fakeDB(t *testing.T, conn *fakeConn) *DB {
+	pool := sql.OpenDB(instrumentedConnector{Connector: fakeConnector{conn}})
+	t.Cleanup(func() { pool.Close() })
+	return &DB{pool}
+}
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(recorder),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+	)
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	return recorder
+}
+
+// sqlSpans are the ended spans the sqlconnect tracer started
+func sqlSpans(recorder *tracetest.SpanRecorder) []sdktrace.ReadOnlySpan {
+	var spans []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.InstrumentationScope().Name == "schoolapi/sqlconnect" {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	values := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		values[kv.Key] = kv.Value
+	}
+	return values
+}
+
+func TestSQLSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	role := []driver.Value{int64(1), "admin", "Administrators", []byte(`["students:read"]`)}
+	db := fakeDB(t, &fakeConn{rows: [][]driver.Value{role}})
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	if _, err := db.RoleHasPermissionDB(ctx, "admin", "students:read"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RevokeSessionDB(ctx, 1, "session-1"); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	spans := sqlSpans(recorder)
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d SQL spans, want 2", len(spans))
+	}
+	tests := []struct {
+		name       string
+		attributes map[attribute.Key]attribute.Value
+	}{
+		{"SELECT roles", map[attribute.Key]attribute.Value{
+			"db.system":                 attribute.StringValue("mysql"),
+			"db.operation.name":         attribute.StringValue("SELECT"),
+			"db.collection.name":        attribute.StringValue("roles"),
+			"db.query.text":             attribute.StringValue("SELECT id, name, description, permissions FROM roles WHERE name = ?"),
+			"db.response.returned_rows": attribute.Int64Value(1),
+		}},
+		{"UPDATE exec_sessions", map[attribute.Key]attribute.Value{
+			"db.operation.name":  attribute.StringValue("UPDATE"),
+			"db.collection.name": attribute.StringValue("exec_sessions"),
+			"db.rows_affected":   attribute.Int64Value(1),
+		}},
+	}
+	for i, tt := range tests {
+		span := spans[i]
+		if span.Name() != tt.name {
+			t.Errorf("span %d name = %q, want %q", i, span.Name(), tt.name)
+			continue
+		}
+		if span.SpanKind() != trace.SpanKindClient {
+			t.Errorf("%q kind = %v, want %v", tt.name, span.SpanKind(), trace.SpanKindClient)
+		}
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() || span.SpanContext().TraceID() != parent.SpanContext().TraceID() {
+			t.Errorf("%q is not a child of the request's span", tt.name)
+		}
+		got := attributes(span)
+		for key, want := range tt.attributes {
+			if got[key] != want {
+				t.Errorf("%q %s = %v, want %v", tt.name, key, got[key].Emit(), want.Emit())
+			}
+		}
+		// the arguments may hold personal data
+		for _, value := range got {
+			if value.Emit() == "session-1" {
+				t.Errorf("%q records an argument: %v", tt.name, got)
+			}
+		}
+	}
+}
+
+func TestSQLSpanError(t *testing.T) {
+	recorder := recordSpans(t)
+	db := fakeDB(t, &fakeConn{err: errors.New("connection refused")})
+
+	if err := db.RevokeSessionDB(context.Background(), 1, "session-1"); err == nil {
+		t.Fatal("RevokeSessionDB succeeded against a failing connection")
+	}
+	spans := sqlSpans(recorder)
+	if len(spans) != 1 {
+		t.Fatalf("recorded %d SQL spans, want 1", len(spans))
+	}
+	if status := spans[0].Status(); status.Code != codes.Error || status.Description != "connection refused" {
+		t.Errorf("span status = %+v, want the driver's error", status)
+	}
+}
+
+// the statements a handler runs sit under its span, in the trace the caller started
+func TestSQLSpansInRequest(t *testing.T) {
+	recorder := recordSpans(t)
+	db := fakeDB(t, &fakeConn{})
+
+	route := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), utils.ContextKey("route"), "DELETE /execs/me/sessions/{sessionid}")))
+		})
+	}
+	h := utils.ApplyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := db.RevokeSessionDB(r.Context(), 1, "session-1"); err != nil {
+			t.Error(err)
+		}
+	}), tracing.Handler, tracing.Stage("jwt", func(next http.Handler) http.Handler { return next }), tracing.Server, route)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	r := httptest.NewRequest(http.MethodDelete, "/execs/me/sessions/session-1", nil)
+	r.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	var handler sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "handler DELETE /execs/me/sessions/{sessionid}" {
+			handler = span
+		}
+	}
+	if handler == nil {
+		t.Fatal("no handler span")
+	}
+	spans := sqlSpans(recorder)
+	if len(spans) != 1 {
+		t.Fatalf("recorded %d SQL spans, want 1", len(spans))
+	}
+	if got := spans[0].SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("SQL span trace id = %s, want the caller's %s", got, traceID)
+	}
+	if spans[0].Parent().SpanID() != handler.SpanContext().SpanID() {
+		t.Errorf("SQL span parent = %s, want the handler span %s", spans[0].Parent().SpanID(), handler.SpanContext().SpanID())
+	}
+}
@@ -0,0 +1,25 @@
+-- Roles define the valid values of execs.role and the permissions each role grants.
+-- A permission of "*" grants everything.
+CREATE TABLE IF NOT EXISTS roles (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(50) NOT NULL UNIQUE,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    permissions JSON NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT IGNORE INTO roles (name, description, permissions) VALUES
+    ('admin', 'Full access', JSON_ARRAY('*')),
+    ('manager', 'Manages teachers and students', JSON_ARRAY('teachers:read', 'students:read')),
+    ('exec', 'School executive', JSON_ARRAY('teachers:read', 'students:read'));
+
+-- Every role change of an exec is recorded here.
+CREATE TABLE IF NOT EXISTS role_audit (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    exec_id INT NOT NULL,
+    old_role VARCHAR(50) NOT NULL DEFAULT '',
+    new_role VARCHAR(50) NOT NULL,
+    changed_by VARCHAR(255) NOT NULL DEFAULT '',
+    changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_role_audit_exec_id (exec_id)
+);
@@ -0,0 +1,3 @@
+-- Links an exec to the subject identifier issued by the district identity provider.
+ALTER TABLE execs ADD COLUMN oidc_subject VARCHAR(255) NULL DEFAULT NULL;
+ALTER TABLE execs ADD UNIQUE INDEX idx_execs_oidc_subject (oidc_subject);
@@ -0,0 +1,2 @@
+-- Lets SCIM provisioning deactivate teachers the same way execs are deactivated.
+ALTER TABLE teachers ADD COLUMN status_inactive BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,14 @@
+-- Records the migrations applied to this database, /readyz reports any file missing from it.
+-- Every migration from now on ends by inserting its own version.
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version VARCHAR(255) PRIMARY KEY,
+    applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT IGNORE INTO schema_migrations (version) VALUES
+    ('0001_roles'),
+    ('0002_exec_sessions'),
+    ('0003_api_keys'),
+    ('0004_exec_oidc_subject'),
+    ('0005_teachers_status_inactive'),
+    ('0006_schema_migrations');
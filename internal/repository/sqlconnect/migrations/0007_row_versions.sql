@@ -0,0 +1,8 @@
+-- Optimistic concurrency: every write bumps version, and updates made through the API only
+-- apply when the client sends the version it last read.
+ALTER TABLE students ADD COLUMN version INT NOT NULL DEFAULT 1;
+ALTER TABLE teachers ADD COLUMN version INT NOT NULL DEFAULT 1;
+ALTER TABLE classes ADD COLUMN version INT NOT NULL DEFAULT 1;
+ALTER TABLE execs ADD COLUMN version INT NOT NULL DEFAULT 1;
+
+INSERT IGNORE INTO schema_migrations (version) VALUES ('0007_row_versions');
@@ -0,0 +1,17 @@
+-- Every create, update and delete made through the API, written in the same transaction as
+-- the change. changes holds only the fields that differ, as {"field": {"before": .., "after": ..}}.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    actor VARCHAR(255) NOT NULL DEFAULT '',
+    action VARCHAR(16) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    entity_id INT NOT NULL,
+    changes JSON NOT NULL,
+    request_id VARCHAR(64) NOT NULL DEFAULT '',
+    created_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+    INDEX idx_audit_log_entity (entity, entity_id),
+    INDEX idx_audit_log_actor (actor),
+    INDEX idx_audit_log_created_at (created_at)
+);
+
+INSERT IGNORE INTO schema_migrations (version) VALUES ('0008_audit_log');
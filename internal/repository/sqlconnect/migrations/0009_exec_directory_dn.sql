@@ -0,0 +1,6 @@
+-- The directory entry an exec was provisioned from (LDAP login). Directory logins match on it,
+-- never on username, so a directory user can't sign in as a local account with the same name.
+ALTER TABLE execs ADD COLUMN directory_dn VARCHAR(512) NULL DEFAULT NULL;
+ALTER TABLE execs ADD UNIQUE INDEX idx_execs_directory_dn (directory_dn);
+
+INSERT IGNORE INTO schema_migrations (version) VALUES ('0009_exec_directory_dn');
@@ -0,0 +1,5 @@
+-- Classes are only ever changed through their teacher, whose version covers the assignments,
+-- so nothing checked or bumped this one.
+ALTER TABLE classes DROP COLUMN version;
+
+INSERT IGNORE INTO schema_migrations (version) VALUES ('0010_drop_class_version');
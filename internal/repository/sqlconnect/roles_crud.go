@@ -0,0 +1,268 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"schoolapi/internal/models"
+	"schoolapi/pkg/utils"
+	"slices"
+)
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (db *DB) GetRolesDB(ctx context.Context) ([]models.Role, error) {
+	defer observe("GetRolesDB")()
+	rows, err := db.QueryContext(ctx, "SELECT id, name, description, permissions FROM roles ORDER BY id")
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "error retrieving roles")
+	}
+	defer rows.Close()
+
+	roles := []models.Role{}
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, utils.ErrorHandler(err, "error retrieving roles")
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, utils.ErrorHandler(err, "error retrieving roles")
+	}
+	return roles, nil
+}
+
+func (db *DB) GetRoleDB(ctx context.Context, id int) (models.Role, error) {
+	defer observe("GetRoleDB")()
+	role, err := scanRole(db.QueryRowContext(ctx, "SELECT id, name, description, permissions FROM roles WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return models.Role{}, utils.ErrorHandler(err, "Role not found")
+	} else if err != nil {
+		return models.Role{}, utils.ErrorHandler(err, "Database query error")
+	}
+	return role, nil
+}
+
+func (db *DB) AddRolesDB(ctx context.Context, newRoles []models.Role) ([]models.Role, error) {
+	defer observe("AddRolesDB")()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "error adding roles")
+	}
+	defer tx.Rollback()
+
+	addedRoles := make([]models.Role, len(newRoles))
+	for i, role := range newRoles {
+		if role.Name == "" {
+			return nil, utils.ErrorHandler(errors.New("blank role name"), "role name is required")
+		}
+		if role.Permissions == nil {
+			role.Permissions = []string{}
+		}
+		permissions, err := json.Marshal(role.Permissions)
+		if err != nil {
+			return nil, utils.ErrorHandler(err, "error adding roles")
+		}
+
+		res, err := tx.ExecContext(ctx, "INSERT INTO roles (name, description, permissions) VALUES (?, ?, ?)", role.Name, role.Description, permissions)
+		if err != nil {
+			return nil, utils.ErrorHandler(err, fmt.Sprintf("error adding role %s", role.Name))
+		}
+		lastId, err := res.LastInsertId()
+		if err != nil {
+			return nil, utils.ErrorHandler(err, "error getting last inserted ID")
+		}
+		role.ID = int(lastId)
+		if err := recordAudit(ctx, tx, auditCreate, "role", role.ID, nil, role); err != nil {
+			return nil, err
+		}
+		addedRoles[i] = role
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, utils.ErrorHandler(err, "error adding roles")
+	}
+	return addedRoles, nil
+}
+
+func (db *DB) PatchRoleDB(ctx context.Context, id int, updates map[string]any) (models.Role, error) {
+	defer observe("PatchRoleDB")()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Role{}, utils.ErrorHandler(err, "error updating role")
+	}
+	defer tx.Rollback()
+
+	existingRole, err := scanRole(tx.QueryRowContext(ctx, "SELECT id, name, description, permissions FROM roles WHERE id = ? FOR UPDATE", id))
+	if err == sql.ErrNoRows {
+		return models.Role{}, utils.ErrorHandler(err, "Role not found")
+	} else if err != nil {
+		return models.Role{}, utils.ErrorHandler(err, "Failed to retrieve role data")
+	}
+	before := existingRole
+
+	for k, v := range updates {
+		switch k {
+		case "name":
+			name, ok := v.(string)
+			if !ok || name == "" {
+				return models.Role{}, utils.ErrorHandler(fmt.Errorf("invalid role name %v", v), "invalid role name")
+			}
+			if name == existingRole.Name {
+				continue
+			}
+			// renaming a role that is in use would leave execs with an unknown role
+			var inUse int
+			if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM execs WHERE role = ?", existingRole.Name).Scan(&inUse); err != nil {
+				return models.Role{}, utils.ErrorHandler(err, "error updating role")
+			}
+			if inUse > 0 {
+				return models.Role{}, utils.ErrorHandler(errors.New("role in use"), "cannot rename a role that is assigned to execs")
+			}
+			existingRole.Name = name
+		case "description":
+			description, ok := v.(string)
+			if !ok {
+				return models.Role{}, utils.ErrorHandler(fmt.Errorf("invalid description %v", v), "invalid role description")
+			}
+			existingRole.Description = description
+		case "permissions":
+			list, ok := v.([]any)
+			if !ok {
+				return models.Role{}, utils.ErrorHandler(fmt.Errorf("invalid permissions %v", v), "permissions must be a list of strings")
+			}
+			permissions := make([]string, 0, len(list))
+			for _, p := range list {
+				permission, ok := p.(string)
+				if !ok {
+					return models.Role{}, utils.ErrorHandler(fmt.Errorf("invalid permission %v", p), "permissions must be a list of strings")
+				}
+				permissions = append(permissions, permission)
+			}
+			existingRole.Permissions = permissions
+		}
+	}
+
+	permissions, err := json.Marshal(existingRole.Permissions)
+	if err != nil {
+		return models.Role{}, utils.ErrorHandler(err, "error updating role")
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE roles SET name = ?, description = ?, permissions = ? WHERE id = ?", existingRole.Name, existingRole.Description, permissions, existingRole.ID); err != nil {
+		return models.Role{}, utils.ErrorHandler(err, "error updating role")
+	}
+	if err := recordAudit(ctx, tx, auditUpdate, "role", id, before, existingRole); err != nil {
+		return models.Role{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.Role{}, utils.ErrorHandler(err, "error updating role")
+	}
+	return existingRole, nil
+}
+
+func (db *DB) DeleteRoleDB(ctx context.Context, id int) error {
+	defer observe("DeleteRoleDB")()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return utils.ErrorHandler(err, "error deleting role")
+	}
+	defer tx.Rollback()
+
+	role, err := scanRole(tx.QueryRowContext(ctx, "SELECT id, name, description, permissions FROM roles WHERE id = ? FOR UPDATE", id))
+	if err == sql.ErrNoRows {
+		return utils.ErrorHandler(err, "Role not found")
+	} else if err != nil {
+		return utils.ErrorHandler(err, "error deleting role")
+	}
+
+	var inUse int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM execs WHERE role = ?", role.Name).Scan(&inUse); err != nil {
+		return utils.ErrorHandler(err, "error deleting role")
+	}
+	if inUse > 0 {
+		return utils.ErrorHandler(errors.New("role in use"), "cannot delete a role that is assigned to execs")
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM roles WHERE id = ?", id); err != nil {
+		return utils.ErrorHandler(err, "error deleting role")
+	}
+	if err := recordAudit(ctx, tx, auditDelete, "role", id, role, nil); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return utils.ErrorHandler(err, "error deleting role")
+	}
+	return nil
+}
+
+// RoleHasPermissionDB reports whether the named role grants the permission. A role holding "*" grants everything.
+func (db *DB) RoleHasPermissionDB(ctx context.Context, roleName, permission string) (bool, error) {
+	defer observe("RoleHasPermissionDB")()
+	role, err := scanRole(db.QueryRowContext(ctx, "SELECT id, name, description, permissions FROM roles WHERE name = ?", roleName))
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, utils.ErrorHandler(err, "internal error")
+	}
+	return slices.Contains(role.Permissions, "*") || slices.Contains(role.Permissions, permission), nil
+}
+
+func (db *DB) GetRoleChangesDB(ctx context.Context, execId int) ([]models.RoleChange, error) {
+	defer observe("GetRoleChangesDB")()
+	rows, err := db.QueryContext(ctx, "SELECT id, exec_id, old_role, new_role, changed_by, changed_at FROM role_audit WHERE exec_id = ? ORDER BY changed_at DESC, id DESC", execId)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "error retrieving role history")
+	}
+	defer rows.Close()
+
+	changes := []models.RoleChange{}
+	for rows.Next() {
+		var change models.RoleChange
+		if err := rows.Scan(&change.ID, &change.ExecID, &change.OldRole, &change.NewRole, &change.ChangedBy, &change.ChangedAt); err != nil {
+			return nil, utils.ErrorHandler(err, "error retrieving role history")
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, utils.ErrorHandler(err, "error retrieving role history")
+	}
+	return changes, nil
+}
+
+// roleExists returns an error unless the role is defined in the roles table
+func roleExists(ctx context.Context, q queryRower, name string) error {
+	var id int
+	err := q.QueryRowContext(ctx, "SELECT id FROM roles WHERE name = ?", name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return utils.ErrorHandler(err, fmt.Sprintf("unknown role: %s", name))
+	} else if err != nil {
+		return utils.ErrorHandler(err, "error validating role")
+	}
+	return nil
+}
+
+func recordRoleChange(ctx context.Context, tx *sql.Tx, execId int, oldRole, newRole, changedBy string) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO role_audit (exec_id, old_role, new_role, changed_by) VALUES (?, ?, ?, ?)", execId, oldRole, newRole, changedBy)
+	if err != nil {
+		return utils.ErrorHandler(err, "error recording role change")
+	}
+	return nil
+}
+
+func scanRole(row interface{ Scan(...any) error }) (models.Role, error) {
+	var role models.Role
+	var permissions []byte
+	if err := row.Scan(&role.ID, &role.Name, &role.Description, &permissions); err != nil {
+		return models.Role{}, err
+	}
+	if err := json.Unmarshal(permissions, &role.Permissions); err != nil {
+		return models.Role{}, err
+	}
+	return role, nil
+}
@@ -0,0 +1,439 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"schoolapi/internal/models"
+	"schoolapi/pkg/utils"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidSCIMFilter = errors.New("invalid filter")
+
+// SCIM attribute (lower-cased) to column, per table
+var scimExecColumns = map[string]string{
+	"username":        "e.username",
+	"emails":          "e.email",
+	"emails.value":    "e.email",
+	"name.givenname":  "e.first_name",
+	"name.familyname": "e.last_name",
+	"active":          "e.status_inactive",
+}
+
+var scimTeacherColumns = map[string]string{
+	"username":        "t.email",
+	"emails":          "t.email",
+	"emails.value":    "t.email",
+	"name.givenname":  "t.first_name",
+	"name.familyname": "t.last_name",
+	"active":          "t.status_inactive",
+	"urn:ietf:params:scim:schemas:extension:enterprise:2.0:user:department": "t.subject",
+}
+
+var scimGroupColumns = map[string]string{
+	"displayname": "r.name",
+}
+
+// ParseSCIMUserID splits ids like "exec-12" and "teacher-7"
+func ParseSCIMUserID(id string) (string, int, bool) {
+	userType, idStr, ok := strings.Cut(id, "-")
+	if !ok || (userType != "exec" && userType != "teacher") {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(idStr)
+	if err != nil {
+		return "", 0, false
+	}
+	return userType, n, true
+}
+
+func (db *DB) GetSCIMUsersDB(ctx context.Context, filters []models.SCIMFilter) ([]models.SCIMUser, error) {
+	defer observe("GetSCIMUsersDB")()
+	includeExecs, includeTeachers := true, true
+	var rest []models.SCIMFilter
+	for _, f := range filters {
+		if strings.ToLower(f.Attribute) != "usertype" {
+			rest = append(rest, f)
+			continue
+		}
+		userType, ok := f.Value.(string)
+		if f.Operator != "eq" || !ok {
+			return nil, fmt.Errorf("%w: userType only supports eq", ErrInvalidSCIMFilter)
+		}
+		includeExecs = includeExecs && strings.EqualFold(userType, "exec")
+		includeTeachers = includeTeachers && strings.EqualFold(userType, "teacher")
+	}
+
+	users := []models.SCIMUser{}
+	if includeExecs {
+		where, args, err := scimWhere(rest, scimExecColumns)
+		if err != nil {
+			return nil, err
+		}
+		execs, err := querySCIMExecs(ctx, db, where, args)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, execs...)
+	}
+	if includeTeachers {
+		where, args, err := scimWhere(rest, scimTeacherColumns)
+		if err != nil {
+			return nil, err
+		}
+		teachers, err := querySCIMTeachers(ctx, db, where, args)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, teachers...)
+	}
+	return users, nil
+}
+
+func (db *DB) GetSCIMUserDB(ctx context.Context, id string) (models.SCIMUser, error) {
+	defer observe("GetSCIMUserDB")()
+	userType, n, ok := ParseSCIMUserID(id)
+	if !ok {
+		return models.SCIMUser{}, utils.ErrorHandler(fmt.Errorf("invalid SCIM id %q", id), "User not found")
+	}
+
+	var users []models.SCIMUser
+	var err error
+	if userType == "exec" {
+		users, err = querySCIMExecs(ctx, db, " AND e.id = ?", []any{n})
+	} else {
+		users, err = querySCIMTeachers(ctx, db, " AND t.id = ?", []any{n})
+	}
+	if err != nil {
+		return models.SCIMUser{}, err
+	}
+	if len(users) == 0 {
+		return models.SCIMUser{}, utils.ErrorHandler(sql.ErrNoRows, "User not found")
+	}
+	return users[0], nil
+}
+
+// AddSCIMTeacherDB creates the teacher a SCIM client provisions, already inactive when the
+// client says so, in one statement
+func (db *DB) AddSCIMTeacherDB(ctx context.Context, teacher models.Teacher, inactive bool) (models.Teacher, error) {
+	defer observe("AddSCIMTeacherDB")()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Teacher{}, utils.ErrorHandler(err, "Error inserting data into DB")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "INSERT INTO teachers (first_name, last_name, email, subject, status_inactive) VALUES (?, ?, ?, ?, ?)",
+		teacher.FirstName, teacher.LastName, teacher.Email, teacher.Subject, inactive)
+	if err != nil {
+		return models.Teacher{}, utils.ErrorHandler(err, "Error inserting data into DB")
+	}
+	lastId, err := res.LastInsertId()
+	if err != nil {
+		return models.Teacher{}, utils.ErrorHandler(err, "Error getting last inserted ID")
+	}
+	teacher.ID = int(lastId)
+	teacher.Version = 1
+	if err := recordAudit(ctx, tx, auditCreate, "teacher", teacher.ID, nil, scimTeacherAudit(teacher, inactive)); err != nil {
+		return models.Teacher{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.Teacher{}, utils.ErrorHandler(err, "Error inserting data into DB")
+	}
+	return teacher, nil
+}
+
+// SaveSCIMExecDB writes a SCIM user back to its exec in one transaction: the attributes, the
+// active flag and, when the exec ends up inactive, revoking its sessions
+func (db *DB) SaveSCIMExecDB(ctx context.Context, exec models.Exec) error {
+	defer observe("SaveSCIMExecDB")()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return utils.ErrorHandler(err, "error updating exec")
+	}
+	defer tx.Rollback()
+
+	existing, err := lockExec(ctx, tx, exec.ID)
+	if err == sql.ErrNoRows {
+		return utils.ErrorHandler(err, "Exec data not found")
+	} else if err != nil {
+		return utils.ErrorHandler(err, "Failed to retrieve exec data")
+	}
+
+	updated := existing
+	updated.FirstName, updated.LastName, updated.Email, updated.Username = exec.FirstName, exec.LastName, exec.Email, exec.Username
+	updated.StatusInactive = exec.StatusInactive
+	if updated != existing {
+		if _, err := tx.ExecContext(ctx, "UPDATE execs SET first_name = ?, last_name = ?, email = ?, username = ?, status_inactive = ?, version = version + 1 WHERE id = ?",
+			updated.FirstName, updated.LastName, updated.Email, updated.Username, updated.StatusInactive, updated.ID); err != nil {
+			return utils.ErrorHandler(err, "error updating exec")
+		}
+		updated.Version++
+		if err := recordAudit(ctx, tx, auditUpdate, "exec", updated.ID, execAudit(existing), execAudit(updated)); err != nil {
+			return err
+		}
+	}
+	if updated.StatusInactive {
+		if err := revokeExecSessions(ctx, tx, updated.ID); err != nil {
+			return utils.ErrorHandler(err, "error revoking sessions")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return utils.ErrorHandler(err, "error updating exec")
+	}
+	return nil
+}
+
+// SaveSCIMTeacherDB writes a SCIM user back to its teacher, attributes and active flag in one
+// transaction
+func (db *DB) SaveSCIMTeacherDB(ctx context.Context, teacher models.Teacher, inactive bool) error {
+	defer observe("SaveSCIMTeacherDB")()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error updating teacher")
+	}
+	defer tx.Rollback()
+
+	var existing models.Teacher
+	var wasInactive bool
+	err = tx.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, subject, status_inactive, version FROM teachers WHERE id = ? FOR UPDATE", teacher.ID).Scan(&existing.ID, &existing.FirstName, &existing.LastName, &existing.Email, &existing.Subject, &wasInactive, &existing.Version)
+	if err == sql.ErrNoRows {
+		return utils.ErrorHandler(err, "Teacher data not found")
+	} else if err != nil {
+		return utils.ErrorHandler(err, "Failed to retrieve teacher data")
+	}
+
+	before := scimTeacherAudit(existing, wasInactive)
+	existing.FirstName, existing.LastName, existing.Email, existing.Subject = teacher.FirstName, teacher.LastName, teacher.Email, teacher.Subject
+	after := scimTeacherAudit(existing, inactive)
+	changes, err := auditChanges(before, after)
+	if err != nil {
+		return utils.ErrorHandler(err, "Error updating teacher")
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE teachers SET first_name = ?, last_name = ?, email = ?, subject = ?, status_inactive = ?, version = version + 1 WHERE id = ?",
+		existing.FirstName, existing.LastName, existing.Email, existing.Subject, inactive, existing.ID); err != nil {
+		return utils.ErrorHandler(err, "Error updating teacher")
+	}
+	after["version"] = existing.Version + 1
+	if err := recordAudit(ctx, tx, auditUpdate, "teacher", existing.ID, before, after); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return utils.ErrorHandler(err, "Error updating teacher")
+	}
+	return nil
+}
+
+func scimTeacherAudit(teacher models.Teacher, inactive bool) map[string]any {
+	return map[string]any{
+		"first_name":      teacher.FirstName,
+		"last_name":       teacher.LastName,
+		"email":           teacher.Email,
+		"subject":         teacher.Subject,
+		"status_inactive": inactive,
+		"version":         teacher.Version,
+	}
+}
+
+func (db *DB) GetSCIMGroupsDB(ctx context.Context, filters []models.SCIMFilter) ([]models.SCIMGroup, error) {
+	defer observe("GetSCIMGroupsDB")()
+	where, args, err := scimWhere(filters, scimGroupColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	return querySCIMGroups(ctx, db, where, args)
+}
+
+func (db *DB) GetSCIMGroupDB(ctx context.Context, id int) (models.SCIMGroup, error) {
+	defer observe("GetSCIMGroupDB")()
+	groups, err := querySCIMGroups(ctx, db, " AND r.id = ?", []any{id})
+	if err != nil {
+		return models.SCIMGroup{}, err
+	}
+	if len(groups) == 0 {
+		return models.SCIMGroup{}, utils.ErrorHandler(sql.ErrNoRows, "Group not found")
+	}
+	return groups[0], nil
+}
+
+func querySCIMExecs(ctx context.Context, db *DB, where string, args []any) ([]models.SCIMUser, error) {
+	query := `SELECT e.id, e.first_name, e.last_name, e.email, e.username, e.status_inactive, e.user_created_at, e.role, COALESCE(r.id, 0)
+				FROM execs e LEFT JOIN roles r ON r.name = e.role
+				WHERE 1=1` + where + ` ORDER BY e.id`
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "error retrieving users")
+	}
+	defer rows.Close()
+
+	users := []models.SCIMUser{}
+	for rows.Next() {
+		var exec models.Exec
+		var roleId int
+		if err := rows.Scan(&exec.ID, &exec.FirstName, &exec.LastName, &exec.Email, &exec.Username, &exec.StatusInactive, &exec.UserCreatedAt, &exec.Role, &roleId); err != nil {
+			return nil, utils.ErrorHandler(err, "error retrieving users")
+		}
+		active := !exec.StatusInactive
+		user := models.SCIMUser{
+			Schemas:  []string{models.SCIMUserSchema},
+			ID:       fmt.Sprintf("exec-%d", exec.ID),
+			UserName: exec.Username,
+			UserType: "exec",
+			Name:     models.SCIMName{GivenName: exec.FirstName, FamilyName: exec.LastName},
+			Active:   &active,
+			Meta:     &models.SCIMMeta{ResourceType: "User", Created: exec.UserCreatedAt.String},
+		}
+		if exec.Email != "" {
+			user.Emails = []models.SCIMEmail{{Value: exec.Email, Type: "work", Primary: true}}
+		}
+		if roleId != 0 {
+			user.Groups = []models.SCIMMember{{Value: strconv.Itoa(roleId), Display: exec.Role}}
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, utils.ErrorHandler(err, "error retrieving users")
+	}
+	return users, nil
+}
+
+func querySCIMTeachers(ctx context.Context, db *DB, where string, args []any) ([]models.SCIMUser, error) {
+	query := `SELECT t.id, t.first_name, t.last_name, t.email, t.subject, t.status_inactive FROM teachers t WHERE 1=1` + where + ` ORDER BY t.id`
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "error retrieving users")
+	}
+	defer rows.Close()
+
+	users := []models.SCIMUser{}
+	for rows.Next() {
+		var teacher models.Teacher
+		var inactive bool
+		if err := rows.Scan(&teacher.ID, &teacher.FirstName, &teacher.LastName, &teacher.Email, &teacher.Subject, &inactive); err != nil {
+			return nil, utils.ErrorHandler(err, "error retrieving users")
+		}
+		active := !inactive
+		users = append(users, models.SCIMUser{
+			Schemas:    []string{models.SCIMUserSchema, models.SCIMEnterpriseUserSchema},
+			ID:         fmt.Sprintf("teacher-%d", teacher.ID),
+			UserName:   teacher.Email,
+			UserType:   "teacher",
+			Name:       models.SCIMName{GivenName: teacher.FirstName, FamilyName: teacher.LastName},
+			Emails:     []models.SCIMEmail{{Value: teacher.Email, Type: "work", Primary: true}},
+			Active:     &active,
+			Enterprise: &models.SCIMEnterprise{Department: teacher.Subject},
+			Meta:       &models.SCIMMeta{ResourceType: "User"},
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, utils.ErrorHandler(err, "error retrieving users")
+	}
+	return users, nil
+}
+
+func querySCIMGroups(ctx context.Context, db *DB, where string, args []any) ([]models.SCIMGroup, error) {
+	query := `SELECT r.id, r.name, r.created_at, COALESCE(e.id, 0), COALESCE(e.username, '')
+				FROM roles r LEFT JOIN execs e ON e.role = r.name
+				WHERE 1=1` + where + ` ORDER BY r.id, e.id`
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "error retrieving groups")
+	}
+	defer rows.Close()
+
+	groups := []models.SCIMGroup{}
+	for rows.Next() {
+		var roleId, execId int
+		var name, createdAt, username string
+		if err := rows.Scan(&roleId, &name, &createdAt, &execId, &username); err != nil {
+			return nil, utils.ErrorHandler(err, "error retrieving groups")
+		}
+		id := strconv.Itoa(roleId)
+		if len(groups) == 0 || groups[len(groups)-1].ID != id {
+			groups = append(groups, models.SCIMGroup{
+				Schemas:     []string{models.SCIMGroupSchema},
+				ID:          id,
+				DisplayName: name,
+				Members:     []models.SCIMMember{},
+				Meta:        &models.SCIMMeta{ResourceType: "Group", Created: createdAt},
+			})
+		}
+		if execId != 0 {
+			group := &groups[len(groups)-1]
+			group.Members = append(group.Members, models.SCIMMember{Value: fmt.Sprintf("exec-%d", execId), Display: username})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, utils.ErrorHandler(err, "error retrieving groups")
+	}
+	return groups, nil
+}
+
+// scimWhere turns filter comparisons into " AND ..." clauses using the table's column map
+func scimWhere(filters []models.SCIMFilter, columns map[string]string) (string, []any, error) {
+	var sb strings.Builder
+	var args []any
+	for _, f := range filters {
+		attr := strings.ToLower(f.Attribute)
+		column, ok := columns[attr]
+		if !ok {
+			return "", nil, fmt.Errorf("%w: unsupported attribute %s", ErrInvalidSCIMFilter, f.Attribute)
+		}
+
+		if attr == "active" {
+			active, ok := f.Value.(bool)
+			if !ok || (f.Operator != "eq" && f.Operator != "ne") {
+				return "", nil, fmt.Errorf("%w: active only supports eq/ne with a boolean", ErrInvalidSCIMFilter)
+			}
+			if f.Operator == "ne" {
+				active = !active
+			}
+			sb.WriteString(" AND " + column + " = ?")
+			args = append(args, !active)
+			continue
+		}
+
+		if f.Operator == "pr" {
+			sb.WriteString(" AND (" + column + " IS NOT NULL AND " + column + " <> '')")
+			continue
+		}
+
+		value, ok := f.Value.(string)
+		if !ok {
+			return "", nil, fmt.Errorf("%w: %s expects a string", ErrInvalidSCIMFilter, f.Attribute)
+		}
+		switch f.Operator {
+		case "eq":
+			sb.WriteString(" AND " + column + " = ?")
+			args = append(args, value)
+		case "ne":
+			sb.WriteString(" AND " + column + " <> ?")
+			args = append(args, value)
+		case "co":
+			sb.WriteString(" AND " + column + " LIKE ?")
+			args = append(args, "%"+escapeLike(value)+"%")
+		case "sw":
+			sb.WriteString(" AND " + column + " LIKE ?")
+			args = append(args, escapeLike(value)+"%")
+		case "ew":
+			sb.WriteString(" AND " + column + " LIKE ?")
+			args = append(args, "%"+escapeLike(value))
+		default:
+			return "", nil, fmt.Errorf("%w: unsupported operator %s", ErrInvalidSCIMFilter, f.Operator)
+		}
+	}
+	return sb.String(), args, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
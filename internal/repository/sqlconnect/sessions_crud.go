@@ -0,0 +1,193 @@
+package sqlconnect
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"schoolapi/internal/models"
+	"schoolapi/pkg/utils"
+	"time"
+	"unicode/utf8"
+)
+
+// CreateSessionDB records a newly issued token and returns the session id to embed in it
+func (db *DB) CreateSessionDB(ctx context.Context, execId int, ip, userAgent string) (string, error) {
+	defer observe("CreateSessionDB")()
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", utils.ErrorHandler(err, "internal error")
+	}
+	sessionId := hex.EncodeToString(idBytes)
+
+	if _, err := db.ExecContext(ctx, "INSERT INTO exec_sessions (id, exec_id, ip, user_agent) VALUES (?, ?, ?, ?)", sessionId, execId, ip, truncate(userAgent, 512)); err != nil {
+		return "", utils.ErrorHandler(err, "error creating session")
+	}
+	return sessionId, nil
+}
+
+// sessionTouchInterval is how stale last_seen_at gets before a request writes it again, so
+// reads don't each cost a write
+const sessionTouchInterval = time.Minute
+
+// TouchSessionDB returns an error unless the session exists and has not been revoked. It also bumps
+// last_seen_at, at most once per sessionTouchInterval.
+func (db *DB) TouchSessionDB(ctx context.Context, sessionId string) error {
+	defer observe("TouchSessionDB")()
+	var revokedAt sql.NullString
+	var stale bool
+	err := db.QueryRowContext(ctx, "SELECT revoked_at, last_seen_at < CURRENT_TIMESTAMP - INTERVAL ? SECOND FROM exec_sessions WHERE id = ?", int(sessionTouchInterval.Seconds()), sessionId).Scan(&revokedAt, &stale)
+	if err == sql.ErrNoRows {
+		return utils.ErrorHandler(err, "session not found")
+	} else if err != nil {
+		return utils.ErrorHandler(err, "internal error")
+	}
+	if revokedAt.Valid {
+		return utils.ErrorHandler(errors.New("session revoked"), "session has been revoked")
+	}
+
+	if !stale {
+		return nil
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE exec_sessions SET last_seen_at = CURRENT_TIMESTAMP WHERE id = ?", sessionId); err != nil {
+		return utils.ErrorHandler(err, "internal error")
+	}
+	return nil
+}
+
+func (db *DB) GetSessionsDB(ctx context.Context, execId int) ([]models.Session, error) {
+	defer observe("GetSessionsDB")()
+	rows, err := db.QueryContext(ctx, "SELECT id, exec_id, ip, user_agent, created_at, last_seen_at, revoked_at FROM exec_sessions WHERE exec_id = ? ORDER BY created_at DESC", execId)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "error retrieving sessions")
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var session models.Session
+		if err := rows.Scan(&session.ID, &session.ExecID, &session.IP, &session.UserAgent, &session.CreatedAt, &session.LastSeenAt, &session.RevokedAt); err != nil {
+			return nil, utils.ErrorHandler(err, "error retrieving sessions")
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, utils.ErrorHandler(err, "error retrieving sessions")
+	}
+	return sessions, nil
+}
+
+// RevokeSessionDB revokes one session belonging to the exec
+func (db *DB) RevokeSessionDB(ctx context.Context, execId int, sessionId string) error {
+	defer observe("RevokeSessionDB")()
+	result, err := db.ExecContext(ctx, "UPDATE exec_sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND exec_id = ? AND revoked_at IS NULL", sessionId, execId)
+	if err != nil {
+		return utils.ErrorHandler(err, "error revoking session")
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return utils.ErrorHandler(err, "error revoking session")
+	}
+	if n == 0 {
+		return utils.ErrorHandler(errors.New("no rows affected"), "Session not found")
+	}
+	return nil
+}
+
+// RevokeExecSessionsDB revokes every active session of the exec and returns their ids
+func (db *DB) RevokeExecSessionsDB(ctx context.Context, execId int) ([]string, error) {
+	defer observe("RevokeExecSessionsDB")()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "error revoking sessions")
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM exec_sessions WHERE exec_id = ? AND revoked_at IS NULL FOR UPDATE", execId)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "error revoking sessions")
+	}
+	defer rows.Close()
+	sessionIds := []string{}
+	for rows.Next() {
+		var sessionId string
+		if err := rows.Scan(&sessionId); err != nil {
+			return nil, utils.ErrorHandler(err, "error revoking sessions")
+		}
+		sessionIds = append(sessionIds, sessionId)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, utils.ErrorHandler(err, "error revoking sessions")
+	}
+
+	if err := revokeExecSessions(ctx, tx, execId); err != nil {
+		return nil, utils.ErrorHandler(err, "error revoking sessions")
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, utils.ErrorHandler(err, "error revoking sessions")
+	}
+	return sessionIds, nil
+}
+
+// execer is a *sql.DB or a *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func revokeExecSessions(ctx context.Context, db execer, execId int) error {
+	_, err := db.ExecContext(ctx, "UPDATE exec_sessions SET revoked_at = CURRENT_TIMESTAMP WHERE exec_id = ? AND revoked_at IS NULL", execId)
+	return err
+}
+
+func (db *DB) RecordLoginAttemptDB(ctx context.Context, username, ip, userAgent string, success bool, reason string) error {
+	defer observe("RecordLoginAttemptDB")()
+	if _, err := db.ExecContext(ctx, "INSERT INTO login_attempts (username, ip, user_agent, success, reason) VALUES (?, ?, ?, ?, ?)", truncate(username, 255), ip, truncate(userAgent, 512), success, reason); err != nil {
+		return utils.ErrorHandler(err, "error recording login attempt")
+	}
+	return nil
+}
+
+func (db *DB) GetLoginAttemptsDB(ctx context.Context, execId int) ([]models.LoginAttempt, error) {
+	defer observe("GetLoginAttemptsDB")()
+	query := `SELECT la.id, la.username, la.ip, la.user_agent, la.success, la.reason, la.attempted_at
+				FROM login_attempts la
+				JOIN execs e ON e.username = la.username
+				WHERE e.id = ?
+				ORDER BY la.attempted_at DESC, la.id DESC
+				LIMIT 100`
+
+	rows, err := db.QueryContext(ctx, query, execId)
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "error retrieving login attempts")
+	}
+	defer rows.Close()
+
+	attempts := []models.LoginAttempt{}
+	for rows.Next() {
+		var attempt models.LoginAttempt
+		if err := rows.Scan(&attempt.ID, &attempt.Username, &attempt.IP, &attempt.UserAgent, &attempt.Success, &attempt.Reason, &attempt.AttemptedAt); err != nil {
+			return nil, utils.ErrorHandler(err, "error retrieving login attempts")
+		}
+		attempts = append(attempts, attempt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, utils.ErrorHandler(err, "error retrieving login attempts")
+	}
+	return attempts, nil
+}
+
+// truncate cuts s to max characters, which is what VARCHAR lengths count, never inside one
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	runes := 0
+	for i := range s {
+		if runes == max {
+			return s[:i]
+		}
+		runes++
+	}
+	return s
+}
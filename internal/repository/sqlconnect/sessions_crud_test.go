@@ -0,0 +1,30 @@
+package sqlconnect
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"Mozilla/5.0", 7, "Mozilla"},
+		{"héllo wörld", 7, "héllo w"},
+		{"日本語のブラウザ", 3, "日本語"},
+		{"", 3, ""},
+	}
+	for _, tt := range tests {
+		got := truncate(tt.in, tt.max)
+		if got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.in, tt.max, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncate(%q, %d) = %q is not valid UTF-8", tt.in, tt.max, got)
+		}
+	}
+}
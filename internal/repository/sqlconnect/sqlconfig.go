@@ -2,24 +2,29 @@ package sqlconnect
 
 import (
 	"database/sql"
-	"fmt"
-	"os"
+	"schoolapi/internal/config"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
-func ConnectDB() (*sql.DB, error) {
-	user := os.Getenv("DB_USER")
-	password := os.Getenv("DB_PASSWORD")
-	host := os.Getenv("HOST_IP")
-	dbport := os.Getenv("DB_PORT")
-	dbname := os.Getenv("DB_NAME")
-
-	connectionString := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", user, password, host, dbport, dbname)
+// DB is the connection pool every query shares, the repository functions are its methods
+type DB struct {
+	*sql.DB
+}
 
-	db, err := sql.Open("mysql", connectionString)
+// Open opens the pool main hands to the handlers. Connections are made on first use.
+func Open(cfg config.DatabaseConfig) (*DB, error) {
+	mysqlConfig, err := mysql.ParseDSN(cfg.DSN())
+	if err != nil {
+		return nil, err
+	}
+	connector, err := mysql.NewConnector(mysqlConfig)
 	if err != nil {
 		return nil, err
 	}
-	return db, nil
+	pool := sql.OpenDB(instrumentedConnector{Connector: connector, slowQueryThreshold: cfg.SlowQueryThreshold})
+	pool.SetMaxOpenConns(cfg.MaxOpenConns)
+	pool.SetMaxIdleConns(cfg.MaxIdleConns)
+	pool.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	return &DB{pool}, nil
 }
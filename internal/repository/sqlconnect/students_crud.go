@@ -1,9 +1,10 @@
 package sqlconnect
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"reflect"
 	"schoolapi/internal/models"
@@ -11,14 +12,10 @@ import (
 	"strconv"
 )
 
-func GetStudentDB(id int) (models.Student, error) {
-	db, err := ConnectDB()
-	if err != nil {
-		return models.Student{}, utils.ErrorHandler(err, "Error connecting to DB")
-	}
-	defer db.Close()
+func (db *DB) GetStudentDB(ctx context.Context, id int) (models.Student, error) {
+	defer observe("GetStudentDB")()
 	var student models.Student
-	err = db.QueryRow("SELECT id, first_name, last_name, email, class FROM students WHERE id = ?", id).Scan(&student.ID, &student.FirstName, &student.LastName, &student.Email, &student.Class)
+	err := db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, class, version FROM students WHERE id = ?", id).Scan(&student.ID, &student.FirstName, &student.LastName, &student.Email, &student.Class, &student.Version)
 	if err == sql.ErrNoRows {
 		return models.Student{}, utils.ErrorHandler(err, "Student not found")
 	} else if err != nil {
@@ -27,24 +24,20 @@ func GetStudentDB(id int) (models.Student, error) {
 	return student, nil
 }
 
-func GetStudentsDB(students []models.Student, r *http.Request, limit, page int) ([]models.Student, int, error) {
-	query := "SELECT id, first_name, last_name, email, class FROM students WHERE 1=1"
+func (db *DB) GetStudentsDB(students []models.Student, r *http.Request, limit, page int) ([]models.Student, int, error) {
+	defer observe("GetStudentsDB")()
+	ctx := r.Context()
+	query := "SELECT id, first_name, last_name, email, class, version FROM students WHERE 1=1"
 	var args []any
 
-	query, args = addFilters(r, query, args)
-	query = addSorting(r, query)
+	query, args = addFilters(r, query, args, StudentFilters)
+	query = addSorting(r, query, StudentFilters)
 
 	offset := (page - 1) * limit
 	query += " LIMIT ? OFFSET ?"
 	args = append(args, limit, offset)
 
-	db, err := ConnectDB()
-	if err != nil {
-		return nil, 0, utils.ErrorHandler(err, "Error connecting to DB")
-	}
-	defer db.Close()
-
-	rows, err := db.Query(query, args...)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, 0, utils.ErrorHandler(err, "internal error")
 	}
@@ -52,7 +45,7 @@ func GetStudentsDB(students []models.Student, r *http.Request, limit, page int)
 
 	for rows.Next() {
 		var student models.Student
-		err := rows.Scan(&student.ID, &student.FirstName, &student.LastName, &student.Email, &student.Class)
+		err := rows.Scan(&student.ID, &student.FirstName, &student.LastName, &student.Email, &student.Class, &student.Version)
 		if err != nil {
 			return nil, 0, utils.ErrorHandler(err, "internal error")
 		}
@@ -60,21 +53,22 @@ func GetStudentsDB(students []models.Student, r *http.Request, limit, page int)
 	}
 	var totalCount int
 	countQuery := "SELECT COUNT(DISTINCT id) FROM students"
-	if err = db.QueryRow(countQuery).Scan(&totalCount); err != nil {
+	if err = db.QueryRowContext(ctx, countQuery).Scan(&totalCount); err != nil {
 		return nil, 0, utils.ErrorHandler(err, "internal error")
 	}
 
 	return students, totalCount, nil
 }
 
-func AddStudentsDB(newStudents []models.Student) ([]models.Student, error) {
-	db, err := ConnectDB()
+func (db *DB) AddStudentsDB(ctx context.Context, newStudents []models.Student) ([]models.Student, error) {
+	defer observe("AddStudentsDB")()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "Error connecting to DB")
+		return nil, utils.ErrorHandler(err, "Error inserting data into DB")
 	}
-	defer db.Close()
+	defer tx.Rollback()
 
-	stmt, err := db.Prepare(generateInsertQuery(models.Student{}, "students"))
+	stmt, err := tx.PrepareContext(ctx, generateInsertQuery(models.Student{}, "students"))
 	if err != nil {
 		return nil, utils.ErrorHandler(err, "Error preparing SQL statement")
 	}
@@ -83,7 +77,7 @@ func AddStudentsDB(newStudents []models.Student) ([]models.Student, error) {
 	addedStudents := make([]models.Student, len(newStudents))
 	for i, t := range newStudents {
 		values := getStructValues(t)
-		res, err := stmt.Exec(values...)
+		res, err := stmt.ExecContext(ctx, values...)
 		if err != nil {
 			return nil, utils.ErrorHandler(err, "Error inserting data into DB")
 		}
@@ -92,50 +86,80 @@ func AddStudentsDB(newStudents []models.Student) ([]models.Student, error) {
 			return nil, utils.ErrorHandler(err, "Error getting last inserted ID")
 		}
 		t.ID = int(lastId)
+		t.Version = 1
+		if err := recordAudit(ctx, tx, auditCreate, "student", t.ID, nil, t); err != nil {
+			return nil, err
+		}
 		addedStudents[i] = t
 	}
+	if err := tx.Commit(); err != nil {
+		return nil, utils.ErrorHandler(err, "Error inserting data into DB")
+	}
 	return addedStudents, nil
 }
 
-func UpdateStudentDB(id int, updatedStudent models.Student) (models.Student, error) {
-	db, err := ConnectDB()
+// UpdateStudentDB replaces the student if it is still at updatedStudent.Version, otherwise it
+// returns a *ConflictError
+func (db *DB) UpdateStudentDB(ctx context.Context, id int, updatedStudent models.Student) (models.Student, error) {
+	defer observe("UpdateStudentDB")()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Printf("Error connecting to DB: %v", err)
-		return models.Student{}, utils.ErrorHandler(err, "DB connection failed")
+		return models.Student{}, utils.ErrorHandler(err, "Error updating student")
 	}
-	defer db.Close()
+	defer tx.Rollback()
 
 	var existingStudent models.Student
-	err = db.QueryRow("SELECT id, first_name, last_name, email, class FROM students WHERE id = ?", id).Scan(&existingStudent.ID, &existingStudent.FirstName, &existingStudent.LastName, &existingStudent.Email, &existingStudent.Class)
+	err = tx.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, class, version FROM students WHERE id = ?", id).Scan(&existingStudent.ID, &existingStudent.FirstName, &existingStudent.LastName, &existingStudent.Email, &existingStudent.Class, &existingStudent.Version)
 	if err == sql.ErrNoRows {
 		return models.Student{}, utils.ErrorHandler(err, "Student data not found")
 	} else if err != nil {
-		log.Printf("Error retrieving student %d: %v", id, err)
+		slog.Error("Error retrieving student", "id", id, "error", err)
 		return models.Student{}, utils.ErrorHandler(err, "Failed to retrieve student data")
 	}
+	if err := checkVersion(id, updatedStudent.Version, existingStudent.Version); err != nil {
+		return models.Student{}, err
+	}
 
 	updatedStudent.ID = existingStudent.ID
-	if _, err = db.Exec("UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?", &updatedStudent.FirstName, &updatedStudent.LastName, &updatedStudent.Email, &updatedStudent.Class, &updatedStudent.ID); err != nil {
+	result, err := tx.ExecContext(ctx, "UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ?, version = version + 1 WHERE id = ? AND version = ?", &updatedStudent.FirstName, &updatedStudent.LastName, &updatedStudent.Email, &updatedStudent.Class, &updatedStudent.ID, existingStudent.Version)
+	if err != nil {
+		return models.Student{}, utils.ErrorHandler(err, "Error updating student")
+	}
+	if err := checkVersionedUpdate(ctx, tx, "students", id, existingStudent.Version, result); err != nil {
+		return models.Student{}, err
+	}
+	updatedStudent.Version = existingStudent.Version + 1
+	if err := recordAudit(ctx, tx, auditUpdate, "student", id, existingStudent, updatedStudent); err != nil {
+		return models.Student{}, err
+	}
+	if err := tx.Commit(); err != nil {
 		return models.Student{}, utils.ErrorHandler(err, "Error updating student")
 	}
 	return updatedStudent, nil
 }
 
-func PatchStudentDB(id int, updates map[string]any) (models.Student, error) {
-	db, err := ConnectDB()
+// PatchStudentDB applies updates if the student is still at version, otherwise it returns a
+// *ConflictError
+func (db *DB) PatchStudentDB(ctx context.Context, id, version int, updates map[string]any) (models.Student, error) {
+	defer observe("PatchStudentDB")()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return models.Student{}, utils.ErrorHandler(err, "DB connection failed")
+		return models.Student{}, utils.ErrorHandler(err, "Error updating student")
 	}
-	defer db.Close()
+	defer tx.Rollback()
 
 	var existingStudent models.Student
-	err = db.QueryRow("SELECT id, first_name, last_name, email, class FROM students WHERE id = ?", id).Scan(&existingStudent.ID, &existingStudent.FirstName, &existingStudent.LastName, &existingStudent.Email, &existingStudent.Class)
+	err = tx.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, class, version FROM students WHERE id = ?", id).Scan(&existingStudent.ID, &existingStudent.FirstName, &existingStudent.LastName, &existingStudent.Email, &existingStudent.Class, &existingStudent.Version)
 	if err == sql.ErrNoRows {
 		return models.Student{}, utils.ErrorHandler(err, "Student data not found")
 	} else if err != nil {
 		return models.Student{}, utils.ErrorHandler(err, "Failed to retrieve student data")
 	}
+	if err := checkVersion(id, version, existingStudent.Version); err != nil {
+		return models.Student{}, err
+	}
 
+	before := existingStudent
 	studentVal := reflect.ValueOf(&existingStudent).Elem()
 	studentType := studentVal.Type()
 
@@ -148,24 +172,33 @@ func PatchStudentDB(id int, updates map[string]any) (models.Student, error) {
 		}
 	}
 
-	if _, err = db.Exec("UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?", &existingStudent.FirstName, &existingStudent.LastName, &existingStudent.Email, &existingStudent.Class, &existingStudent.ID); err != nil {
+	result, err := tx.ExecContext(ctx, "UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ?, version = version + 1 WHERE id = ? AND version = ?", &existingStudent.FirstName, &existingStudent.LastName, &existingStudent.Email, &existingStudent.Class, &existingStudent.ID, existingStudent.Version)
+	if err != nil {
+		return models.Student{}, utils.ErrorHandler(err, "Error updating student")
+	}
+	if err := checkVersionedUpdate(ctx, tx, "students", id, existingStudent.Version, result); err != nil {
+		return models.Student{}, err
+	}
+	existingStudent.Version++
+	if err := recordAudit(ctx, tx, auditUpdate, "student", id, before, existingStudent); err != nil {
+		return models.Student{}, err
+	}
+	if err := tx.Commit(); err != nil {
 		return models.Student{}, utils.ErrorHandler(err, "Error updating student")
 	}
 	return existingStudent, nil
 }
 
-func PatchStudentsDB(updates []map[string]any) error {
-	db, err := ConnectDB()
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-
-	tx, err := db.Begin()
+// PatchStudentsDB applies every update or none. Each carries the "version" it was read at,
+// the ones that changed since are all returned in one *ConflictError.
+func (db *DB) PatchStudentsDB(ctx context.Context, updates []map[string]any) error {
+	defer observe("PatchStudentsDB")()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
+	var conflicts []models.VersionConflict
 	for _, update := range updates {
 		idStr, ok := update["id"].(string)
 		if !ok {
@@ -180,24 +213,29 @@ func PatchStudentsDB(updates []map[string]any) error {
 		}
 
 		var studentFromDb models.Student
-		err = db.QueryRow("SELECT id, first_name, last_name, email, class FROM students WHERE id = ?", id).Scan(&studentFromDb.ID, &studentFromDb.FirstName, &studentFromDb.LastName, &studentFromDb.Email, &studentFromDb.Class)
+		err = tx.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, class, version FROM students WHERE id = ? FOR UPDATE", id).Scan(&studentFromDb.ID, &studentFromDb.FirstName, &studentFromDb.LastName, &studentFromDb.Email, &studentFromDb.Class, &studentFromDb.Version)
 		if err != nil {
-			log.Println("ID:", id)
-			log.Printf("Type: %T", id)
-			log.Println(err)
+			slog.Error("Error retrieving row for patch", "id", id, "error", err)
 			tx.Rollback()
 			if err == sql.ErrNoRows {
 				return utils.ErrorHandler(err, "Student not found in the database")
 			}
 			return utils.ErrorHandler(err, "Error patching student information")
 		}
+		// the row is locked, so the version can't change before the update below
+		version, _ := update["version"].(float64)
+		if int(version) != studentFromDb.Version {
+			conflicts = append(conflicts, models.VersionConflict{ID: id, ExpectedVersion: int(version), CurrentVersion: studentFromDb.Version})
+			continue
+		}
 
+		before := studentFromDb
 		studentVal := reflect.ValueOf(&studentFromDb).Elem()
 		studentType := studentVal.Type()
 
 		for k, v := range update {
-			if k == "id" {
-				continue // skip updating the ID field
+			if k == "id" || k == "version" {
+				continue // skip updating the ID and version fields
 			}
 			for i := 0; i < studentVal.NumField(); i++ {
 				field := studentType.Field(i)
@@ -209,7 +247,7 @@ func PatchStudentsDB(updates []map[string]any) error {
 							fieldVal.Set(val.Convert(fieldVal.Type()))
 						} else {
 							tx.Rollback()
-							log.Printf("cannot convert %v to %v", val.Type(), fieldVal.Type())
+							slog.Warn("Cannot convert patch value", "from", val.Type().String(), "to", fieldVal.Type().String())
 							return utils.ErrorHandler(err, "Failed to patch student information")
 						}
 					}
@@ -218,11 +256,20 @@ func PatchStudentsDB(updates []map[string]any) error {
 			}
 		}
 
-		_, err = tx.Exec("UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?", studentFromDb.FirstName, studentFromDb.LastName, studentFromDb.Email, studentFromDb.Class, studentFromDb.ID)
+		_, err = tx.ExecContext(ctx, "UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ?, version = version + 1 WHERE id = ?", studentFromDb.FirstName, studentFromDb.LastName, studentFromDb.Email, studentFromDb.Class, studentFromDb.ID)
 		if err != nil {
 			tx.Rollback()
 			return utils.ErrorHandler(err, "Failed to patch student information")
 		}
+		studentFromDb.Version++
+		if err := recordAudit(ctx, tx, auditUpdate, "student", id, before, studentFromDb); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if len(conflicts) > 0 {
+		tx.Rollback()
+		return &ConflictError{Conflicts: conflicts}
 	}
 
 	err = tx.Commit()
@@ -232,41 +279,40 @@ func PatchStudentsDB(updates []map[string]any) error {
 	return nil
 }
 
-func DeleteStudentDB(id int) error {
-	db, err := ConnectDB()
+func (db *DB) DeleteStudentDB(ctx context.Context, id int) error {
+	defer observe("DeleteStudentDB")()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return utils.ErrorHandler(err, "DB connection failed")
+		return utils.ErrorHandler(err, "Error deleting student")
 	}
-	defer db.Close()
+	defer tx.Rollback()
 
-	result, err := db.Exec("DELETE FROM students WHERE id = ?", id)
-	if err != nil {
+	student, err := lockStudent(ctx, tx, id)
+	if err == sql.ErrNoRows {
+		return utils.ErrorHandler(err, "Student not found")
+	} else if err != nil {
 		return utils.ErrorHandler(err, "Error deleting student")
 	}
-	n, err := result.RowsAffected()
-	if err != nil {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM students WHERE id = ?", id); err != nil {
 		return utils.ErrorHandler(err, "Error deleting student")
 	}
-
-	if n == 0 {
-		return utils.ErrorHandler(err, "Student not found")
+	if err := recordAudit(ctx, tx, auditDelete, "student", id, student, nil); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return utils.ErrorHandler(err, "Error deleting student")
 	}
 	return nil
 }
 
-func DeleteStudentsDB(ids []int) ([]int, error) {
-	db, err := ConnectDB()
-	if err != nil {
-		return nil, utils.ErrorHandler(err, "DB connection failed")
-	}
-	defer db.Close()
-
-	tx, err := db.Begin()
+func (db *DB) DeleteStudentsDB(ctx context.Context, ids []int) ([]int, error) {
+	defer observe("DeleteStudentsDB")()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, utils.ErrorHandler(err, "Failed to delete students")
 	}
 
-	stmt, err := tx.Prepare("DELETE FROM students WHERE id = ?")
+	stmt, err := tx.PrepareContext(ctx, "DELETE FROM students WHERE id = ?")
 	if err != nil {
 		tx.Rollback()
 		return nil, utils.ErrorHandler(err, "Failed to delete students")
@@ -275,27 +321,24 @@ func DeleteStudentsDB(ids []int) ([]int, error) {
 
 	deletedIds := []int{}
 	for _, id := range ids {
-		result, err := stmt.Exec(id)
-		if err != nil {
+		student, err := lockStudent(ctx, tx, id)
+		if err == sql.ErrNoRows {
+			tx.Rollback()
+			return nil, utils.ErrorHandler(err, fmt.Sprintf("ID %d does not exist", id))
+		} else if err != nil {
 			tx.Rollback()
 			return nil, utils.ErrorHandler(err, "Failed to delete students")
 		}
 
-		rowsAffected, err := result.RowsAffected()
-		if err != nil {
+		if _, err := stmt.ExecContext(ctx, id); err != nil {
 			tx.Rollback()
 			return nil, utils.ErrorHandler(err, "Failed to delete students")
 		}
-
-		// If student was deleted, add the id to the deletedIds
-		if rowsAffected > 0 {
-			deletedIds = append(deletedIds, id)
-		}
-
-		if rowsAffected < 1 {
+		if err := recordAudit(ctx, tx, auditDelete, "student", id, student, nil); err != nil {
 			tx.Rollback()
-			return nil, utils.ErrorHandler(err, fmt.Sprintf("ID %d does not exist", id))
+			return nil, err
 		}
+		deletedIds = append(deletedIds, id)
 	}
 
 	err = tx.Commit()
@@ -308,3 +351,30 @@ func DeleteStudentsDB(ids []int) ([]int, error) {
 	}
 	return deletedIds, nil
 }
+
+// lockStudent reads and locks the student a delete is about to remove, for the audit log
+func lockStudent(ctx context.Context, tx *sql.Tx, id int) (models.Student, error) {
+	var student models.Student
+	err := tx.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, class, version FROM students WHERE id = ? FOR UPDATE", id).Scan(&student.ID, &student.FirstName, &student.LastName, &student.Email, &student.Class, &student.Version)
+	return student, err
+}
+
+// GetClassNamesDB returns the class names students can be assigned to
+func (db *DB) GetClassNamesDB(ctx context.Context) (map[string]bool, error) {
+	defer observe("GetClassNamesDB")()
+	rows, err := db.QueryContext(ctx, "SELECT class_name FROM classes")
+	if err != nil {
+		return nil, utils.ErrorHandler(err, "Database query error")
+	}
+	defer rows.Close()
+
+	classes := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, utils.ErrorHandler(err, "Error scanning a class row")
+		}
+		classes[name] = true
+	}
+	return classes, rows.Err()
+}
@@ -5,23 +5,20 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"reflect"
 	"schoolapi/internal/models"
 	"schoolapi/pkg/utils"
+	"slices"
 	"strconv"
 	"strings"
 )
 
-func GetTeacherDB(id int) (models.Teacher, error) {
-	db, err := ConnectDB()
-	if err != nil {
-		return models.Teacher{}, utils.ErrorHandler(err, "Error connecting to DB")
-	}
-	defer db.Close()
+func (db *DB) GetTeacherDB(ctx context.Context, id int) (models.Teacher, error) {
+	defer observe("GetTeacherDB")()
 	var teacher models.Teacher
-	err = db.QueryRow("SELECT id, first_name, last_name, email, subject FROM teachers WHERE id = ?", id).Scan(&teacher.ID, &teacher.FirstName, &teacher.LastName, &teacher.Email, &teacher.Subject)
+	err := db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, subject, version FROM teachers WHERE id = ?", id).Scan(&teacher.ID, &teacher.FirstName, &teacher.LastName, &teacher.Email, &teacher.Subject, &teacher.Version)
 	if err == sql.ErrNoRows {
 		return models.Teacher{}, utils.ErrorHandler(err, "Teacher not found")
 	} else if err != nil {
@@ -31,7 +28,7 @@ func GetTeacherDB(id int) (models.Teacher, error) {
 	var classes []models.Class
 	query := `SELECT c.id, c.class_name FROM classes c INNER JOIN class_assignments ca ON c.id = ca.class_id WHERE ca.teacher_id = ?`
 
-	rows, err := db.Query(query, id)
+	rows, err := db.QueryContext(ctx, query, id)
 	if err != nil {
 		return models.Teacher{}, utils.ErrorHandler(err, "Error retrieving teacher details")
 	}
@@ -49,39 +46,35 @@ func GetTeacherDB(id int) (models.Teacher, error) {
 	return teacher, nil
 }
 
-func GetTeachersDB(teachers []models.Teacher, r *http.Request) ([]models.Teacher, error) {
+func (db *DB) GetTeachersDB(teachers []models.Teacher, r *http.Request) ([]models.Teacher, error) {
+	defer observe("GetTeachersDB")()
+	ctx := r.Context()
 
-	query := "SELECT id, first_name, last_name, email, subject FROM teachers WHERE 1=1"
+	query := "SELECT id, first_name, last_name, email, subject, version FROM teachers WHERE 1=1"
 	var args []any
 
-	query, args = addFilters(r, query, args)
-	query = addSorting(r, query)
-
-	db, err := ConnectDB()
-	if err != nil {
-		return nil, utils.ErrorHandler(err, "Error connecting to DB")
-	}
-	defer db.Close()
+	query, args = addFilters(r, query, args, TeacherFilters)
+	query = addSorting(r, query, TeacherFilters)
 
-	rows, err := db.Query(query, args...)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
-		log.Printf("Query error: %v", err)
+		slog.Error("Query error", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var teacher models.Teacher
-		err := rows.Scan(&teacher.ID, &teacher.FirstName, &teacher.LastName, &teacher.Email, &teacher.Subject)
+		err := rows.Scan(&teacher.ID, &teacher.FirstName, &teacher.LastName, &teacher.Email, &teacher.Subject, &teacher.Version)
 		if err != nil {
-			log.Printf("Row scan error: %v", err)
+			slog.Error("Row scan error", "error", err)
 			return nil, err
 		}
 
 		var classes []models.Class
 		query = `SELECT c.id, c.class_name FROM classes c INNER JOIN class_assignments ca ON c.id = ca.class_id WHERE ca.teacher_id = ?`
 
-		rows, err = db.Query(query, teacher.ID)
+		rows, err = db.QueryContext(ctx, query, teacher.ID)
 		if err != nil {
 			utils.ErrorHandler(err, "Error retrieving teacher details")
 		}
@@ -101,15 +94,16 @@ func GetTeachersDB(teachers []models.Teacher, r *http.Request) ([]models.Teacher
 	return teachers, nil
 }
 
-func AddTeachersDB(newTeachers []models.Teacher) ([]models.Teacher, error) {
-	db, err := ConnectDB()
+func (db *DB) AddTeachersDB(ctx context.Context, newTeachers []models.Teacher) ([]models.Teacher, error) {
+	defer observe("AddTeachersDB")()
+	// stmt, err := db.PrepareContext(ctx, "INSERT INTO teachers (first_name, last_name, email, `class`, `subject`) VALUES (?,?,?,?,?)") // the olden way of manual labor
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "Error connecting to DB")
+		return nil, utils.ErrorHandler(err, "Error inserting data into DB")
 	}
-	defer db.Close()
+	defer tx.Rollback()
 
-	// stmt, err := db.Prepare("INSERT INTO teachers (first_name, last_name, email, `class`, `subject`) VALUES (?,?,?,?,?)") // the olden way of manual labor
-	stmt, err := db.Prepare(generateInsertQuery(models.Teacher{}, "teachers")) // using new function
+	stmt, err := tx.PrepareContext(ctx, generateInsertQuery(models.Teacher{}, "teachers")) // using new function
 	if err != nil {
 		return nil, utils.ErrorHandler(err, "Error preparing SQL statement")
 	}
@@ -118,7 +112,7 @@ func AddTeachersDB(newTeachers []models.Teacher) ([]models.Teacher, error) {
 	addedTeachers := make([]models.Teacher, len(newTeachers))
 	for i, t := range newTeachers {
 		values := getStructValues(t)
-		res, err := stmt.Exec(values...)
+		res, err := stmt.ExecContext(ctx, values...)
 		if err != nil {
 			return nil, utils.ErrorHandler(err, "Error inserting data into DB")
 		}
@@ -127,44 +121,55 @@ func AddTeachersDB(newTeachers []models.Teacher) ([]models.Teacher, error) {
 			return nil, utils.ErrorHandler(err, "Error getting last inserted ID")
 		}
 		t.ID = int(lastId)
+		t.Version = 1
+		if err := recordAudit(ctx, tx, auditCreate, "teacher", t.ID, nil, t); err != nil {
+			return nil, err
+		}
 		addedTeachers[i] = t
 	}
+	if err := tx.Commit(); err != nil {
+		return nil, utils.ErrorHandler(err, "Error inserting data into DB")
+	}
 	return addedTeachers, nil
 }
 
-func UpdateTeacherDB(ctx context.Context, id int, updatedTeacher models.Teacher) (models.Teacher, error) {
-	db, err := ConnectDB()
+// UpdateTeacherDB replaces the teacher and their classes if the teacher is still at
+// updatedTeacher.Version, otherwise it returns a *ConflictError
+func (db *DB) UpdateTeacherDB(ctx context.Context, id int, updatedTeacher models.Teacher) (models.Teacher, error) {
+	defer observe("UpdateTeacherDB")()
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
 	if err != nil {
-		log.Printf("Error connecting to DB: %v", err)
-		return models.Teacher{}, utils.ErrorHandler(err, "DB connection failed")
+		return models.Teacher{}, utils.ErrorHandler(err, "Failed to begin transaction")
 	}
-	defer db.Close()
+	defer func() {
+		// safety: rollback if not committed
+		_ = tx.Rollback()
+	}()
 
-	// Ensure the teacher exists (and lock row minimally)
-	var exists int
-	if err := db.QueryRowContext(ctx, "SELECT 1 FROM teachers WHERE id = ?", id).Scan(&exists); err != nil {
+	// Ensure the teacher exists and is still at the version the client read
+	existingTeacher, err := lockTeacher(ctx, tx, id)
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.Teacher{}, utils.ErrorHandler(err, "Teacher not found")
 		}
 		return models.Teacher{}, utils.ErrorHandler(err, "Failed to verify teacher")
 	}
-
-	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
-	if err != nil {
-		return models.Teacher{}, utils.ErrorHandler(err, "Failed to begin transaction")
+	currentVersion := existingTeacher.Version
+	if err := checkVersion(id, updatedTeacher.Version, currentVersion); err != nil {
+		return models.Teacher{}, err
 	}
-	defer func() {
-		// safety: rollback if not committed
-		_ = tx.Rollback()
-	}()
 
-	// 1) Update base fields
-	if _, err := tx.ExecContext(ctx,
-		`UPDATE teachers SET first_name=?, last_name=?, email=?, subject=? WHERE id=?`,
-		updatedTeacher.FirstName, updatedTeacher.LastName, updatedTeacher.Email, updatedTeacher.Subject, id,
-	); err != nil {
+	// 1) Update base fields, unless someone else got there first
+	result, err := tx.ExecContext(ctx,
+		`UPDATE teachers SET first_name=?, last_name=?, email=?, subject=?, version=version+1 WHERE id=? AND version=?`,
+		updatedTeacher.FirstName, updatedTeacher.LastName, updatedTeacher.Email, updatedTeacher.Subject, id, currentVersion,
+	)
+	if err != nil {
 		return models.Teacher{}, utils.ErrorHandler(err, "Error updating teacher")
 	}
+	if err := checkVersionedUpdate(ctx, tx, "teachers", id, currentVersion, result); err != nil {
+		return models.Teacher{}, err
+	}
 
 	// 2) Gather desired class IDs from payload
 	desired := make(map[int]struct{}, len(updatedTeacher.Classes))
@@ -282,13 +287,22 @@ func UpdateTeacherDB(ctx context.Context, id int, updatedTeacher models.Teacher)
 		}
 	}
 
-	// 8) Commit
+	// 8) Audit, classes by id only
+	existingTeacher.Classes = classRefs(current)
+	audited := updatedTeacher
+	audited.ID, audited.Version, audited.Classes = id, currentVersion+1, classRefs(desired)
+	if err := recordAudit(ctx, tx, auditUpdate, "teacher", id, existingTeacher, audited); err != nil {
+		return models.Teacher{}, err
+	}
+
+	// 9) Commit
 	if err := tx.Commit(); err != nil {
 		return models.Teacher{}, utils.ErrorHandler(err, "Transaction commit failed")
 	}
 
-	// 9) (Optional) Re-read canonical result to return
+	// 10) (Optional) Re-read canonical result to return
 	updatedTeacher.ID = id
+	updatedTeacher.Version = currentVersion + 1
 	// Reload classes to return a fresh slice
 	rows, err := db.QueryContext(ctx, `
         SELECT c.id, c.class_name
@@ -317,21 +331,28 @@ func UpdateTeacherDB(ctx context.Context, id int, updatedTeacher models.Teacher)
 
 }
 
-func PatchTeacherDB(id int, updates map[string]any) (models.Teacher, error) {
-	db, err := ConnectDB()
+// PatchTeacherDB applies updates if the teacher is still at version, otherwise it returns a
+// *ConflictError
+func (db *DB) PatchTeacherDB(ctx context.Context, id, version int, updates map[string]any) (models.Teacher, error) {
+	defer observe("PatchTeacherDB")()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return models.Teacher{}, utils.ErrorHandler(err, "DB connection failed")
+		return models.Teacher{}, utils.ErrorHandler(err, "Error updating teacher")
 	}
-	defer db.Close()
+	defer tx.Rollback()
 
 	var existingTeacher models.Teacher
-	err = db.QueryRow("SELECT id, first_name, last_name, email, subject FROM teachers WHERE id = ?", id).Scan(&existingTeacher.ID, &existingTeacher.FirstName, &existingTeacher.LastName, &existingTeacher.Email, &existingTeacher.Subject)
+	err = tx.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, subject, version FROM teachers WHERE id = ?", id).Scan(&existingTeacher.ID, &existingTeacher.FirstName, &existingTeacher.LastName, &existingTeacher.Email, &existingTeacher.Subject, &existingTeacher.Version)
 	if err == sql.ErrNoRows {
 		return models.Teacher{}, utils.ErrorHandler(err, "Teacher data not found")
 	} else if err != nil {
 		return models.Teacher{}, utils.ErrorHandler(err, "Failed to retrieve teacher data")
 	}
+	if err := checkVersion(id, version, existingTeacher.Version); err != nil {
+		return models.Teacher{}, err
+	}
 
+	before := existingTeacher
 	teacherVal := reflect.ValueOf(&existingTeacher).Elem()
 	teacherType := teacherVal.Type()
 
@@ -344,24 +365,33 @@ func PatchTeacherDB(id int, updates map[string]any) (models.Teacher, error) {
 		}
 	}
 
-	if _, err = db.Exec("UPDATE teachers SET first_name = ?, last_name = ?, email = ?, subject = ? WHERE id = ?", &existingTeacher.FirstName, &existingTeacher.LastName, &existingTeacher.Email, &existingTeacher.Subject, &existingTeacher.ID); err != nil {
+	result, err := tx.ExecContext(ctx, "UPDATE teachers SET first_name = ?, last_name = ?, email = ?, subject = ?, version = version + 1 WHERE id = ? AND version = ?", &existingTeacher.FirstName, &existingTeacher.LastName, &existingTeacher.Email, &existingTeacher.Subject, &existingTeacher.ID, existingTeacher.Version)
+	if err != nil {
+		return models.Teacher{}, utils.ErrorHandler(err, "Error updating teacher")
+	}
+	if err := checkVersionedUpdate(ctx, tx, "teachers", id, existingTeacher.Version, result); err != nil {
+		return models.Teacher{}, err
+	}
+	existingTeacher.Version++
+	if err := recordAudit(ctx, tx, auditUpdate, "teacher", id, before, existingTeacher); err != nil {
+		return models.Teacher{}, err
+	}
+	if err := tx.Commit(); err != nil {
 		return models.Teacher{}, utils.ErrorHandler(err, "Error updating teacher")
 	}
 	return existingTeacher, nil
 }
 
-func PatchTeachersDB(updates []map[string]any) error {
-	db, err := ConnectDB()
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-
-	tx, err := db.Begin()
+// PatchTeachersDB applies every update or none. Each carries the "version" it was read at,
+// the ones that changed since are all returned in one *ConflictError.
+func (db *DB) PatchTeachersDB(ctx context.Context, updates []map[string]any) error {
+	defer observe("PatchTeachersDB")()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
+	var conflicts []models.VersionConflict
 	for _, update := range updates {
 		idStr, ok := update["id"].(string)
 		if !ok {
@@ -376,24 +406,29 @@ func PatchTeachersDB(updates []map[string]any) error {
 		}
 
 		var teacherFromDb models.Teacher
-		err = db.QueryRow("SELECT id, first_name, last_name, email, subject FROM teachers WHERE id = ?", id).Scan(&teacherFromDb.ID, &teacherFromDb.FirstName, &teacherFromDb.LastName, &teacherFromDb.Email, &teacherFromDb.Subject)
+		err = tx.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, subject, version FROM teachers WHERE id = ? FOR UPDATE", id).Scan(&teacherFromDb.ID, &teacherFromDb.FirstName, &teacherFromDb.LastName, &teacherFromDb.Email, &teacherFromDb.Subject, &teacherFromDb.Version)
 		if err != nil {
-			log.Println("ID:", id)
-			log.Printf("Type: %T", id)
-			log.Println(err)
+			slog.Error("Error retrieving row for patch", "id", id, "error", err)
 			tx.Rollback()
 			if err == sql.ErrNoRows {
 				return utils.ErrorHandler(err, "Teacher not found in the database")
 			}
 			return utils.ErrorHandler(err, "Error patching teacher information")
 		}
+		// the row is locked, so the version can't change before the update below
+		version, _ := update["version"].(float64)
+		if int(version) != teacherFromDb.Version {
+			conflicts = append(conflicts, models.VersionConflict{ID: id, ExpectedVersion: int(version), CurrentVersion: teacherFromDb.Version})
+			continue
+		}
 
+		before := teacherFromDb
 		teacherVal := reflect.ValueOf(&teacherFromDb).Elem()
 		teacherType := teacherVal.Type()
 
 		for k, v := range update {
-			if k == "id" {
-				continue // skip updating the ID field
+			if k == "id" || k == "version" {
+				continue // skip updating the ID and version fields
 			}
 			for i := 0; i < teacherVal.NumField(); i++ {
 				field := teacherType.Field(i)
@@ -405,7 +440,7 @@ func PatchTeachersDB(updates []map[string]any) error {
 							fieldVal.Set(val.Convert(fieldVal.Type()))
 						} else {
 							tx.Rollback()
-							log.Printf("cannot convert %v to %v", val.Type(), fieldVal.Type())
+							slog.Warn("Cannot convert patch value", "from", val.Type().String(), "to", fieldVal.Type().String())
 							return utils.ErrorHandler(err, "Failed to patch teacher information")
 						}
 					}
@@ -414,11 +449,20 @@ func PatchTeachersDB(updates []map[string]any) error {
 			}
 		}
 
-		_, err = tx.Exec("UPDATE teachers SET first_name = ?, last_name = ?, email = ?, subject = ? WHERE id = ?", teacherFromDb.FirstName, teacherFromDb.LastName, teacherFromDb.Email, teacherFromDb.Subject, teacherFromDb.ID)
+		_, err = tx.ExecContext(ctx, "UPDATE teachers SET first_name = ?, last_name = ?, email = ?, subject = ?, version = version + 1 WHERE id = ?", teacherFromDb.FirstName, teacherFromDb.LastName, teacherFromDb.Email, teacherFromDb.Subject, teacherFromDb.ID)
 		if err != nil {
 			tx.Rollback()
 			return utils.ErrorHandler(err, "Failed to patch teacher information")
 		}
+		teacherFromDb.Version++
+		if err := recordAudit(ctx, tx, auditUpdate, "teacher", id, before, teacherFromDb); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if len(conflicts) > 0 {
+		tx.Rollback()
+		return &ConflictError{Conflicts: conflicts}
 	}
 
 	err = tx.Commit()
@@ -428,41 +472,40 @@ func PatchTeachersDB(updates []map[string]any) error {
 	return nil
 }
 
-func DeleteTeacherDB(id int) error {
-	db, err := ConnectDB()
+func (db *DB) DeleteTeacherDB(ctx context.Context, id int) error {
+	defer observe("DeleteTeacherDB")()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return utils.ErrorHandler(err, "DB connection failed")
+		return utils.ErrorHandler(err, "Error deleting teacher")
 	}
-	defer db.Close()
+	defer tx.Rollback()
 
-	result, err := db.Exec("DELETE FROM teachers WHERE id = ?", id)
-	if err != nil {
+	teacher, err := lockTeacher(ctx, tx, id)
+	if err == sql.ErrNoRows {
+		return utils.ErrorHandler(err, "Teacher not found")
+	} else if err != nil {
 		return utils.ErrorHandler(err, "Error deleting teacher")
 	}
-	n, err := result.RowsAffected()
-	if err != nil {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM teachers WHERE id = ?", id); err != nil {
 		return utils.ErrorHandler(err, "Error deleting teacher")
 	}
-
-	if n == 0 {
-		return utils.ErrorHandler(err, "Teacher not found")
+	if err := recordAudit(ctx, tx, auditDelete, "teacher", id, teacher, nil); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return utils.ErrorHandler(err, "Error deleting teacher")
 	}
 	return nil
 }
 
-func DeleteTeachersDB(ids []int) ([]int, error) {
-	db, err := ConnectDB()
-	if err != nil {
-		return nil, utils.ErrorHandler(err, "DB connection failed")
-	}
-	defer db.Close()
-
-	tx, err := db.Begin()
+func (db *DB) DeleteTeachersDB(ctx context.Context, ids []int) ([]int, error) {
+	defer observe("DeleteTeachersDB")()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, utils.ErrorHandler(err, "Failed to delete teachers")
 	}
 
-	stmt, err := tx.Prepare("DELETE FROM teachers WHERE id = ?")
+	stmt, err := tx.PrepareContext(ctx, "DELETE FROM teachers WHERE id = ?")
 	if err != nil {
 		tx.Rollback()
 		return nil, utils.ErrorHandler(err, "Failed to delete teachers")
@@ -471,27 +514,24 @@ func DeleteTeachersDB(ids []int) ([]int, error) {
 
 	deletedIds := []int{}
 	for _, id := range ids {
-		result, err := stmt.Exec(id)
-		if err != nil {
+		teacher, err := lockTeacher(ctx, tx, id)
+		if err == sql.ErrNoRows {
+			tx.Rollback()
+			return nil, utils.ErrorHandler(err, fmt.Sprintf("ID %d does not exist", id))
+		} else if err != nil {
 			tx.Rollback()
 			return nil, utils.ErrorHandler(err, "Failed to delete teachers")
 		}
 
-		rowsAffected, err := result.RowsAffected()
-		if err != nil {
+		if _, err := stmt.ExecContext(ctx, id); err != nil {
 			tx.Rollback()
 			return nil, utils.ErrorHandler(err, "Failed to delete teachers")
 		}
-
-		// If teacher was deleted, then add the id to the deletedIds
-		if rowsAffected > 0 {
-			deletedIds = append(deletedIds, id)
-		}
-
-		if rowsAffected < 1 {
+		if err := recordAudit(ctx, tx, auditDelete, "teacher", id, teacher, nil); err != nil {
 			tx.Rollback()
-			return nil, utils.ErrorHandler(err, fmt.Sprintf("ID %d does not exist", id))
+			return nil, err
 		}
+		deletedIds = append(deletedIds, id)
 	}
 
 	err = tx.Commit()
@@ -505,13 +545,25 @@ func DeleteTeachersDB(ids []int) ([]int, error) {
 	return deletedIds, nil
 }
 
-func GetStudentsByTeacherIdDB(teacherId string, students []models.Student) ([]models.Student, error) {
-	db, err := ConnectDB()
-	if err != nil {
-		return nil, utils.ErrorHandler(err, "Failed connect to DB")
+// lockTeacher reads the teacher a write is about to change, for the version check and the audit log
+func lockTeacher(ctx context.Context, tx *sql.Tx, id int) (models.Teacher, error) {
+	var teacher models.Teacher
+	err := tx.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, subject, version FROM teachers WHERE id = ? FOR UPDATE", id).Scan(&teacher.ID, &teacher.FirstName, &teacher.LastName, &teacher.Email, &teacher.Subject, &teacher.Version)
+	return teacher, err
+}
+
+// classRefs lists class ids in order, as the audit log records a teacher's classes
+func classRefs(ids map[int]struct{}) []models.Class {
+	refs := make([]models.Class, 0, len(ids))
+	for id := range ids {
+		refs = append(refs, models.Class{ID: id})
 	}
-	defer db.Close()
+	slices.SortFunc(refs, func(a, b models.Class) int { return a.ID - b.ID })
+	return refs
+}
 
+func (db *DB) GetStudentsByTeacherIdDB(ctx context.Context, teacherId string, students []models.Student) ([]models.Student, error) {
+	defer observe("GetStudentsByTeacherIdDB")()
 	query := `
 	SELECT DISTINCT s.id, s.first_name, s.last_name, s.email
 						FROM students s
@@ -520,7 +572,7 @@ func GetStudentsByTeacherIdDB(teacherId string, students []models.Student) ([]mo
 						WHERE ca.teacher_id = ?
 						ORDER BY s.id;`
 
-	rows, err := db.Query(query, teacherId)
+	rows, err := db.QueryContext(ctx, query, teacherId)
 	if err != nil {
 		return nil, utils.ErrorHandler(err, "Failed to retrieve teacher data from DB")
 	}
@@ -542,13 +594,8 @@ func GetStudentsByTeacherIdDB(teacherId string, students []models.Student) ([]mo
 	return students, nil
 }
 
-func GetStudentsCountByTeacherIdDB(teacherId string) (uint, error) {
-	db, err := ConnectDB()
-	if err != nil {
-		return 0, utils.ErrorHandler(err, "Failed connect to DB")
-	}
-	defer db.Close()
-
+func (db *DB) GetStudentsCountByTeacherIdDB(ctx context.Context, teacherId string) (uint, error) {
+	defer observe("GetStudentsCountByTeacherIdDB")()
 	var studentCount uint
 
 	query := `SELECT COUNT(DISTINCT s.id) AS student_count
@@ -557,7 +604,7 @@ func GetStudentsCountByTeacherIdDB(teacherId string) (uint, error) {
 				JOIN class_assignments ca ON ca.class_id = ce.class_id
 				WHERE ca.teacher_id = ?`
 
-	err = db.QueryRow(query, teacherId).Scan(&studentCount)
+	err := db.QueryRowContext(ctx, query, teacherId).Scan(&studentCount)
 	if err != nil {
 		return 0, utils.ErrorHandler(err, "Failed to retrieve student count")
 	}
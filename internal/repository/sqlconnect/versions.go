@@ -0,0 +1,48 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"schoolapi/internal/models"
+	"strings"
+)
+
+// ConflictError is returned when rows changed since the client read them. Nothing was written.
+type ConflictError struct {
+	Conflicts []models.VersionConflict
+}
+
+func (e *ConflictError) Error() string {
+	ids := make([]string, len(e.Conflicts))
+	for i, c := range e.Conflicts {
+		ids[i] = fmt.Sprint(c.ID)
+	}
+	return "changed since it was read: " + strings.Join(ids, ", ")
+}
+
+// AnyVersion skips the check, for writers that aren't editing something they read, like SCIM
+// provisioning where the identity provider has the last word
+const AnyVersion = 0
+
+// checkVersion compares the version just read with the one the client sent
+func checkVersion(id, expected, current int) error {
+	if expected == AnyVersion || expected == current {
+		return nil
+	}
+	return &ConflictError{Conflicts: []models.VersionConflict{{ID: id, ExpectedVersion: expected, CurrentVersion: current}}}
+}
+
+// checkVersionedUpdate is for an UPDATE ... WHERE id = ? AND version = ?. No row affected means
+// someone else's write got in between the read and this one.
+func checkVersionedUpdate(ctx context.Context, q queryRower, table string, id, expected int, result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+	var current int
+	if err := q.QueryRowContext(ctx, "SELECT version FROM "+table+" WHERE id = ?", id).Scan(&current); err != nil {
+		return err
+	}
+	return checkVersion(id, expected, current)
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// idle is when the bucket will be full again and can be dropped
+	idle time.Time
+}
+
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	revoked map[string]time.Time
+	stop    chan struct{}
+	once    sync.Once
+	// now is time.Now, tests move it forward
+	now func() time.Time
+}
+
+func NewMemory() *Memory {
+	m := &Memory{
+		buckets: make(map[string]*bucket),
+		revoked: make(map[string]time.Time),
+		stop:    make(chan struct{}),
+		now:     time.Now,
+	}
+	go m.evict()
+	return m
+}
+
+func (m *Memory) Take(_ context.Context, key string, limit int, window time.Duration) (TakeResult, error) {
+	capacity := float64(limit)
+	perToken := float64(window) / capacity
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, last: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+float64(now.Sub(b.last))/perToken)
+	b.last = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	result := takeResult(allowed, b.tokens, limit, window)
+	b.idle = now.Add(result.Reset)
+	return result, nil
+}
+
+func (m *Memory) Revoke(_ context.Context, id string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.revoked[id] = m.now().Add(ttl)
+	return nil
+}
+
+func (m *Memory) IsRevoked(_ context.Context, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	expires, ok := m.revoked[id]
+	return ok && m.now().Before(expires), nil
+}
+
+// Close ends the background eviction goroutine
+func (m *Memory) Close() error {
+	m.once.Do(func() { close(m.stop) })
+	return nil
+}
+
+// evict drops full buckets and expired revocations, neither carries state worth keeping
+func (m *Memory) evict() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case now := <-ticker.C:
+			m.mu.Lock()
+			for key, b := range m.buckets {
+				if now.After(b.idle) {
+					delete(m.buckets, key)
+				}
+			}
+			for id, expires := range m.revoked {
+				if now.After(expires) {
+					delete(m.revoked, id)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// testStore is a Store whose clock the test moves forward
+type testStore struct {
+	Store
+	advance func(d time.Duration)
+}
+
+// stores runs test against the memory store and against the Redis store on a miniredis
+func stores(t *testing.T, test func(t *testing.T, s testStore)) {
+	start := time.Date(2026, 1, 5, 8, 0, 0, 0, time.UTC)
+
+	t.Run("memory", func(t *testing.T) {
+		m := NewMemory()
+		t.Cleanup(func() { m.Close() })
+		clock := start
+		m.now = func() time.Time { return clock }
+		test(t, testStore{m, func(d time.Duration) { clock = clock.Add(d) }})
+	})
+
+	t.Run("redis", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		clock := start
+		mr.SetTime(clock)
+		r, err := NewRedis("redis://" + mr.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { r.Close() })
+		test(t, testStore{r, func(d time.Duration) {
+			clock = clock.Add(d)
+			mr.SetTime(clock)
+			mr.FastForward(d)
+		}})
+	})
+}
+
+func TestTake(t *testing.T) {
+	stores(t, func(t *testing.T, s testStore) {
+		ctx := context.Background()
+		take := func(key string) TakeResult {
+			t.Helper()
+			result, err := s.Take(ctx, key, 3, 3*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return result
+		}
+
+		for want := 2; want >= 0; want-- {
+			result := take("client-a")
+			if !result.Allowed || result.Remaining != want {
+				t.Fatalf("take = %+v, want allowed with %d remaining", result, want)
+			}
+		}
+
+		denied := take("client-a")
+		want := TakeResult{Allowed: false, Remaining: 0, RetryAfter: time.Second, Reset: 3 * time.Second}
+		if denied != want {
+			t.Errorf("take on an empty bucket = %+v, want %+v", denied, want)
+		}
+		if other := take("client-b"); !other.Allowed || other.Remaining != 2 {
+			t.Errorf("another key = %+v, want its own full bucket", other)
+		}
+
+		// one token refills per second
+		s.advance(time.Second)
+		if result := take("client-a"); !result.Allowed || result.Remaining != 0 {
+			t.Errorf("take after a refill = %+v, want allowed with 0 remaining", result)
+		}
+		if result := take("client-a"); result.Allowed {
+			t.Errorf("take = %+v, want the refilled token spent", result)
+		}
+
+		// an idle bucket refills to its limit and no further
+		s.advance(time.Hour)
+		if result := take("client-a"); !result.Allowed || result.Remaining != 2 {
+			t.Errorf("take after idling = %+v, want allowed with 2 remaining", result)
+		}
+	})
+}
+
+func TestRevoke(t *testing.T) {
+	stores(t, func(t *testing.T, s testStore) {
+		ctx := context.Background()
+		isRevoked := func(id string) bool {
+			t.Helper()
+			revoked, err := s.IsRevoked(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return revoked
+		}
+
+		if isRevoked("session-1") {
+			t.Fatal("session revoked before Revoke")
+		}
+		if err := s.Revoke(ctx, "session-1", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if !isRevoked("session-1") {
+			t.Error("session not revoked")
+		}
+		if isRevoked("session-2") {
+			t.Error("revoking one session revoked another")
+		}
+
+		s.advance(59 * time.Second)
+		if !isRevoked("session-1") {
+			t.Error("revocation expired before its ttl")
+		}
+		s.advance(2 * time.Second)
+		if isRevoked("session-1") {
+			t.Error("revocation outlived its ttl")
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"schoolapi/internal/api/router"
+	"schoolapi/pkg/utils"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	callerTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	callerSpanID  = "00f067aa0ba902b7"
+)
+
+// recordSpans installs a tracer provider sampling like Setup's, recording into memory
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(recorder),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+	)
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	return recorder
+}
+
+// serve runs a request through the stages in the order main applies them, handler answers it
+func serve(r *http.Request, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /students/{id}", handler)
+	passThrough := func(next http.Handler) http.Handler { return next }
+	h := utils.ApplyMiddleware(mux,
+		Handler,
+		Stage("jwt", passThrough),
+		Stage("logging", passThrough),
+		Server,
+		router.Route(mux),
+	)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func spansByName(t *testing.T, recorder *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	t.Helper()
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	if started, ended := len(recorder.Started()), len(recorder.Ended()); started != ended {
+		t.Errorf("%d spans started, %d ended", started, ended)
+	}
+	return spans
+}
+
+func attributeValue(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestSpans(t *testing.T) {
+	recorder := recordSpans(t)
+
+	var handlerContext trace.SpanContext
+	r := httptest.NewRequest(http.MethodGet, "/students/7", nil)
+	r.Header.Set("traceparent", "00-"+callerTraceID+"-"+callerSpanID+"-01")
+	w := serve(r, func(w http.ResponseWriter, r *http.Request) {
+		handlerContext = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	spans := spansByName(t, recorder)
+	if len(spans) != 4 {
+		t.Fatalf("recorded %d spans, want 4: %v", len(spans), spans)
+	}
+	server, ok := spans["GET /students/{id}"]
+	if !ok {
+		t.Fatalf("no server span named after the route: %v", spans)
+	}
+
+	// the caller's trace continues, every span of the request belongs to it
+	if server.SpanKind() != trace.SpanKindServer {
+		t.Errorf("server span kind = %v, want %v", server.SpanKind(), trace.SpanKindServer)
+	}
+	if got := server.Parent().SpanID().String(); got != callerSpanID || !server.Parent().IsRemote() {
+		t.Errorf("server span parent = %s (remote %t), want the caller's %s", got, server.Parent().IsRemote(), callerSpanID)
+	}
+	for name, span := range spans {
+		if got := span.SpanContext().TraceID().String(); got != callerTraceID {
+			t.Errorf("%q trace id = %s, want %s", name, got, callerTraceID)
+		}
+	}
+
+	for key, want := range map[attribute.Key]attribute.Value{
+		"http.request.method":       attribute.StringValue("GET"),
+		"url.path":                  attribute.StringValue("/students/7"),
+		"http.route":                attribute.StringValue("GET /students/{id}"),
+		"http.response.status_code": attribute.IntValue(http.StatusOK),
+	} {
+		if got, ok := attributeValue(server, key); !ok || got != want {
+			t.Errorf("server span %s = %v, want %v", key, got.Emit(), want.Emit())
+		}
+	}
+	if server.Status().Code != codes.Unset {
+		t.Errorf("server span status = %v, want unset", server.Status())
+	}
+
+	// each stage sits inside the one applied after it, the handler inside the innermost
+	parents := []struct{ child, parent string }{
+		{"middleware logging", "GET /students/{id}"},
+		{"middleware jwt", "middleware logging"},
+		{"handler GET /students/{id}", "middleware jwt"},
+	}
+	for _, tt := range parents {
+		child, ok := spans[tt.child]
+		if !ok {
+			t.Errorf("no %q span: %v", tt.child, spans)
+			continue
+		}
+		if child.Parent().SpanID() != spans[tt.parent].SpanContext().SpanID() {
+			t.Errorf("%q parent = %s, want %q", tt.child, child.Parent().SpanID(), tt.parent)
+		}
+	}
+
+	// what the handler passes on, e.g. to the SQL spans, is the handler span
+	if handlerContext.SpanID() != spans["handler GET /students/{id}"].SpanContext().SpanID() {
+		t.Errorf("handler context span = %s, want the handler span", handlerContext.SpanID())
+	}
+}
+
+func TestServerSpanWithoutCaller(t *testing.T) {
+	recorder := recordSpans(t)
+
+	w := serve(httptest.NewRequest(http.MethodGet, "/students/7", nil), func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+
+	server, ok := spansByName(t, recorder)["GET /students/{id}"]
+	if !ok {
+		t.Fatal("no server span")
+	}
+	if server.Parent().IsValid() {
+		t.Errorf("server span parent = %s, want a new trace", server.Parent().SpanID())
+	}
+	if got, _ := attributeValue(server, "http.response.status_code"); got != attribute.IntValue(http.StatusInternalServerError) {
+		t.Errorf("server span status code = %v, want %d", got.Emit(), http.StatusInternalServerError)
+	}
+	if server.Status().Code != codes.Error {
+		t.Errorf("server span status = %v, want an error on a 5xx", server.Status())
+	}
+}
+
+// a caller that didn't sample its trace isn't overruled, but the context still reaches the handler
+func TestUnsampledCaller(t *testing.T) {
+	recorder := recordSpans(t)
+
+	var handlerContext trace.SpanContext
+	r := httptest.NewRequest(http.MethodGet, "/students/7", nil)
+	r.Header.Set("traceparent", "00-"+callerTraceID+"-"+callerSpanID+"-00")
+	serve(r, func(w http.ResponseWriter, r *http.Request) {
+		handlerContext = trace.SpanContextFromContext(r.Context())
+	})
+
+	if spans := recorder.Ended(); len(spans) != 0 {
+		t.Errorf("recorded %d spans of an unsampled trace", len(spans))
+	}
+	if got := handlerContext.TraceID().String(); got != callerTraceID {
+		t.Errorf("handler trace id = %s, want %s", got, callerTraceID)
+	}
+}
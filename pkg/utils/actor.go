@@ -0,0 +1,12 @@
+package utils
+
+import "context"
+
+// Actor is who the request acts as: the exec's username, "apikey:<name>" or "scim". Requests
+// without credentials, like a password reset, are "anonymous".
+func Actor(ctx context.Context) string {
+	if username, ok := ctx.Value(ContextKey("username")).(string); ok && username != "" {
+		return username
+	}
+	return "anonymous"
+}
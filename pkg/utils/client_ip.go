@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+)
+
+// ClientIP returns the address mw.RealIP resolved for the request, falling back to the peer address
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(ContextKey("clientIP")).(string); ok && ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
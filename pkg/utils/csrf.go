@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// CSRFToken derives the session's CSRF token, so there is nothing to store server side and
+// the token changes with every login
+func CSRFToken(secret []byte, sessionId string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("csrf:" + sessionId))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func VerifyCSRFToken(secret []byte, sessionId, token string) bool {
+	return hmac.Equal([]byte(CSRFToken(secret, sessionId)), []byte(token))
+}
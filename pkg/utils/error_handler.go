@@ -2,12 +2,10 @@ package utils
 
 import (
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
 )
 
 func ErrorHandler(err error, message string) error {
-	errorLogger := log.New(os.Stderr, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
-	errorLogger.Println(message, err)
+	slog.Error(message, "error", err)
 	return fmt.Errorf("%s", message)
 }
@@ -1,34 +1,23 @@
 package utils
 
 import (
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func SignToken(userId, username, userRole string) (string, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	jwtExpiresIn := os.Getenv("JWT_EXPIRES_IN")
-
+// SignToken issues a token for the given session. mw.JWT rejects it once the session is revoked.
+func SignToken(secret []byte, lifetime time.Duration, userId, username, userRole, sessionId string) (string, error) {
 	claims := jwt.MapClaims{
 		"uid":  userId,
 		"user": username,
 		"role": userRole,
-	}
-
-	if jwtExpiresIn != "" {
-		duration, err := time.ParseDuration(jwtExpiresIn)
-		if err != nil {
-			return "", ErrorHandler(err, "internal error")
-		}
-		claims["exp"] = jwt.NewNumericDate(time.Now().Add(duration))
-	} else {
-		claims["exp"] = jwt.NewNumericDate(time.Now().Add(15 * time.Minute))
+		"sid":  sessionId,
+		"exp":  jwt.NewNumericDate(time.Now().Add(lifetime)),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString([]byte(jwtSecret))
+	signedToken, err := token.SignedString(secret)
 	if err != nil {
 		return "", ErrorHandler(err, "internal error")
 	}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// Problem is an RFC 9457 problem details body
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// RequestID lets a client quote the error so it can be found in the server logs
+	RequestID string `json:"request_id,omitempty"`
+	// Errors is an extension member listing every failed field of a validation problem
+	Errors ValidationErrors `json:"errors,omitempty"`
+}
+
+func WriteProblem(w http.ResponseWriter, status int, detail string) {
+	writeProblem(w, Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail})
+}
+
+// WriteValidationProblem reports every failed field at once so bulk clients can fix them in one go
+func WriteValidationProblem(w http.ResponseWriter, errs ValidationErrors) {
+	writeProblem(w, Problem{Type: "about:blank", Title: http.StatusText(http.StatusBadRequest), Status: http.StatusBadRequest, Detail: "validation failed", Errors: errs})
+}
+
+func writeProblem(w http.ResponseWriter, problem Problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// mw.RequestID has already put the id on the response
+	problem.RequestID = w.Header().Get("X-Request-ID")
+	w.WriteHeader(problem.Status)
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		slog.Error("JSON encoding error", "error", err)
+	}
+}
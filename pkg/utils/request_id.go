@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestID is the id mw.RequestID gave the request, empty outside a request
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(ContextKey("requestID")).(string)
+	return id
+}
+
+// Traceparent is the W3C trace context of the request with this server as the parent,
+// for outbound calls made on its behalf
+func Traceparent(ctx context.Context) string {
+	traceparent, _ := ctx.Value(ContextKey("traceparent")).(string)
+	return traceparent
+}
+
+// PropagateRequestID sets the correlation headers on an outbound request. With tracing on
+// the current span becomes the parent of the call.
+func PropagateRequestID(ctx context.Context, header http.Header) {
+	if id := RequestID(ctx); id != "" {
+		header.Set("X-Request-ID", id)
+	}
+	if trace.SpanFromContext(ctx).IsRecording() {
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+	} else if traceparent := Traceparent(ctx); traceparent != "" {
+		header.Set("traceparent", traceparent)
+	}
+}
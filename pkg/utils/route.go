@@ -0,0 +1,9 @@
+package utils
+
+import "context"
+
+// Route is the pattern the request matched, e.g. "GET /students/{id}", or "" when none did
+func Route(ctx context.Context) string {
+	route, _ := ctx.Value(ContextKey("route")).(string)
+	return route
+}
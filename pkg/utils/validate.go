@@ -0,0 +1,228 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// FieldError is one failed rule, reported against the field's JSON path, e.g. data[3].email
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) String() string {
+	return e.Field + ": " + e.Message
+}
+
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, e := range v {
+		messages[i] = e.String()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validate checks a struct against its `validate` tags. Supported rules, comma separated:
+// required, email, min=N, max=N (length for strings, value for numbers) and oneof=a b c.
+// Empty optional fields skip the other rules.
+func Validate(v any) ValidationErrors {
+	return validateStruct(reflect.ValueOf(v), "")
+}
+
+// ValidateSlice validates every item, prefixing fields with the item's position, e.g. data[3].email
+func ValidateSlice[T any](items []T, prefix string) ValidationErrors {
+	var errs ValidationErrors
+	for i, item := range items {
+		errs = append(errs, validateStruct(reflect.ValueOf(item), fmt.Sprintf("%s[%d].", prefix, i))...)
+	}
+	return errs
+}
+
+func validateStruct(v reflect.Value, prefix string) ValidationErrors {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fields, err := structRules(v.Type())
+	if err != nil {
+		// the server checks the models with CheckTags at startup, never let a bad tag pass unvalidated
+		slog.Error("Invalid validate tag", "error", err)
+		return ValidationErrors{{Field: strings.TrimSuffix(prefix, "."), Message: "cannot be validated"}}
+	}
+
+	var errs ValidationErrors
+	for _, field := range fields {
+		if message := checkRules(v.Field(field.index), field.rules); message != "" {
+			errs = append(errs, FieldError{Field: prefix + field.name, Message: message})
+		}
+	}
+	return errs
+}
+
+// CheckTags reports every malformed or unknown rule in the validate tags of the given structs
+func CheckTags(structs ...any) error {
+	var errs []error
+	for _, s := range structs {
+		t := reflect.TypeOf(s)
+		for t != nil && t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if t == nil || t.Kind() != reflect.Struct {
+			errs = append(errs, fmt.Errorf("validate: %T is not a struct", s))
+			continue
+		}
+		if _, err := structRules(t); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type rule struct {
+	name  string
+	param string
+	limit float64
+}
+
+type fieldRules struct {
+	index int
+	name  string
+	rules []rule
+}
+
+type parsedRules struct {
+	fields []fieldRules
+	err    error
+}
+
+// rulesCache holds the parsed tags per struct type, tags are only parsed the first time a type is validated
+var rulesCache sync.Map
+
+func structRules(t reflect.Type) ([]fieldRules, error) {
+	if cached, ok := rulesCache.Load(t); ok {
+		parsed := cached.(parsedRules)
+		return parsed.fields, parsed.err
+	}
+
+	var parsed parsedRules
+	var errs []error
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" || !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		rules, err := parseRules(tag)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("validate: %s.%s: %w", t.Name(), field.Name, err))
+			continue
+		}
+		parsed.fields = append(parsed.fields, fieldRules{index: i, name: name, rules: rules})
+	}
+	parsed.err = errors.Join(errs...)
+	rulesCache.Store(t, parsed)
+	return parsed.fields, parsed.err
+}
+
+func parseRules(tag string) ([]rule, error) {
+	var rules []rule
+	for _, raw := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(raw, "=")
+		r := rule{name: name, param: param}
+		switch name {
+		case "required", "email":
+		case "min", "max":
+			limit, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad %s rule %q", name, raw)
+			}
+			r.limit = limit
+		case "oneof":
+			if len(strings.Fields(param)) == 0 {
+				return nil, fmt.Errorf("oneof rule %q lists no values", raw)
+			}
+		default:
+			return nil, fmt.Errorf("unknown rule %q", raw)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// checkRules returns the message for the first rule the value fails, or ""
+func checkRules(value reflect.Value, rules []rule) string {
+	isZero := value.IsZero()
+	if value.Kind() == reflect.String {
+		isZero = strings.TrimSpace(value.String()) == ""
+	}
+
+	for _, rule := range rules {
+		if rule.name == "required" {
+			if isZero {
+				return "is required"
+			}
+			continue
+		}
+		if isZero {
+			return ""
+		}
+
+		switch rule.name {
+		case "email":
+			address, err := mail.ParseAddress(value.String())
+			if err != nil || address.Address != value.String() || !strings.Contains(address.Address[strings.LastIndex(address.Address, "@"):], ".") {
+				return "invalid format"
+			}
+		case "min", "max":
+			size, unit := measure(value)
+			if rule.name == "min" && size < rule.limit {
+				return fmt.Sprintf("must be at least %s%s", rule.param, unit)
+			}
+			if rule.name == "max" && size > rule.limit {
+				return fmt.Sprintf("must be at most %s%s", rule.param, unit)
+			}
+		case "oneof":
+			if !slices.Contains(strings.Fields(rule.param), fmt.Sprint(value.Interface())) {
+				return "must be one of " + strings.Join(strings.Fields(rule.param), ", ")
+			}
+		}
+	}
+	return ""
+}
+
+func measure(value reflect.Value) (float64, string) {
+	switch value.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(value.String())), " characters"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), ""
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(value.Len()), " items"
+	}
+	return 0, ""
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckTags(t *testing.T) {
+	type valid struct {
+		Name  string `json:"name" validate:"required,min=3,max=255"`
+		Email string `json:"email" validate:"email"`
+		Kind  string `json:"kind" validate:"oneof=a b"`
+	}
+	type unknownRule struct {
+		Name string `validate:"required,maxlen=5"`
+	}
+	type badLimit struct {
+		Name string `validate:"max=ten"`
+	}
+	type emptyOneOf struct {
+		Kind string `validate:"oneof="`
+	}
+
+	if err := CheckTags(valid{}, &valid{}); err != nil {
+		t.Errorf("CheckTags(valid) = %v", err)
+	}
+	for _, s := range []any{unknownRule{}, badLimit{}, emptyOneOf{}, "not a struct"} {
+		if err := CheckTags(s); err == nil {
+			t.Errorf("CheckTags(%T) accepted a bad tag", s)
+		}
+	}
+}
+
+func TestValidateBadTag(t *testing.T) {
+	type item struct {
+		Name string `json:"name" validate:"required,max=ten"`
+	}
+	// used to panic
+	errs := ValidateSlice([]item{{Name: "x"}}, "data")
+	if len(errs) != 1 || errs[0].Field != "data[0]" || !strings.Contains(errs[0].Message, "cannot be validated") {
+		t.Errorf("ValidateSlice = %v, want the item reported as not validatable", errs)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	type exec struct {
+		Username string `json:"username" validate:"required,min=3,max=5"`
+		Email    string `json:"email" validate:"email"`
+		Role     string `json:"role" validate:"oneof=admin exec"`
+	}
+	errs := Validate(exec{Username: "ab", Email: "jane@", Role: "root"})
+	want := []string{"username: must be at least 3 characters", "email: invalid format", "role: must be one of admin, exec"}
+	if len(errs) != len(want) {
+		t.Fatalf("Validate = %v, want %v", errs, want)
+	}
+	for i := range want {
+		if errs[i].String() != want[i] {
+			t.Errorf("error %d = %q, want %q", i, errs[i], want[i])
+		}
+	}
+	if errs := Validate(exec{Username: "jane"}); len(errs) != 0 {
+		t.Errorf("Validate skipped optional fields = %v, want no errors", errs)
+	}
+}
+
+func TestWriteValidationProblem(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.Header().Set("X-Request-ID", "req-1")
+	WriteValidationProblem(rec, ValidationErrors{{Field: "data[0].email", Message: "invalid format"}})
+
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("status %d, Content-Type %q; want a 400 problem+json", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var problem Problem
+	if err := json.NewDecoder(rec.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+	want := Problem{Type: "about:blank", Title: "Bad Request", Status: 400, Detail: "validation failed", RequestID: "req-1",
+		Errors: ValidationErrors{{Field: "data[0].email", Message: "invalid format"}}}
+	if !reflect.DeepEqual(problem, want) {
+		t.Errorf("problem = %+v, want %+v", problem, want)
+	}
+}